@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Mortifer97/WASAText/service/backup"
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// defaultBackupDirectory is the default directory of the snapshots, the same of webapi.
+const defaultBackupDirectory = "/tmp/wasatext-backups"
+
+func createBackup(ctx context.Context, db database.AppDatabase, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	directory := flags.String("dir", defaultBackupDirectory, "directory of the snapshots")
+	keep := flags.Int("keep", 7, "number of snapshots to keep (0 keeps all)")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	manager, err := backup.NewManager(db, *directory, *keep)
+	if err != nil {
+		return err
+	}
+	snapshot, err := manager.Create(ctx)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "snapshot %s written (%s)\n", snapshot.Path, formatBytes(snapshot.Size))
+	return nil
+}
+
+func listBackups(ctx context.Context, _ database.AppDatabase, args []string) error {
+	flags := flag.NewFlagSet("backups", flag.ContinueOnError)
+	directory := flags.String("dir", defaultBackupDirectory, "directory of the snapshots")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 {
+		return errUsage
+	}
+	snapshots, err := backup.List(*directory)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "SNAPSHOT\tCREATED\tSIZE")
+	for _, snapshot := range snapshots {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", snapshot.Name, snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			formatBytes(snapshot.Size))
+	}
+	return w.Flush()
+}
+
+func restoreBackup(ctx context.Context, _ database.AppDatabase, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	directory := flags.String("dir", defaultBackupDirectory, "directory of the snapshots, for snapshots given by name")
+	yes := flags.Bool("yes", false, "confirm that webapi is stopped and the database can be replaced")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	if dbDriver != "sqlite3" {
+		return errors.New("snapshots are available only for SQLite databases")
+	}
+	snapshot := flags.Arg(0)
+	if !strings.ContainsRune(snapshot, filepath.Separator) {
+		snapshot = filepath.Join(*directory, snapshot)
+	}
+	if _, err := os.Stat(snapshot); err != nil {
+		return fmt.Errorf("snapshot not found: %w", err)
+	}
+	if !*yes {
+		return errors.New("database not restored: stop webapi, then confirm with -yes")
+	}
+
+	previous, err := backup.Restore(snapshot, dbFilename)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "database %s restored from %s\n", dbFilename, snapshot)
+	if previous != "" {
+		_, _ = fmt.Fprintf(out, "the previous database was moved to %s\n", previous)
+	}
+	return nil
+}
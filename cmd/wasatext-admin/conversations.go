@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+func showConversation(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseId(args)
+	if err != nil {
+		return err
+	}
+	conversation, err := db.GetConversationById(ctx, id)
+	if err != nil {
+		return err
+	}
+	members, err := db.GetGroupMembers(ctx, id)
+	if err != nil {
+		return err
+	}
+	problems, err := db.CheckConversations(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "id\t%d\n", conversation.ConversationId)
+	_, _ = fmt.Fprintf(w, "name\t%s\n", conversation.Name)
+	_, _ = fmt.Fprintf(w, "type\t%s\n", conversation.Type)
+	_, _ = fmt.Fprintf(w, "members\t%s\n", strings.Join(members, ", "))
+	if conversation.LastMessage != nil {
+		_, _ = fmt.Fprintf(w, "last message\t%d (%s)\n", conversation.LastMessage.MessageID,
+			conversation.LastMessage.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	if conversation.MessageTTL > 0 {
+		_, _ = fmt.Fprintf(w, "messages disappear after\t%ds\n", conversation.MessageTTL)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		_, _ = fmt.Fprintln(out)
+		return printProblems(problems)
+	}
+	return nil
+}
+
+func checkConversations(ctx context.Context, db database.AppDatabase, args []string) error {
+	id, err := parseId(args)
+	if err != nil {
+		return err
+	}
+	problems, err := db.CheckConversations(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		_, _ = fmt.Fprintln(out, "no problems found")
+		return nil
+	}
+	return printProblems(problems)
+}
+
+func repairConversations(ctx context.Context, db database.AppDatabase, args []string) error {
+	id, err := parseId(args)
+	if err != nil {
+		return err
+	}
+	repaired, err := db.RepairConversations(ctx, id)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "%d problems repaired\n", len(repaired))
+	if len(repaired) > 0 {
+		if err := printProblems(repaired); err != nil {
+			return err
+		}
+	}
+
+	// What is left needs a decision of the operator
+	left, err := db.CheckConversations(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(left) > 0 {
+		_, _ = fmt.Fprintf(out, "\n%d problems left\n", len(left))
+		return printProblems(left)
+	}
+	return nil
+}
+
+func printProblems(problems []database.ConversationProblem) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CONVERSATION\tPROBLEM\tREPAIRABLE\tDETAIL")
+	for _, problem := range problems {
+		repairable := "no"
+		if problem.Repairable {
+			repairable = "yes"
+		}
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", problem.ConversationId, problem.Kind, repairable, problem.Detail)
+	}
+	return w.Flush()
+}
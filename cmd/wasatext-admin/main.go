@@ -0,0 +1,213 @@
+/*
+Wasatext-admin is the tool for the operators of a WASAText instance. It works directly on the database, SQLite or
+PostgreSQL, through the same database package of webapi.
+
+Usage:
+
+	wasatext-admin [-db <file>] [-driver sqlite3|postgres] [-dsn <dsn>] <command> [arguments]
+
+The commands are:
+
+	users [-limit <n>] [-offset <n>]
+		Lists the users.
+	search <text>
+		Searches the users by username or display name.
+	rename <user> <username>
+		Changes the username of a user, given by id or username.
+	delete-user -yes <user>
+		Deletes a user like the user would do from the app: their messages stay, attributed to "Deleted user".
+	conversation <id>
+		Shows a conversation, its members and its problems.
+	check [<id>]
+		Looks for problems in a conversation, or in all of them.
+	repair [<id>]
+		Fixes the problems that can be fixed automatically, such as a wrong last message.
+	stats
+		Shows how many users, conversations and messages there are, and the size of the database.
+	migrate
+		Updates the database schema to the version of this program.
+	vacuum
+		Rebuilds the database file to give back the space of deleted data.
+	backup [-dir <directory>] [-keep <n>]
+		Takes a verified snapshot of the database, like webapi does on schedule, keeping only the last n snapshots.
+	backups [-dir <directory>]
+		Lists the snapshots.
+	restore [-dir <directory>] -yes <snapshot>
+		Replaces the database with a snapshot, given by name or path, after verifying it. The current database is kept
+		next to it. webapi must be stopped first: the database is not replaced while it is in use.
+
+Snapshots are available only for SQLite: PostgreSQL databases are backed up with pg_dump.
+
+Like webapi, every command except restore updates the schema of the database first. Commands that change the database
+should not be run while webapi is writing to it.
+
+Return values (exit codes):
+
+	0
+		The command was successful
+
+	> 0
+		The command failed
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// command is a sub-command of wasatext-admin; run receives the arguments after the name of the command. Offline
+// commands work on the database file and receive a nil db.
+type command struct {
+	usage   string
+	run     func(ctx context.Context, db database.AppDatabase, args []string) error
+	offline bool
+}
+
+var commands = map[string]command{
+	"users":        {"[-limit <n>] [-offset <n>]", listUsers, false},
+	"search":       {"<text>", searchUsers, false},
+	"rename":       {"<user> <username>", renameUser, false},
+	"delete-user":  {"-yes <user>", deleteUser, false},
+	"conversation": {"<id>", showConversation, false},
+	"check":        {"[<id>]", checkConversations, false},
+	"repair":       {"[<id>]", repairConversations, false},
+	"stats":        {"", showStatistics, false},
+	"migrate":      {"", migrate, false},
+	"vacuum":       {"", vacuum, false},
+	"backup":       {"[-dir <directory>] [-keep <n>]", createBackup, false},
+	"backups":      {"[-dir <directory>]", listBackups, true},
+	"restore":      {"[-dir <directory>] -yes <snapshot>", restoreBackup, true},
+}
+
+// dbFilename is the SQLite database file, set by the -db flag.
+var dbFilename string
+
+// dbDriver and dbDSN select the database engine and the PostgreSQL server, like DB.Driver and DB.DSN of webapi.
+var dbDriver, dbDSN string
+
+// out is where the commands write their results.
+var out io.Writer = os.Stdout
+
+// errUsage is returned by commands called with wrong arguments.
+var errUsage = errors.New("wrong arguments")
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	flag.StringVar(&dbFilename, "db", "/tmp/decaf.db", "SQLite database file")
+	flag.StringVar(&dbDriver, "driver", "sqlite3", "database engine: sqlite3 or postgres")
+	flag.StringVar(&dbDSN, "dsn", "", "PostgreSQL data source name, for the postgres driver")
+	flag.Usage = usage
+	flag.Parse()
+	if dbDriver != "sqlite3" && dbDriver != "postgres" {
+		return fmt.Errorf("unknown database driver %q", dbDriver)
+	}
+	if flag.NArg() == 0 {
+		usage()
+		return errUsage
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command %q", flag.Arg(0))
+	}
+
+	// Interrupting the command (Ctrl-C) cancels the query in progress
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var db database.AppDatabase
+	if !cmd.offline {
+		var closeDB func()
+		var err error
+		db, closeDB, err = openDatabase(ctx)
+		if err != nil {
+			return err
+		}
+		defer closeDB()
+	}
+	err := cmd.run(ctx, db, flag.Args()[1:])
+	if errors.Is(err, errUsage) {
+		_, _ = fmt.Fprintf(os.Stderr, "usage: wasatext-admin [flags] %s %s\n", flag.Arg(0), cmd.usage)
+	}
+	return err
+}
+
+// openDatabase opens the database selected by the flags, and returns the function closing it. SQLite is opened with
+// the same settings as webapi, so that the commands wait for the locks of a running server.
+func openDatabase(ctx context.Context) (database.AppDatabase, func(), error) {
+	if dbDriver == "postgres" {
+		dbconn, err := sql.Open("postgres", dbDSN)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening database: %w", err)
+		}
+		db, err := database.NewPostgres(dbconn)
+		if err != nil {
+			_ = dbconn.Close()
+			return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+		}
+		return db, func() { _ = dbconn.Close() }, nil
+	}
+	writer, reader, err := database.OpenSQLite(ctx, dbFilename, database.DefaultSQLiteConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening database: %w", err)
+	}
+	closeDB := func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	}
+	db, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		closeDB()
+		return nil, nil, fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	return db, closeDB, nil
+}
+
+func usage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: wasatext-admin [flags] <command> [arguments]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, _ = fmt.Fprintf(os.Stderr, "  %s %s\n", name, commands[name].usage)
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// parseId parses the optional conversation id of check and repair: 0 when missing.
+func parseId(args []string) (int64, error) {
+	switch len(args) {
+	case 0:
+		return 0, nil
+	case 1:
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil || id <= 0 {
+			return 0, fmt.Errorf("invalid id %q", args[0])
+		}
+		return id, nil
+	default:
+		return 0, errUsage
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+func showStatistics(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	stats, err := db.GetStatistics(ctx)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, row := range []struct {
+		name  string
+		value int64
+	}{
+		{"users", stats.Users},
+		{"direct conversations", stats.DirectConversations},
+		{"groups", stats.Groups},
+		{"messages", stats.Messages},
+		{"photos", stats.Photos},
+		{"comments", stats.Comments},
+		{"scheduled messages", stats.ScheduledMessages},
+		{"imported chats", stats.ImportedChats},
+	} {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t\n", row.name, row.value)
+	}
+	_, _ = fmt.Fprintf(w, "database size\t%s\t\n", formatBytes(stats.DatabaseSize))
+	_, _ = fmt.Fprintf(w, "free space\t%s\t\n", formatBytes(stats.FreeSpace))
+	return w.Flush()
+}
+
+// migrate does nothing by itself: the schema is updated when the database is opened, before every command.
+func migrate(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if err := db.Ping(ctx); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(out, "database schema up to date")
+	return nil
+}
+
+func vacuum(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	before, err := db.GetStatistics(ctx)
+	if err != nil {
+		return err
+	}
+	if err := db.Vacuum(ctx); err != nil {
+		return err
+	}
+	after, err := db.GetStatistics(ctx)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "database size %s -> %s\n", formatBytes(before.DatabaseSize), formatBytes(after.DatabaseSize))
+	return nil
+}
+
+// formatBytes returns a size in bytes in a readable unit.
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, exponent := float64(size)/unit, 0
+	for value >= unit && exponent < 3 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGT"[exponent])
+}
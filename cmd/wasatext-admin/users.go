@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+func listUsers(ctx context.Context, db database.AppDatabase, args []string) error {
+	flags := flag.NewFlagSet("users", flag.ContinueOnError)
+	limit := flags.Int("limit", 100, "maximum number of users")
+	offset := flags.Int("offset", 0, "number of users to skip")
+	if err := flags.Parse(args); err != nil || flags.NArg() > 0 || *limit <= 0 || *offset < 0 {
+		return errUsage
+	}
+	users, err := db.ListUsers(ctx, *limit, *offset)
+	if err != nil {
+		return err
+	}
+	return printUsers(users)
+}
+
+func searchUsers(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	users, err := db.SearchUsersByUsername(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printUsers(users)
+}
+
+func printUsers(users []database.User) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tUSERNAME\tDISPLAY NAME")
+	for _, user := range users {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", user.UserId, user.Name, user.DisplayName)
+	}
+	return w.Flush()
+}
+
+func renameUser(ctx context.Context, db database.AppDatabase, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	user, err := findUser(ctx, db, args[0])
+	if err != nil {
+		return err
+	}
+	username := args[1]
+	// Same rules of the API
+	if len(username) < 3 || len(username) > 16 {
+		return fmt.Errorf("the username must be 3 to 16 characters long")
+	}
+	existing, err := db.GetUserByName(ctx, username)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("username %q is already used by user %d", username, existing.UserId)
+	}
+	if err := db.UpdateUsername(ctx, user.UserId, username); err != nil {
+		return fmt.Errorf("renaming user: %w", err)
+	}
+	_, _ = fmt.Fprintf(out, "user %d renamed from %q to %q\n", user.UserId, user.Name, username)
+	return nil
+}
+
+func deleteUser(ctx context.Context, db database.AppDatabase, args []string) error {
+	flags := flag.NewFlagSet("delete-user", flag.ContinueOnError)
+	yes := flags.Bool("yes", false, "confirm the deletion")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+	user, err := findUser(ctx, db, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("user %d (%s) not deleted: confirm with -yes", user.UserId, user.Name)
+	}
+
+	// The archives of the data exports are files, not removed with the user
+	jobs, err := db.GetExportJobs(ctx, user.UserId)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteUser(ctx, user.UserId); err != nil {
+		return fmt.Errorf("deleting user: %w", err)
+	}
+	for _, job := range jobs {
+		if job.FilePath == "" {
+			continue
+		}
+		if err := os.Remove(job.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			_, _ = fmt.Fprintf(os.Stderr, "warning: export archive %s not removed: %v\n", job.FilePath, err)
+		}
+	}
+	_, _ = fmt.Fprintf(out, "user %d (%s) deleted\n", user.UserId, user.Name)
+	return nil
+}
+
+// findUser returns the user with the given id or, if it is not a number, username.
+func findUser(ctx context.Context, db database.AppDatabase, idOrName string) (database.User, error) {
+	if id, err := strconv.ParseInt(idOrName, 10, 64); err == nil {
+		if id <= 0 {
+			return database.User{}, fmt.Errorf("invalid user id %d", id)
+		}
+		user, err := db.GetUserById(ctx, id)
+		if err != nil {
+			return database.User{}, fmt.Errorf("user %d not found", id)
+		}
+		return user, nil
+	}
+	user, err := db.GetUserByName(ctx, idOrName)
+	if err != nil {
+		return database.User{}, err
+	}
+	if user == nil {
+		return database.User{}, fmt.Errorf("user %q not found", idOrName)
+	}
+	return *user, nil
+}
@@ -0,0 +1,137 @@
+/*
+Wasatext-import imports the history of a chat exported from WhatsApp or Telegram into a WASAText database, in a new
+conversation. Importing the same chat again only adds the messages that are missing.
+
+Usage:
+
+	wasatext-import [flags] <export>
+
+The export is a WhatsApp `.txt` file or `.zip` archive, a Telegram `result.json` file, or a directory containing one
+of them together with the attachments.
+
+The flags are:
+
+	-db <file>
+		The SQLite database file (default: the same of webapi).
+	-source <whatsapp|telegram>
+		The format of the export, when it cannot be detected from the file name.
+	-title <name>
+		The name of the new conversation (default: the name of the chat in the export).
+	-user <name>=<username>
+		Maps a participant to an existing user; can be repeated. Only these users are reused: every other participant
+		gets a new user, with a username derived from their name and a numeric suffix if it is taken. Importing the
+		chat again maps the participants to the same users.
+	-timezone <zone>
+		The time zone of WhatsApp timestamps, such as Europe/Rome (default: the local time zone).
+	-date-order <dmy|mdy>
+		The order of day and month in WhatsApp dates (default: detected from the dates).
+
+Return values (exit codes):
+
+	0
+		The chat was imported
+
+	> 0
+		The import failed; nothing was imported
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/chatimport"
+	"github.com/Mortifer97/WASAText/service/database"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// userMapping collects the repeated -user flags.
+type userMapping map[string]string
+
+func (m userMapping) String() string {
+	return fmt.Sprint(map[string]string(m))
+}
+
+func (m userMapping) Set(value string) error {
+	separator := strings.LastIndex(value, "=")
+	if separator <= 0 || separator == len(value)-1 {
+		return fmt.Errorf("expected <name>=<username>, got %q", value)
+	}
+	m[value[:separator]] = value[separator+1:]
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var opts chatimport.Options
+	users := userMapping{}
+	dbFilename := flag.String("db", "/tmp/decaf.db", "SQLite database file")
+	flag.StringVar(&opts.Source, "source", "", "format of the export: whatsapp or telegram (default: detected)")
+	flag.StringVar(&opts.Title, "title", "", "name of the new conversation (default: from the export)")
+	flag.Var(users, "user", "map a participant to an existing user, as <name>=<username> (can be repeated)")
+	timezone := flag.String("timezone", "", "time zone of WhatsApp timestamps (default: local)")
+	flag.StringVar(&opts.DateOrder, "date-order", "", "order of day and month in WhatsApp dates: dmy or mdy (default: detected)")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		return fmt.Errorf("expected one export, got %d", flag.NArg())
+	}
+	opts.Users = users
+	if *timezone != "" {
+		location, err := time.LoadLocation(*timezone)
+		if err != nil {
+			return fmt.Errorf("loading time zone: %w", err)
+		}
+		opts.Location = location
+	}
+
+	fsys, name, closeExport, err := chatimport.Open(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = closeExport() }()
+	chat, err := chatimport.Load(fsys, name, opts)
+	if err != nil {
+		return fmt.Errorf("reading export: %w", err)
+	}
+
+	// Interrupting the command (Ctrl-C) cancels the import
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// Same settings as webapi, so that the import waits for the locks of a running server
+	writer, reader, err := database.OpenSQLite(ctx, *dbFilename, database.DefaultSQLiteConfig)
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	}()
+	db, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+	result, err := chatimport.Import(ctx, db, fsys, chat, opts)
+	if err != nil {
+		return err
+	}
+	action := "updated"
+	if result.Created {
+		action = "created"
+	}
+	fmt.Printf("conversation %d %q %s: %d messages imported, %d already present\n", //nolint:forbidigo
+		result.ConversationId, chat.Title, action, result.Imported, result.Skipped)
+	return nil
+}
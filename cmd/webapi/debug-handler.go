@@ -0,0 +1,24 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/Mortifer97/WASAText/service/metrics"
+)
+
+// debugHandler returns the handler of the debug server: the profiler (/debug/pprof/), the expvar variables
+// (/debug/vars) and the metrics of registry in the Prometheus format (/metrics). It is not exposed by the API server,
+// so that they are only reachable from where the debug address is.
+func debugHandler(registry *metrics.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", registry.Handler())
+	return mux
+}
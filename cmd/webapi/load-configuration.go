@@ -24,10 +24,41 @@ type WebAPIConfiguration struct {
 		WriteTimeout    time.Duration `conf:"default:5s"`
 		ShutdownTimeout time.Duration `conf:"default:5s"`
 	}
+	Chat struct {
+		MaxPinnedMessages         int           `conf:"default:5"`
+		ExpiredMessagesInterval   time.Duration `conf:"default:1m"`
+		ScheduledMessagesInterval time.Duration `conf:"default:10s"`
+	}
+	Export struct {
+		Directory  string        `conf:"default:/tmp/wasatext-exports"`
+		Expiration time.Duration `conf:"default:24h"`
+	}
+	Backup struct {
+		Directory string        `conf:"default:/tmp/wasatext-backups"`
+		Interval  time.Duration `conf:"default:24h"`
+		Retention int           `conf:"default:7"`
+	}
+	Admin struct {
+		Token string `conf:"mask"`
+	}
 	Debug bool
 	DB    struct {
+		// Driver is the database engine: sqlite3 (using Filename) or postgres (using DSN)
+		Driver   string `conf:"default:sqlite3"`
 		Filename string `conf:"default:/tmp/decaf.db"` //linux
 		//Filename string `conf:"default:demo/decaf.db"` //windows
+		DSN string `conf:"mask"`
+		// QueryTimeout is how long the database calls of a request can last before being cancelled (0 means no limit)
+		QueryTimeout time.Duration `conf:"default:5s"`
+		// The connections to the SQLite database are set up with these, see database.SQLiteConfig: by default WAL,
+		// foreign keys enforced, a single writer and a pool of readers
+		JournalMode  string        `conf:"default:WAL"`
+		Synchronous  string        `conf:"default:NORMAL"`
+		BusyTimeout  time.Duration `conf:"default:5s"`
+		ForeignKeys  bool          `conf:"default:true"`
+		MaxOpenConns int           `conf:"default:1"`
+		ReadPool     bool          `conf:"default:true"`
+		MaxReadConns int           `conf:"default:0"`
 	}
 }
 
@@ -2,7 +2,8 @@
 Webapi is the executable for the main web server.
 It builds a web server around APIs from `service/api`.
 Webapi connects to external resources needed (database) and starts two web servers: the API web server, and the debug.
-Everything is served via the API web server, except debug variables (/debug/vars) and profiler infos (pprof).
+Everything is served via the API web server, except debug variables (/debug/vars), profiler infos (pprof) and the
+metrics in the Prometheus format (/metrics), served by the debug web server. An empty debug address disables it.
 
 Usage:
 
@@ -29,6 +30,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -37,7 +39,9 @@ import (
 	"github.com/Mortifer97/WASAText/service/api"
 	"github.com/Mortifer97/WASAText/service/database"
 	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/Mortifer97/WASAText/service/metrics"
 	"github.com/ardanlabs/conf"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
 )
@@ -56,9 +60,9 @@ func main() {
 // * creates and configure the logger
 // * connects to any external resources (like databases, authenticators, etc.)
 // * creates an instance of the service/api package
-// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers)
+// * starts the principal web server (using the service/api.Router.Handler() for HTTP handlers), and the debug one
 // * waits for any termination event: SIGTERM signal (UNIX), non-recoverable server error, etc.
-// * closes the principal web server
+// * closes the principal and the debug web servers
 func run() error {
 	rand.Seed(globaltime.Now().UnixNano())
 	// Load Configuration and defaults
@@ -83,20 +87,70 @@ func run() error {
 
 	// Start Database
 	logger.Println("initializing database support")
-	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
-	if err != nil {
-		logger.WithError(err).Error("error opening SQLite DB")
-		return fmt.Errorf("opening SQLite: %w", err)
+	var dbconn, dbreader *sql.DB
+	var db database.AppDatabase
+	switch cfg.DB.Driver {
+	case "sqlite3":
+		dbconn, dbreader, err = database.OpenSQLite(context.Background(), cfg.DB.Filename, database.SQLiteConfig{
+			JournalMode:  cfg.DB.JournalMode,
+			Synchronous:  cfg.DB.Synchronous,
+			BusyTimeout:  cfg.DB.BusyTimeout,
+			ForeignKeys:  cfg.DB.ForeignKeys,
+			MaxOpenConns: cfg.DB.MaxOpenConns,
+			ReadPool:     cfg.DB.ReadPool,
+			MaxReadConns: cfg.DB.MaxReadConns,
+		})
+		if err != nil {
+			logger.WithError(err).Error("error opening SQLite DB")
+			return fmt.Errorf("opening SQLite: %w", err)
+		}
+	case "postgres":
+		dbconn, err = sql.Open("postgres", cfg.DB.DSN)
+		if err != nil {
+			logger.WithError(err).Error("error opening PostgreSQL DB")
+			return fmt.Errorf("opening PostgreSQL: %w", err)
+		}
+		// Snapshots are taken with the SQLite online backup: PostgreSQL is backed up with pg_dump
+		cfg.Backup.Interval = 0
+	default:
+		return fmt.Errorf("unknown database driver %q", cfg.DB.Driver)
 	}
 	defer func() {
 		logger.Debug("database stopping")
+		// The read only connections are closed first: the last connection to close checkpoints the WAL file
+		if dbreader != nil {
+			_ = dbreader.Close()
+		}
 		_ = dbconn.Close()
 	}()
-	db, err := database.New(dbconn)
+	if cfg.DB.Driver == "postgres" {
+		db, err = database.NewPostgres(dbconn)
+	} else {
+		db, err = database.NewSQLite(dbconn, dbreader)
+	}
 	if err != nil {
 		logger.WithError(err).Error("error creating AppDatabase")
 		return fmt.Errorf("creating AppDatabase: %w", err)
 	}
+	if cfg.DB.Driver == "sqlite3" {
+		report, err := database.ReportSQLite(context.Background(), dbconn, dbreader)
+		if err != nil {
+			logger.WithError(err).Error("error reading SQLite settings")
+			return fmt.Errorf("reading SQLite settings: %w", err)
+		}
+		logger.WithFields(logrus.Fields{
+			"journal_mode":   report.JournalMode,
+			"synchronous":    report.Synchronous,
+			"busy_timeout":   report.BusyTimeout,
+			"foreign_keys":   report.ForeignKeys,
+			"max_open_conns": report.MaxOpenConns,
+			"read_pool":      report.ReadPool,
+			"max_read_conns": report.MaxReadConns,
+		}).Info("SQLite database ready")
+		if report.ForeignKeyViolations > 0 {
+			logger.Warnf("%d rows reference missing rows (see PRAGMA foreign_key_check): `wasatext-admin repair` fixes the ones of the conversations", report.ForeignKeyViolations)
+		}
+	}
 
 	// Start (main) API server
 	logger.Info("initializing API server")
@@ -107,13 +161,27 @@ func run() error {
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
 	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// buffered channel so the goroutines can exit if we don't collect these errors.
+	serverErrors := make(chan error, 2)
+
+	// The metrics of the API are exported by the debug server
+	registry := metrics.NewRegistry()
 
 	// Create the API router
 	apirouter, err := api.New(api.Config{
-		Logger:   logger,
-		Database: db,
+		Logger:                    logger,
+		Database:                  db,
+		MaxPinnedMessages:         cfg.Chat.MaxPinnedMessages,
+		ExpiredMessagesInterval:   cfg.Chat.ExpiredMessagesInterval,
+		ScheduledMessagesInterval: cfg.Chat.ScheduledMessagesInterval,
+		ExportDirectory:           cfg.Export.Directory,
+		ExportExpiration:          cfg.Export.Expiration,
+		BackupDirectory:           cfg.Backup.Directory,
+		BackupInterval:            cfg.Backup.Interval,
+		BackupRetention:           cfg.Backup.Retention,
+		AdminToken:                cfg.Admin.Token,
+		QueryTimeout:              cfg.DB.QueryTimeout,
+		Metrics:                   registry,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
@@ -130,6 +198,11 @@ func run() error {
 	// Apply CORS policy
 	router = applyCORSHandler(router)
 
+	// The context of every request derives from this one, so that the database calls still running when the
+	// graceful shutdown times out can be cancelled.
+	requestsCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+
 	// Create the API server
 	apiserver := http.Server{
 		Addr:              cfg.Web.APIHost,
@@ -137,6 +210,9 @@ func run() error {
 		ReadTimeout:       cfg.Web.ReadTimeout,
 		ReadHeaderTimeout: cfg.Web.ReadTimeout,
 		WriteTimeout:      cfg.Web.WriteTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return requestsCtx
+		},
 	}
 
 	// Start the service listening for requests in a separate goroutine
@@ -146,6 +222,20 @@ func run() error {
 		logger.Infof("stopping API server")
 	}()
 
+	// Start the debug server, if enabled
+	debugserver := http.Server{
+		Addr:              cfg.Web.DebugHost,
+		Handler:           debugHandler(registry),
+		ReadHeaderTimeout: cfg.Web.ReadTimeout,
+	}
+	if cfg.Web.DebugHost != "" {
+		go func() {
+			logger.Infof("debug server listening on %s", debugserver.Addr)
+			serverErrors <- debugserver.ListenAndServe()
+			logger.Infof("stopping debug server")
+		}()
+	}
+
 	// Waiting for shutdown signal or POSIX signals
 	select {
 	case err := <-serverErrors:
@@ -169,9 +259,15 @@ func run() error {
 		err = apiserver.Shutdown(ctx)
 		if err != nil {
 			logger.WithError(err).Warning("error during graceful shutdown of HTTP server")
+			cancelRequests()
 			err = apiserver.Close()
 		}
 
+		// The debug server is stopped at once: a profile being taken is not worth waiting for.
+		if cfg.Web.DebugHost != "" {
+			_ = debugserver.Close()
+		}
+
 		// Log the status of this shutdown.
 		switch {
 
@@ -4,16 +4,17 @@ go 1.17
 
 require (
 	github.com/ardanlabs/conf v1.5.0
+	github.com/felixge/httpsnoop v1.0.4
 	github.com/gofrs/uuid v4.4.0+incompatible
 	github.com/gorilla/handlers v1.5.2
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.9.0
 	github.com/mattn/go-sqlite3 v1.14.23
 	github.com/sirupsen/logrus v1.9.3
 	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
-	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/google/go-cmp v0.5.8 // indirect
 	github.com/kr/pretty v0.1.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Handler per cancellare l'account dell'utente.
+// I suoi messaggi e commenti restano nelle conversazioni come "Deleted user" e lo username torna disponibile.
+// Si collega a database/account-db.go.
+func (rt *_router) deleteAccount(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	// Solo il proprietario può cancellare il proprio account
+	if userId != ctx.UserID {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	if _, err := rt.db.GetUserById(ctx.Context, userId); err != nil {
+		http.Error(w, "Utente non trovato", http.StatusNotFound)
+		return
+	}
+	exports, err := rt.db.GetExportJobs(ctx.Context, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero esportazioni")
+		http.Error(w, "Errore cancellazione account", http.StatusInternalServerError)
+		return
+	}
+	if err := rt.db.DeleteUser(ctx.Context, userId); err != nil {
+		ctx.Logger.WithError(err).Error("errore cancellazione account")
+		http.Error(w, "Errore cancellazione account", http.StatusInternalServerError)
+		return
+	}
+	rt.presence.forget(userId)
+	for _, job := range exports {
+		rt.removeExportArchive(job)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestDeleteAccountOnlyByOwner(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.login(t, "alice")
+	bobby := s.login(t, "bobby")
+
+	if code := s.do(t, http.MethodDelete, "/users/"+strconv.FormatInt(bobby, 10), alice, nil, nil); code != http.StatusForbidden {
+		t.Fatalf("deleting another account: got status %d, want %d", code, http.StatusForbidden)
+	}
+	if _, err := s.db.GetUserById(context.Background(), bobby); err != nil {
+		t.Fatalf("account deleted by another user: %v", err)
+	}
+	if code := s.do(t, http.MethodDelete, "/users/"+strconv.FormatInt(bobby, 10), bobby, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("deleting own account: got status %d, want %d", code, http.StatusNoContent)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"github.com/Mortifer97/WASAText/service/api/reqcontext"
 	"github.com/gofrs/uuid"
 	"github.com/julienschmidt/httprouter"
@@ -31,6 +32,15 @@ func (rt *_router) wrap(fn httpRouterHandler) func(http.ResponseWriter, *http.Re
 			"remote-ip": r.RemoteAddr,
 		})
 
+		// The database calls of the request are cancelled when the client goes away or the query timeout expires
+		var cancel context.CancelFunc
+		if rt.queryTimeout > 0 {
+			ctx.Context, cancel = context.WithTimeout(r.Context(), rt.queryTimeout)
+		} else {
+			ctx.Context, cancel = context.WithCancel(r.Context())
+		}
+		defer cancel()
+
 		// Call the next handler in chain (usually, the handler function for the path)
 		fn(w, r, ps, ctx)
 	}
@@ -1,10 +1,13 @@
 package api
 
 import (
+	"crypto/subtle"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/globaltime"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -41,6 +44,41 @@ func (rt *_router) Handler() http.Handler {
 	rt.router.PUT("/users/:userId/groups/:groupId/photo", rt.wrap(rt.AuthHandler(rt.setGroupPhoto)))
 	rt.router.GET("/users/:userId/search", rt.wrap(rt.AuthHandler(rt.searchUsers)))
 	rt.router.GET("/users/:userId/groups/:groupId/members/", rt.wrap(rt.AuthHandler(rt.getGroupMembers)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/messages/:messageId/pin", rt.wrap(rt.AuthHandler(rt.pinMessage)))
+	rt.router.DELETE("/users/:userId/conversations/:conversationId/messages/:messageId/pin", rt.wrap(rt.AuthHandler(rt.unpinMessage)))
+	rt.router.GET("/users/:userId/conversations/:conversationId/pins", rt.wrap(rt.AuthHandler(rt.getPinnedMessages)))
+	rt.router.PUT("/users/:userId/starred/:messageId", rt.wrap(rt.AuthHandler(rt.starMessage)))
+	rt.router.DELETE("/users/:userId/starred/:messageId", rt.wrap(rt.AuthHandler(rt.unstarMessage)))
+	rt.router.GET("/users/:userId/starred", rt.wrap(rt.AuthHandler(rt.getStarredMessages)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/read", rt.wrap(rt.AuthHandler(rt.markConversationRead)))
+	rt.router.DELETE("/users/:userId/conversations/:conversationId/read", rt.wrap(rt.AuthHandler(rt.markConversationUnread)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/settings", rt.wrap(rt.AuthHandler(rt.setConversationSettings)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/timer", rt.wrap(rt.AuthHandler(rt.setMessageTimer)))
+	rt.router.POST("/users/:userId/conversations/:conversationId/typing", rt.wrap(rt.AuthHandler(rt.startTyping)))
+	rt.router.DELETE("/users/:userId/conversations/:conversationId/typing", rt.wrap(rt.AuthHandler(rt.stopTyping)))
+	rt.router.DELETE("/users/:userId", rt.wrap(rt.AuthHandler(rt.deleteAccount)))
+	rt.router.POST("/users/:userId/exports/", rt.wrap(rt.AuthHandler(rt.requestDataExport)))
+	rt.router.GET("/users/:userId/exports/", rt.wrap(rt.AuthHandler(rt.getDataExports)))
+	rt.router.GET("/users/:userId/exports/:jobId", rt.wrap(rt.AuthHandler(rt.getDataExport)))
+	rt.router.GET("/users/:userId/exports/:jobId/archive", rt.wrap(rt.AuthHandler(rt.downloadDataExport)))
+	rt.router.PUT("/users/:userId/profile", rt.wrap(rt.AuthHandler(rt.setMyProfile)))
+	rt.router.GET("/users/:userId/profile/:targetId", rt.wrap(rt.AuthHandler(rt.getProfile)))
+	rt.router.GET("/users/:userId/settings", rt.wrap(rt.AuthHandler(rt.getUserSettings)))
+	rt.router.PUT("/users/:userId/settings", rt.wrap(rt.AuthHandler(rt.setUserSettings)))
+	rt.router.GET("/users/:userId/conversations/:conversationId/export", rt.wrap(rt.AuthHandler(rt.exportConversation)))
+	rt.router.GET("/users/:userId/conversations/:conversationId/messages/:messageId/photo", rt.wrap(rt.AuthHandler(rt.getMessagePhoto)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/draft", rt.wrap(rt.AuthHandler(rt.putDraft)))
+	rt.router.GET("/users/:userId/conversations/:conversationId/draft", rt.wrap(rt.AuthHandler(rt.getDraft)))
+	rt.router.DELETE("/users/:userId/conversations/:conversationId/draft", rt.wrap(rt.AuthHandler(rt.deleteDraft)))
+	rt.router.POST("/users/:userId/conversations/:conversationId/scheduled-messages/", rt.wrap(rt.AuthHandler(rt.scheduleMessage)))
+	rt.router.GET("/users/:userId/conversations/:conversationId/scheduled-messages/", rt.wrap(rt.AuthHandler(rt.getScheduledMessages)))
+	rt.router.PUT("/users/:userId/conversations/:conversationId/scheduled-messages/:scheduledId", rt.wrap(rt.AuthHandler(rt.updateScheduledMessage)))
+	rt.router.DELETE("/users/:userId/conversations/:conversationId/scheduled-messages/:scheduledId", rt.wrap(rt.AuthHandler(rt.cancelScheduledMessage)))
+
+	// Administration routes
+	rt.router.POST("/admin/imports", rt.wrap(rt.AdminHandler(rt.importChat)))
+	rt.router.POST("/admin/backups", rt.wrap(rt.AdminHandler(rt.createBackup)))
+	rt.router.GET("/admin/backups", rt.wrap(rt.AdminHandler(rt.getBackups)))
 
 	// Special routes
 	rt.router.GET("/liveness", rt.liveness)
@@ -61,17 +99,38 @@ func (rt *_router) AuthHandler(next httpRouterHandler) httpRouterHandler {
 			return
 		}
 		userID, err := strconv.ParseInt(authHeader, 10, 64)
-		if err != nil {
+		if err != nil || userID <= 0 {
 			ctx.Logger.Warn("formato userID non valido")
 			http.Error(w, "Non autorizzato: formato userID non valido", http.StatusUnauthorized)
 			return
 		}
-		_, err = rt.db.GetUserById(userID)
+		_, err = rt.db.GetUserById(ctx.Context, userID)
 		if err != nil {
 			ctx.Logger.Warn("userID non valido")
 			http.Error(w, "Non autorizzato: userID non valido", http.StatusUnauthorized)
 			return
 		}
+		// Ogni richiesta autenticata mantiene l'utente online
+		rt.presence.touch(userID, globaltime.Now())
+		ctx.UserID = userID
+		next(w, r, ps, ctx)
+	}
+}
+
+// AdminHandler consente l'accesso alle API di amministrazione solo con il token configurato
+// (header "Authorization: Bearer <token>"); senza token configurato queste API non esistono.
+func (rt *_router) AdminHandler(next httpRouterHandler) httpRouterHandler {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+		if rt.adminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(rt.adminToken)) != 1 {
+			ctx.Logger.Warn("token di amministrazione non valido")
+			http.Error(w, "Non autorizzato", http.StatusUnauthorized)
+			return
+		}
 		next(w, r, ps, ctx)
 	}
 }
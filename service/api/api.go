@@ -37,10 +37,18 @@ See the `main.go` file inside the `cmd/webapi` for a full usage example.
 package api
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/Mortifer97/WASAText/service/backup"
 	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/metrics"
 	"github.com/julienschmidt/httprouter"
 	"github.com/sirupsen/logrus"
 )
@@ -52,6 +60,44 @@ type Config struct {
 
 	// Database is the instance of database.AppDatabase where data are saved
 	Database database.AppDatabase
+
+	// MaxPinnedMessages is the maximum number of messages pinned in a single conversation (0 means no limit)
+	MaxPinnedMessages int
+
+	// ExpiredMessagesInterval is how often expired disappearing messages and export archives are deleted (default: 1
+	// minute)
+	ExpiredMessagesInterval time.Duration
+
+	// ScheduledMessagesInterval is how often due scheduled messages are sent (default: 10 seconds)
+	ScheduledMessagesInterval time.Duration
+
+	// ExportDirectory is where the personal data export archives are written (default: a directory in the system
+	// temporary directory)
+	ExportDirectory string
+
+	// ExportExpiration is how long the export archives can be downloaded before being deleted (default: 24 hours)
+	ExportExpiration time.Duration
+
+	// BackupDirectory is where the snapshots of the database are written (default: a directory in the system temporary
+	// directory)
+	BackupDirectory string
+
+	// BackupInterval is how often a snapshot of the database is taken (0 means only when requested by an administrator)
+	BackupInterval time.Duration
+
+	// BackupRetention is how many snapshots are kept, deleting the oldest ones (0 means that all are kept)
+	BackupRetention int
+
+	// QueryTimeout is how long the database calls of a request can last before being cancelled (0 means no limit)
+	QueryTimeout time.Duration
+
+	// AdminToken authenticates the administration APIs, sent as "Authorization: Bearer <token>" (empty means that the
+	// administration APIs are disabled)
+	AdminToken string
+
+	// Metrics is where the metrics of the requests, of the database calls and of the messages sent are registered
+	// (default: a registry that is not exported)
+	Metrics *metrics.Registry
 }
 
 // Router is the package API interface representing an API handler builder
@@ -73,25 +119,115 @@ func New(cfg Config) (Router, error) {
 		return nil, errors.New("database is required")
 	}
 
+	if cfg.Metrics == nil {
+		cfg.Metrics = metrics.NewRegistry()
+	}
+	apiMetrics := newAPIMetrics(cfg.Metrics)
+	cfg.Database = apiMetrics.instrument(cfg.Database)
+
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
-	router := httprouter.New()
+	router := &instrumentedRouter{Router: httprouter.New(), metrics: apiMetrics}
 	router.RedirectTrailingSlash = false
 	router.RedirectFixedPath = false
 
-	return &_router{
-		router:     router,
-		baseLogger: cfg.Logger,
-		db:         cfg.Database,
-	}, nil
+	if cfg.ExpiredMessagesInterval <= 0 {
+		cfg.ExpiredMessagesInterval = time.Minute
+	}
+	if cfg.ScheduledMessagesInterval <= 0 {
+		cfg.ScheduledMessagesInterval = 10 * time.Second
+	}
+	if cfg.ExportDirectory == "" {
+		cfg.ExportDirectory = filepath.Join(os.TempDir(), "wasatext-exports")
+	}
+	if cfg.ExportExpiration <= 0 {
+		cfg.ExportExpiration = 24 * time.Hour
+	}
+	if cfg.BackupDirectory == "" {
+		cfg.BackupDirectory = filepath.Join(os.TempDir(), "wasatext-backups")
+	}
+
+	// Exports left unfinished by a previous run cannot be resumed
+	interrupted, err := cfg.Database.FailUnfinishedExportJobs(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failing unfinished export jobs: %w", err)
+	}
+	if interrupted > 0 {
+		cfg.Logger.Warnf("%d export jobs were interrupted by a restart", interrupted)
+	}
+
+	backups, err := backup.NewManager(cfg.Database, cfg.BackupDirectory, cfg.BackupRetention)
+	if err != nil {
+		return nil, fmt.Errorf("creating backup manager: %w", err)
+	}
+
+	rt := &_router{
+		router:            router,
+		baseLogger:        cfg.Logger,
+		db:                cfg.Database,
+		maxPinnedMessages: cfg.MaxPinnedMessages,
+		presence:          newPresenceTracker(),
+		exportDirectory:   cfg.ExportDirectory,
+		exportExpiration:  cfg.ExportExpiration,
+		adminToken:        cfg.AdminToken,
+		queryTimeout:      cfg.QueryTimeout,
+		backups:           backups,
+		metrics:           apiMetrics,
+		stopBackground:    make(chan struct{}),
+	}
+	rt.backgroundCtx, rt.cancelBackground = context.WithCancel(context.Background())
+
+	// Start the background tasks; they are stopped by Close()
+	rt.background.Add(4)
+	go rt.reapExpiredMessages(cfg.ExpiredMessagesInterval)
+	go rt.deliverScheduledMessages(cfg.ScheduledMessagesInterval)
+	go rt.trackPresence()
+	go rt.reapExpiredExports(cfg.ExpiredMessagesInterval)
+	if cfg.BackupInterval > 0 {
+		rt.background.Add(1)
+		go rt.runBackups(cfg.BackupInterval)
+	}
+
+	return rt, nil
 }
 
 type _router struct {
-	router *httprouter.Router
+	router *instrumentedRouter
 
 	// baseLogger is a logger for non-requests contexts, like goroutines or background tasks not started by a request.
 	// Use context logger if available (e.g., in requests) instead of this logger.
 	baseLogger logrus.FieldLogger
 
 	db database.AppDatabase
+
+	// maxPinnedMessages is the maximum number of messages pinned in a single conversation (0 means no limit)
+	maxPinnedMessages int
+
+	// presence tracks which users are online or typing, fed by the authenticated requests
+	presence *presenceTracker
+
+	// exportDirectory is where the export archives are written, and exportExpiration how long they are kept
+	exportDirectory  string
+	exportExpiration time.Duration
+
+	// backups takes the snapshots of the database, on schedule or when requested by an administrator
+	backups *backup.Manager
+
+	// adminToken authenticates the administration APIs; they are disabled when it is empty
+	adminToken string
+
+	// metrics are the metrics of the API, recorded by the router and by the handlers
+	metrics *apiMetrics
+
+	// queryTimeout is how long the database calls of a request can last (0 means no limit)
+	queryTimeout time.Duration
+
+	// stopBackground is closed by Close() to stop the background goroutines, tracked by background.
+	stopBackground chan struct{}
+	background     sync.WaitGroup
+
+	// backgroundCtx is passed to the database calls of the background goroutines; Close() cancels it, so that they
+	// don't keep the shutdown waiting.
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
 }
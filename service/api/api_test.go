@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/sirupsen/logrus"
+)
+
+// testServer is the API served on a new SQLite database, opened like webapi does.
+type testServer struct {
+	*httptest.Server
+	db database.AppDatabase
+	// filename is the database file, to open it from another pool like the command line tools do
+	filename string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.db")
+	writer, reader, err := database.OpenSQLite(context.Background(), filename, database.DefaultSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	router, err := New(Config{
+		Logger:          logger,
+		Database:        db,
+		ExportDirectory: filepath.Join(dir, "exports"),
+		BackupDirectory: filepath.Join(dir, "backups"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(router.Handler())
+	t.Cleanup(func() {
+		server.Close()
+		_ = router.Close()
+		_ = reader.Close()
+		_ = writer.Close()
+	})
+	return &testServer{Server: server, db: db, filename: filename}
+}
+
+// request sends a request authenticated as userId (none if 0), with body encoded as JSON if not nil.
+func (s *testServer) request(method string, path string, userId int64, body interface{}) (*http.Response, error) {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		payload = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, s.URL+path, payload)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if userId != 0 {
+		req.Header.Set("Authorization", strconv.FormatInt(userId, 10))
+	}
+	return s.Client().Do(req)
+}
+
+// do sends a request like request does, and decodes the response in out if not nil. It returns the status code.
+func (s *testServer) do(t *testing.T, method string, path string, userId int64, body interface{}, out interface{}) int {
+	t.Helper()
+	resp, err := s.request(method, path, userId, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// login logs in with name, creating the user if needed, and returns its id.
+func (s *testServer) login(t *testing.T, name string) int64 {
+	t.Helper()
+	var user UserResponse
+	if code := s.do(t, http.MethodPost, "/session", 0, map[string]string{"name": name}, &user); code != http.StatusOK && code != http.StatusCreated {
+		t.Fatalf("login %s: status %d", name, code)
+	}
+	return user.Id
+}
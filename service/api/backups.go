@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Handler di amministrazione per creare subito uno snapshot del database, mentre il server continua a funzionare.
+// Lo snapshot viene verificato prima di essere conservato; quelli oltre la retention vengono eliminati.
+func (rt *_router) createBackup(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	// Una copia può durare più del timeout delle query: viene interrotta solo se il client si disconnette
+	snapshot, err := rt.backups.Create(r.Context())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore backup database")
+		http.Error(w, "Errore backup database", http.StatusInternalServerError)
+		return
+	}
+	ctx.Logger.WithField("snapshot", snapshot.Name).Info("backup del database completato")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(snapshot)
+}
+
+// Handler di amministrazione per elencare gli snapshot del database, dal più recente.
+func (rt *_router) getBackups(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	snapshots, err := rt.backups.List()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore elenco backup")
+		http.Error(w, "Errore elenco backup", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(snapshots)
+}
+
+// runBackups crea uno snapshot del database ogni `interval`, finché il router non viene chiuso.
+func (rt *_router) runBackups(interval time.Duration) {
+	defer rt.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rt.stopBackground:
+			return
+		case <-ticker.C:
+			snapshot, err := rt.backups.Create(rt.backgroundCtx)
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error backing up database")
+				continue
+			}
+			rt.baseLogger.WithField("snapshot", snapshot.Name).Info("database backed up")
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/chatimport"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxImportSize è la dimensione massima di un'esportazione caricata per l'importazione (allegati compresi).
+const maxImportSize = 256 << 20
+
+// Handler di amministrazione per importare una chat esportata da WhatsApp o Telegram in una nuova conversazione.
+// Form multipart: file (il .txt di WhatsApp, il result.json di Telegram, o un archivio .zip con la chat e gli
+// allegati) e i campi facoltativi source, title, users (oggetto JSON nome partecipante -> username), timezone e
+// dateOrder, con lo stesso significato delle opzioni di cmd/wasatext-import.
+// Importare di nuovo la stessa chat aggiunge solo i messaggi mancanti.
+func (rt *_router) importChat(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "Errore parsing form", http.StatusBadRequest)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "File mancante", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	opts := chatimport.Options{
+		Source:    r.FormValue("source"),
+		Title:     r.FormValue("title"),
+		DateOrder: r.FormValue("dateOrder"),
+	}
+	if users := r.FormValue("users"); users != "" {
+		if err := json.Unmarshal([]byte(users), &opts.Users); err != nil {
+			http.Error(w, "Utenti non validi", http.StatusBadRequest)
+			return
+		}
+	}
+	if timezone := r.FormValue("timezone"); timezone != "" {
+		if opts.Location, err = time.LoadLocation(timezone); err != nil {
+			http.Error(w, "Fuso orario non valido", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Un archivio contiene anche gli allegati; un file singolo solo il testo della chat
+	var fsys fs.FS
+	var chat chatimport.Chat
+	if archive, zipErr := zip.NewReader(file, header.Size); zipErr == nil {
+		fsys = archive
+		chat, err = chatimport.Load(archive, "", opts)
+	} else if _, err = file.Seek(0, io.SeekStart); err == nil {
+		chat, err = chatimport.Parse(file, header.Filename, opts)
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("esportazione non valida")
+		if errors.Is(err, chatimport.ErrUnknownFormat) {
+			http.Error(w, "Formato non riconosciuto", http.StatusBadRequest)
+		} else {
+			http.Error(w, "Esportazione non valida: "+err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	// Un'importazione può durare più del timeout delle query: viene interrotta solo se il client si disconnette
+	result, err := chatimport.Import(r.Context(), rt.db, fsys, chat, opts)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore importazione chat")
+		http.Error(w, "Errore importazione chat", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if result.Created {
+		w.WriteHeader(http.StatusCreated)
+	}
+	_ = json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Esportazione di una conversazione in JSON, testo semplice o HTML autonomo.
+// I messaggi sono scritti uno alla volta mentre vengono letti dal database (IterateMessages), senza tenerli tutti
+// in memoria. Le foto possono essere incluse nel file ("embed", come URL "data:"), indicate con il percorso API da
+// cui scaricarle ("link") oppure omesse ("none").
+
+// conversationWriter scrive una conversazione esportata in un formato.
+type conversationWriter interface {
+	begin(conversation database.Conversation, exportedAt time.Time) error
+	message(message exportMessage) error
+	end() error
+}
+
+// exportFormats associa a ogni formato il Content-Type, l'estensione del file e la modalità predefinita per le foto.
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+	media       string
+}{
+	"json": {"application/json", "json", "embed"},
+	"txt":  {"text/plain; charset=utf-8", "txt", "link"},
+	"html": {"text/html; charset=utf-8", "html", "embed"},
+}
+
+// Handler per esportare una conversazione.
+// Parametri di query: format (json, txt o html), media (embed, link o none) e l'intervallo from/to, come data
+// (2006-01-02) o data e ora RFC 3339; from è incluso, to escluso, ma una data senza ora include tutto quel giorno.
+func (rt *_router) exportConversation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+
+	query := r.URL.Query()
+	formatName := query.Get("format")
+	if formatName == "" {
+		formatName = "json"
+	}
+	format, ok := exportFormats[formatName]
+	if !ok {
+		http.Error(w, "Formato non valido", http.StatusBadRequest)
+		return
+	}
+	media := query.Get("media")
+	if media == "" {
+		media = format.media
+	}
+	if media != "embed" && media != "link" && media != "none" || (formatName == "txt" && media == "embed") {
+		http.Error(w, "Modalità foto non valida", http.StatusBadRequest)
+		return
+	}
+	from, okFrom := parseExportTime(query.Get("from"), false)
+	to, okTo := parseExportTime(query.Get("to"), true)
+	if !okFrom || !okTo || (!from.IsZero() && !to.IsZero() && !from.Before(to)) {
+		http.Error(w, "Intervallo di date non valido", http.StatusBadRequest)
+		return
+	}
+
+	conversation, err := rt.db.GetConversationSummary(ctx.Context, conversationId, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero conversazione")
+		http.Error(w, "Errore esportazione conversazione", http.StatusInternalServerError)
+		return
+	}
+
+	var out conversationWriter
+	switch formatName {
+	case "json":
+		out = &jsonConversationWriter{w: w}
+	case "txt":
+		out = &textConversationWriter{w: w}
+	default:
+		out = &htmlConversationWriter{w: w}
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="conversation-%d.%s"`, conversationId, format.extension))
+
+	// Da qui la risposta è già iniziata: in caso di errore si può solo interromperla. La lettura dei messaggi può
+	// durare più del timeout delle query, quindi viene interrotta solo se il client si disconnette
+	err = out.begin(conversation, globaltime.Now())
+	if err == nil {
+		err = rt.db.IterateMessages(r.Context(), userId, conversationId, from, to, func(message database.Message) error {
+			return out.message(newExportMessage(message, exportMedia(message, userId, media)))
+		})
+	}
+	if err == nil {
+		err = out.end()
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore esportazione conversazione")
+	}
+}
+
+// parseExportTime legge un estremo dell'intervallo di esportazione; una stringa vuota è un estremo assente.
+// Una data senza ora indica l'inizio di quel giorno nel fuso orario del server, o la sua fine se `endOfDay` è vero.
+func parseExportTime(value string, endOfDay bool) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, true
+	}
+	if day, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		if endOfDay {
+			day = day.AddDate(0, 0, 1)
+		}
+		return day, true
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, err == nil
+}
+
+// exportMedia restituisce come indicare la foto di un messaggio nell'esportazione, secondo la modalità scelta.
+func exportMedia(message database.Message, userId int64, mode string) string {
+	if len(message.Photo) == 0 {
+		return ""
+	}
+	switch mode {
+	case "embed":
+		return "data:" + http.DetectContentType(message.Photo) + ";base64," + base64.StdEncoding.EncodeToString(message.Photo)
+	case "link":
+		return fmt.Sprintf("/users/%d/conversations/%d/messages/%d/photo", userId, message.ConversationId, message.MessageId)
+	default:
+		return ""
+	}
+}
+
+// jsonConversationWriter scrive {"conversation": ..., "exportedAt": ..., "messages": [...]}.
+type jsonConversationWriter struct {
+	w        io.Writer
+	messages int
+}
+
+func (j *jsonConversationWriter) begin(conversation database.Conversation, exportedAt time.Time) error {
+	conversation.Photo = nil
+	header, err := json.Marshal(conversation)
+	if err != nil {
+		return err
+	}
+	timestamp, err := json.Marshal(exportedAt)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, `{"conversation":%s,"exportedAt":%s,"messages":[`, header, timestamp)
+	return err
+}
+
+func (j *jsonConversationWriter) message(message exportMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if j.messages > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.messages++
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonConversationWriter) end() error {
+	_, err := io.WriteString(j.w, "]}\n")
+	return err
+}
+
+// textConversationWriter scrive un messaggio per riga, preceduto da id, data e mittente.
+type textConversationWriter struct {
+	w io.Writer
+}
+
+func (t *textConversationWriter) begin(conversation database.Conversation, exportedAt time.Time) error {
+	_, err := fmt.Fprintf(t.w, "Conversazione: %s\nEsportata il %s\n\n", conversation.Name, exportedAt.Format("02/01/2006 15:04"))
+	return err
+}
+
+func (t *textConversationWriter) message(message exportMessage) error {
+	var line strings.Builder
+	fmt.Fprintf(&line, "#%d [%s] %s:", message.MessageId, message.Timestamp.Format("02/01/2006 15:04"), message.Sender.ShownName())
+	if message.ForwardedFrom != nil {
+		line.WriteString(" (inoltrato")
+		if message.ForwardedFrom.Sender != nil {
+			line.WriteString(" da " + message.ForwardedFrom.Sender.ShownName())
+		}
+		line.WriteString(")")
+	}
+	if message.ReplyToMessageId != nil {
+		fmt.Fprintf(&line, " (in risposta a #%d)", *message.ReplyToMessageId)
+	}
+	if message.Text != "" {
+		line.WriteString(" " + message.Text)
+	}
+	if message.Media != "" {
+		line.WriteString(" [foto: " + message.Media + "]")
+	} else if message.Type == "photo" || (message.Text == "" && message.Type != "system") {
+		line.WriteString(" [foto]")
+	}
+	if len(message.Reactions) > 0 {
+		reactions := make([]string, 0, len(message.Reactions))
+		for _, reaction := range message.Reactions {
+			reactions = append(reactions, fmt.Sprintf("%s %d", reaction.Emoji, reaction.Count))
+		}
+		line.WriteString(" {" + strings.Join(reactions, ", ") + "}")
+	}
+	line.WriteString("\n")
+	_, err := io.WriteString(t.w, line.String())
+	return err
+}
+
+func (t *textConversationWriter) end() error {
+	return nil
+}
+
+// htmlConversationWriter scrive una pagina HTML autonoma con i modelli di export-html.go.
+type htmlConversationWriter struct {
+	w io.Writer
+}
+
+func (h *htmlConversationWriter) begin(conversation database.Conversation, exportedAt time.Time) error {
+	return exportTemplates.ExecuteTemplate(h.w, "header", map[string]string{"Title": conversation.Name, "Back": ""})
+}
+
+func (h *htmlConversationWriter) message(message exportMessage) error {
+	return exportTemplates.ExecuteTemplate(h.w, "message", message)
+}
+
+func (h *htmlConversationWriter) end() error {
+	return exportTemplates.ExecuteTemplate(h.w, "footer", nil)
+}
+
+// Handler per scaricare la foto di un messaggio, usato dalle esportazioni con media=link.
+func (rt *_router) getMessagePhoto(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	messageId, _ := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 || messageId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	message, err := rt.db.GetMessageById(ctx.Context, messageId, conversationId)
+	if err != nil || len(message.Photo) == 0 {
+		http.Error(w, "Foto non trovata", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(message.Photo))
+	_, _ = w.Write(message.Photo)
+}
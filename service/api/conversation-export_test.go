@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// TestExportConversationRange exports a conversation by date and time on a server whose time zone is ahead of UTC:
+// the dates are days of the server, and the times with an offset are the same instants whatever the zone.
+func TestExportConversationRange(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	defer func() {
+		time.Local = local
+		globaltime.FixedTime = time.Time{}
+	}()
+
+	s := newTestServer(t)
+	ctx := context.Background()
+	alice := s.login(t, "alice")
+	bobby := s.login(t, "bobby")
+	conversation, err := s.db.CreateConversation(ctx, alice, bobby, "direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Half past midnight on the 10th for the server, still the 9th in UTC
+	globaltime.FixedTime = time.Date(2026, time.March, 10, 0, 30, 0, 0, time.Local)
+	if _, err := s.db.AddMessage(ctx, conversation.ConversationId, alice, "just after midnight", "sent", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+	globaltime.FixedTime = time.Time{}
+
+	for _, test := range []struct {
+		from, to string
+		messages int
+	}{
+		{"2026-03-10", "2026-03-10", 1},
+		{"2026-03-09", "2026-03-09", 0},
+		{"2026-03-09T14:00:00Z", "2026-03-09T15:00:00Z", 1},
+		{"2026-03-10T00:00:00+10:00", "2026-03-10T00:30:00+10:00", 0},
+		{"2026-03-09T15:00:00Z", "", 0},
+	} {
+		path := fmt.Sprintf("/users/%d/conversations/%d/export?", alice, conversation.ConversationId) +
+			url.Values{"from": {test.from}, "to": {test.to}}.Encode()
+		var export struct {
+			Messages []json.RawMessage `json:"messages"`
+		}
+		if code := s.do(t, http.MethodGet, path, alice, nil, &export); code != http.StatusOK {
+			t.Fatalf("export from %s to %s: got status %d", test.from, test.to, code)
+		}
+		if len(export.Messages) != test.messages {
+			t.Errorf("export from %s to %s: got %d messages, want %d", test.from, test.to, len(export.Messages), test.messages)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Handler per segnare come letta una conversazione.
+// Tutti i messaggi ricevuti finora diventano letti (aggiorna last_access) e viene tolto il segno "da leggere".
+// Si collega a UpdateLastAccess in database/update-entity-db.go.
+func (rt *_router) markConversationRead(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	if err := rt.db.UpdateLastAccess(ctx.Context, userId, conversationId); err != nil {
+		ctx.Logger.WithError(err).Error("errore aggiornamento ultimo accesso")
+		http.Error(w, "Errore aggiornamento conversazione", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler per segnare come da leggere una conversazione.
+// I messaggi già letti restano tali; la conversazione viene solo evidenziata nella lista.
+// Si collega a MarkConversationUnread in database/update-entity-db.go.
+func (rt *_router) markConversationUnread(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	if err := rt.db.MarkConversationUnread(ctx.Context, userId, conversationId); err != nil {
+		ctx.Logger.WithError(err).Error("errore nel segnare la conversazione come da leggere")
+		http.Error(w, "Errore aggiornamento conversazione", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+// ConversationSettingsRequest rappresenta il payload per cambiare le impostazioni personali di una conversazione.
+// I campi assenti restano invariati; "mutedUntil" è usato solo con "muted": true e, se assente, la conversazione
+// resta silenziata finché non viene riattivata.
+type ConversationSettingsRequest struct {
+	Muted      *bool      `json:"muted"`
+	MutedUntil *time.Time `json:"mutedUntil"`
+	Archived   *bool      `json:"archived"`
+	Pinned     *bool      `json:"pinned"`
+}
+
+// Handler per silenziare, archiviare o fissare in alto una conversazione per l'utente.
+// Controlla che l'utente sia membro della conversazione e chiama UpdateConversationSettings.
+// Si collega a database/member-settings-db.go.
+func (rt *_router) setConversationSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	var body ConversationSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	if body.MutedUntil != nil && (body.Muted == nil || !*body.Muted || !body.MutedUntil.After(globaltime.Now())) {
+		http.Error(w, "Scadenza del silenzioso non valida", http.StatusBadRequest)
+		return
+	}
+	settings, err := rt.db.UpdateConversationSettings(ctx.Context, userId, conversationId, database.MemberSettingsUpdate{
+		Muted:      body.Muted,
+		MutedUntil: body.MutedUntil,
+		Archived:   body.Archived,
+		Pinned:     body.Pinned,
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore aggiornamento impostazioni conversazione")
+		http.Error(w, "Errore aggiornamento impostazioni conversazione", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
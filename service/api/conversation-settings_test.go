@@ -0,0 +1,41 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// TestMuteUntilFollowsServiceClock checks that the end of a mute is validated against the clock of the service, the
+// one the database uses to tell whether the conversation is still muted.
+func TestMuteUntilFollowsServiceClock(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.login(t, "alice")
+	bobby := s.login(t, "bobby")
+	conversation, err := s.db.CreateConversation(context.Background(), alice, bobby, "direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := fmt.Sprintf("/users/%d/conversations/%d/settings", alice, conversation.ConversationId)
+
+	now := time.Now().Add(24 * time.Hour)
+	globaltime.FixedTime = now
+	defer func() { globaltime.FixedTime = time.Time{} }()
+	yes := true
+	for _, test := range []struct {
+		mutedUntil time.Time
+		code       int
+	}{
+		{now.Add(-time.Hour), http.StatusBadRequest},
+		{now.Add(time.Hour), http.StatusOK},
+	} {
+		body := ConversationSettingsRequest{Muted: &yes, MutedUntil: &test.mutedUntil}
+		if code := s.do(t, http.MethodPut, path, alice, body, nil); code != test.code {
+			t.Errorf("muting until %v: got status %d, want %d", test.mutedUntil, code, test.code)
+		}
+	}
+}
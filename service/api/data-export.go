@@ -0,0 +1,385 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/gofrs/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Esportazione dei dati personali.
+// L'archivio ZIP viene prodotto in background e contiene:
+//   - profile.json: profilo e impostazioni dell'utente
+//   - conversations/<id>.json e conversations/<id>.html: ogni conversazione con i suoi messaggi
+//   - media/: la foto del profilo e le foto dei messaggi
+//   - index.html: l'indice per consultare l'archivio offline
+
+// errExportInterrupted è restituito quando il servizio si ferma durante un'esportazione
+var errExportInterrupted = errors.New("export interrupted by the shutdown of the service")
+
+// Handler per richiedere l'esportazione dei dati dell'utente.
+// Se un'esportazione è già in corso restituisce quella, altrimenti ne avvia una nuova.
+// Si collega a database/export-jobs-db.go.
+func (rt *_router) requestDataExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	// La verifica di un'esportazione in corso e la creazione avvengono in un'unica operazione sul database,
+	// così due richieste contemporanee non avviano due esportazioni
+	job, created, err := rt.db.CreateExportJob(ctx.Context, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore creazione esportazione")
+		http.Error(w, "Errore avvio esportazione", http.StatusInternalServerError)
+		return
+	}
+	if created {
+		rt.background.Add(1)
+		go rt.runExportJob(job)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handler per ottenere le esportazioni richieste dall'utente, dalla più recente.
+func (rt *_router) getDataExports(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	jobs, err := rt.db.GetExportJobs(ctx.Context, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero esportazioni")
+		http.Error(w, "Errore recupero esportazioni", http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []database.ExportJob{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// getOwnExportJob legge gli id dal percorso e restituisce il job, se appartiene all'utente;
+// altrimenti scrive la risposta di errore e restituisce false.
+func (rt *_router) getOwnExportJob(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (database.ExportJob, bool) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	jobId, _ := strconv.ParseInt(ps.ByName("jobId"), 10, 64)
+	if userId <= 0 || jobId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return database.ExportJob{}, false
+	}
+	job, err := rt.db.GetExportJob(ctx, jobId)
+	if err != nil || job.UserId != userId {
+		http.Error(w, "Esportazione non trovata", http.StatusNotFound)
+		return database.ExportJob{}, false
+	}
+	return job, true
+}
+
+// Handler per ottenere lo stato di un'esportazione.
+func (rt *_router) getDataExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	job, ok := rt.getOwnExportJob(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Handler per scaricare l'archivio di un'esportazione completata e non ancora scaduta.
+func (rt *_router) downloadDataExport(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	job, ok := rt.getOwnExportJob(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	switch job.Status {
+	case database.ExportDone:
+		// L'archivio scaduto non è ancora stato rimosso dalla pulizia periodica, ma non va più consegnato
+		if job.ExpiresAt != nil && job.ExpiresAt.Before(globaltime.Now()) {
+			http.Error(w, "Esportazione scaduta", http.StatusGone)
+			return
+		}
+	case database.ExportExpired:
+		http.Error(w, "Esportazione scaduta", http.StatusGone)
+		return
+	case database.ExportFailed:
+		http.Error(w, "Esportazione non riuscita", http.StatusConflict)
+		return
+	default:
+		http.Error(w, "Esportazione non ancora pronta", http.StatusConflict)
+		return
+	}
+	archive, err := os.Open(job.FilePath)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore apertura archivio esportazione")
+		http.Error(w, "Errore download esportazione", http.StatusInternalServerError)
+		return
+	}
+	defer archive.Close()
+	name := fmt.Sprintf("wasatext-export-%d.zip", job.JobId)
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, *job.CompletedAt, archive)
+}
+
+// runExportJob produce l'archivio di un job e ne salva l'esito.
+func (rt *_router) runExportJob(job database.ExportJob) {
+	defer rt.background.Done()
+	logger := rt.baseLogger.WithField("export_job", job.JobId)
+
+	job.Status = database.ExportRunning
+	if err := rt.db.UpdateExportJob(rt.backgroundCtx, job); err != nil {
+		logger.WithError(err).Error("error starting export job")
+		return
+	}
+
+	path, err := rt.writeExportArchive(rt.backgroundCtx, job.UserId)
+	now := globaltime.Now()
+	job.CompletedAt = &now
+	if err != nil {
+		logger.WithError(err).Error("error exporting user data")
+		job.Status = database.ExportFailed
+		job.Error = "errore durante l'esportazione"
+		if errors.Is(err, errExportInterrupted) || errors.Is(err, context.Canceled) {
+			job.Error = "esportazione interrotta dall'arresto del servizio"
+		}
+	} else {
+		expiresAt := now.Add(rt.exportExpiration)
+		job.Status = database.ExportDone
+		job.ExpiresAt = &expiresAt
+		job.FilePath = path
+	}
+	// L'esito viene salvato anche se il servizio si sta arrestando e il contesto dei task in background è annullato
+	if err := rt.db.UpdateExportJob(context.Background(), job); err != nil {
+		logger.WithError(err).Error("error saving export job")
+	}
+}
+
+// writeExportArchive scrive l'archivio con i dati dell'utente nella cartella delle esportazioni e ne restituisce il
+// percorso.
+func (rt *_router) writeExportArchive(ctx context.Context, userId int64) (string, error) {
+	if err := os.MkdirAll(rt.exportDirectory, 0o700); err != nil {
+		return "", fmt.Errorf("creating the export directory: %w", err)
+	}
+	file, err := os.CreateTemp(rt.exportDirectory, "export-*.zip.tmp")
+	if err != nil {
+		return "", fmt.Errorf("creating the archive: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name()) // no-op once the archive has been renamed
+	}()
+
+	archive := zip.NewWriter(file)
+	if err := rt.writeUserData(ctx, archive, userId); err != nil {
+		return "", err
+	}
+	if err := archive.Close(); err != nil {
+		return "", fmt.Errorf("writing the archive: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("writing the archive: %w", err)
+	}
+
+	name, err := uuid.NewV4()
+	if err != nil {
+		return "", fmt.Errorf("generating the archive name: %w", err)
+	}
+	path := filepath.Join(rt.exportDirectory, "export-"+name.String()+".zip")
+	if err := os.Rename(file.Name(), path); err != nil {
+		return "", fmt.Errorf("saving the archive: %w", err)
+	}
+	return path, nil
+}
+
+// writeUserData scrive nell'archivio profilo, conversazioni, foto e indice HTML dell'utente.
+func (rt *_router) writeUserData(ctx context.Context, archive *zip.Writer, userId int64) error {
+	exportedAt := globaltime.Now()
+	profile, err := rt.db.GetUserProfile(ctx, userId)
+	if err != nil {
+		return err
+	}
+	settings, err := rt.db.GetUserSettings(ctx, userId)
+	if err != nil {
+		return err
+	}
+	var profilePhoto string
+	if len(profile.Photo) > 0 {
+		profilePhoto = "media/profile" + mediaExtension(profile.Photo)
+		if err := writeArchiveFile(archive, profilePhoto, profile.Photo); err != nil {
+			return err
+		}
+		profile.Photo = nil
+	}
+	if err := writeArchiveJSON(archive, "profile.json", map[string]interface{}{
+		"exportedAt": exportedAt,
+		"profile":    profile,
+		"photo":      profilePhoto,
+		"settings":   settings,
+	}); err != nil {
+		return err
+	}
+
+	type indexEntry struct {
+		Name     string
+		Page     string
+		Messages int
+	}
+	var index []indexEntry
+	conversations, err := rt.db.GetConversationsByUser(ctx, userId, "desc", true)
+	if err != nil {
+		return err
+	}
+	for _, conversation := range conversations {
+		select {
+		case <-rt.stopBackground:
+			return errExportInterrupted
+		default:
+		}
+		messages, err := rt.writeExportConversation(ctx, archive, userId, conversation)
+		if err != nil {
+			return fmt.Errorf("exporting conversation %d: %w", conversation.ConversationId, err)
+		}
+		index = append(index, indexEntry{
+			Name:     conversation.Name,
+			Page:     fmt.Sprintf("conversations/%d.html", conversation.ConversationId),
+			Messages: messages,
+		})
+	}
+
+	page, err := archive.Create("index.html")
+	if err != nil {
+		return err
+	}
+	return exportTemplates.ExecuteTemplate(page, "index", map[string]interface{}{
+		"Title":         "Esportazione WASAText",
+		"Back":          "",
+		"Profile":       profile,
+		"ProfilePhoto":  profilePhoto,
+		"ExportedAt":    exportedAt,
+		"Conversations": index,
+	})
+}
+
+// writeExportConversation scrive nell'archivio una conversazione in JSON e in HTML, con le foto dei messaggi, e
+// restituisce il numero dei messaggi.
+func (rt *_router) writeExportConversation(ctx context.Context, archive *zip.Writer, userId int64, conversation database.Conversation) (int, error) {
+	messages, err := rt.db.GetMessagesByConversation(ctx, userId, conversation.ConversationId, "asc")
+	if err != nil {
+		return 0, err
+	}
+	exported := make([]exportMessage, 0, len(messages))
+	for _, message := range messages {
+		var media string
+		if len(message.Photo) > 0 {
+			media = fmt.Sprintf("media/%d/%d%s", conversation.ConversationId, message.MessageId, mediaExtension(message.Photo))
+			if err := writeArchiveFile(archive, media, message.Photo); err != nil {
+				return 0, err
+			}
+		}
+		exported = append(exported, newExportMessage(message, media))
+	}
+
+	conversation.Photo = nil
+	base := fmt.Sprintf("conversations/%d", conversation.ConversationId)
+	if err := writeArchiveJSON(archive, base+".json", map[string]interface{}{
+		"conversation": conversation,
+		"messages":     exported,
+	}); err != nil {
+		return 0, err
+	}
+
+	page, err := archive.Create(base + ".html")
+	if err != nil {
+		return 0, err
+	}
+	header := map[string]string{"Title": conversation.Name, "Back": "../index.html"}
+	if err := exportTemplates.ExecuteTemplate(page, "header", header); err != nil {
+		return 0, err
+	}
+	for _, message := range exported {
+		// Le pagine delle conversazioni sono in conversations/, le foto in media/
+		if message.Media != "" {
+			message.Media = "../" + message.Media
+		}
+		if err := exportTemplates.ExecuteTemplate(page, "message", message); err != nil {
+			return 0, err
+		}
+	}
+	return len(exported), exportTemplates.ExecuteTemplate(page, "footer", nil)
+}
+
+// writeArchiveFile aggiunge un file all'archivio.
+func writeArchiveFile(archive *zip.Writer, name string, data []byte) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+// writeArchiveJSON aggiunge all'archivio un file JSON indentato.
+func writeArchiveJSON(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// reapExpiredExports cancella periodicamente gli archivi scaduti, finché Close() non ferma il router.
+func (rt *_router) reapExpiredExports(interval time.Duration) {
+	defer rt.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rt.stopBackground:
+			return
+		case <-ticker.C:
+			jobs, err := rt.db.GetExpiredExportJobs(rt.backgroundCtx, globaltime.Now())
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error retrieving expired exports")
+				continue
+			}
+			for _, job := range jobs {
+				rt.removeExportArchive(job)
+				job.Status = database.ExportExpired
+				job.FilePath = ""
+				if err := rt.db.UpdateExportJob(rt.backgroundCtx, job); err != nil {
+					rt.baseLogger.WithError(err).WithField("export_job", job.JobId).Error("error expiring export")
+				}
+			}
+		}
+	}
+}
+
+// removeExportArchive cancella il file dell'archivio di un job, se esiste.
+func (rt *_router) removeExportArchive(job database.ExportJob) {
+	if job.FilePath == "" {
+		return
+	}
+	if err := os.Remove(job.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		rt.baseLogger.WithError(err).WithField("export_job", job.JobId).Error("error removing export archive")
+	}
+}
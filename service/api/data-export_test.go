@@ -0,0 +1,44 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// TestDownloadExpiredDataExport checks that an archive is no longer served once it expires, even before the periodic
+// cleanup marks the job as expired.
+func TestDownloadExpiredDataExport(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.login(t, "alice")
+	var job database.ExportJob
+	if code := s.do(t, http.MethodPost, fmt.Sprintf("/users/%d/exports/", alice), alice, nil, &job); code != http.StatusAccepted {
+		t.Fatalf("requesting export: got status %d", code)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for job.Status != database.ExportDone {
+		if job.Status == database.ExportFailed || time.Now().After(deadline) {
+			t.Fatalf("export not completed: %+v", job)
+		}
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if job, err = s.db.GetExportJob(context.Background(), job.JobId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := fmt.Sprintf("/users/%d/exports/%d/archive", alice, job.JobId)
+	if code := s.do(t, http.MethodGet, archive, alice, nil, nil); code != http.StatusOK {
+		t.Fatalf("downloading export: got status %d, want %d", code, http.StatusOK)
+	}
+
+	globaltime.FixedTime = job.ExpiresAt.Add(time.Minute)
+	defer func() { globaltime.FixedTime = time.Time{} }()
+	if code := s.do(t, http.MethodGet, archive, alice, nil, nil); code != http.StatusGone {
+		t.Fatalf("downloading expired export: got status %d, want %d", code, http.StatusGone)
+	}
+}
@@ -27,7 +27,7 @@ func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Check if the user exists
-	_, err = rt.db.GetUserById(userId)
+	_, err = rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("user not found")
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -35,7 +35,7 @@ func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Check if the user is part of the conversation
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error checking if user is in conversation")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -48,7 +48,7 @@ func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Verify if the comment exists
-	comment, err := rt.db.GetCommentById(commentId)
+	comment, err := rt.db.GetCommentById(ctx.Context, commentId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("comment not found")
 		http.Error(w, "Comment not found", http.StatusNotFound)
@@ -63,7 +63,7 @@ func (rt *_router) removeComment(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Delete the comment
-	err = rt.db.DeleteCommentById(commentId)
+	err = rt.db.DeleteCommentById(ctx.Context, commentId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("failed to delete comment")
 		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
@@ -88,7 +88,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Check if the user exists
-	_, err = rt.db.GetUserById(userId)
+	_, err = rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("user not found")
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -96,7 +96,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Check if the group exists
-	_, err = rt.db.GetGroupById(int64(groupId))
+	_, err = rt.db.GetGroupById(ctx.Context, int64(groupId))
 	if err != nil {
 		ctx.Logger.WithError(err).Error("group not found")
 		http.Error(w, "Group not found", http.StatusNotFound)
@@ -104,7 +104,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Check if the user is a member of the group
-	isMember, err := rt.db.IsUserMemberOfGroup(userId, int64(groupId))
+	isMember, err := rt.db.IsUserMemberOfGroup(ctx.Context, userId, int64(groupId))
 	if err != nil {
 		ctx.Logger.WithError(err).Error("database error checking group membership")
 		http.Error(w, "Database error", http.StatusInternalServerError)
@@ -117,7 +117,7 @@ func (rt *_router) leaveGroup(w http.ResponseWriter, r *http.Request, ps httprou
 	}
 
 	// Remove the user from the group
-	err = rt.db.RemoveUserFromGroup(int64(groupId), userId)
+	err = rt.db.RemoveUserFromGroup(ctx.Context, int64(groupId), userId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("failed to remove user from group")
 		http.Error(w, "Failed to remove user from group", http.StatusInternalServerError)
@@ -145,7 +145,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Check if the user exists
-	_, err = rt.db.GetUserById(userId)
+	_, err = rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("user not found")
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -153,7 +153,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Check if the user is part of the conversation
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("error checking if user is in conversation")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -166,7 +166,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Verify if the message exists
-	message, err := rt.db.GetMessageById(messageId, conversationId)
+	message, err := rt.db.GetMessageById(ctx.Context, messageId, conversationId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("message not found")
 		http.Error(w, "Message not found", http.StatusNotFound)
@@ -181,7 +181,7 @@ func (rt *_router) deleteMessage(w http.ResponseWriter, r *http.Request, ps http
 	}
 
 	// Delete the message
-	err = rt.db.DeleteMessageById(messageId)
+	err = rt.db.DeleteMessageById(ctx.Context, messageId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("failed to delete message")
 		http.Error(w, "Failed to delete message", http.StatusInternalServerError)
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// minMessageTTL e maxMessageTTL delimitano la durata dei messaggi effimeri
+	minMessageTTL = 10 * time.Second
+	maxMessageTTL = 365 * 24 * time.Hour
+)
+
+// MessageTimerRequest rappresenta il payload per impostare il timer dei messaggi effimeri, in secondi (0 lo disattiva)
+type MessageTimerRequest struct {
+	Seconds int64 `json:"seconds"`
+}
+
+// Handler per impostare il timer dei messaggi effimeri di una conversazione.
+// Controlla che l'utente sia membro della conversazione e chiama SetMessageTTL;
+// il timer vale per i messaggi inviati da ora in poi.
+// Si collega a database/disappearing-messages-db.go.
+func (rt *_router) setMessageTimer(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	var body MessageTimerRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(body.Seconds) * time.Second
+	if ttl != 0 && (ttl < minMessageTTL || ttl > maxMessageTTL) {
+		http.Error(w, "Durata non valida", http.StatusBadRequest)
+		return
+	}
+	if err := rt.db.SetMessageTTL(ctx.Context, conversationId, ttl); err != nil {
+		ctx.Logger.WithError(err).Error("errore aggiornamento timer messaggi")
+		http.Error(w, "Errore aggiornamento timer messaggi", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// reapExpiredMessages cancella periodicamente i messaggi effimeri scaduti, finché Close() non ferma il router.
+func (rt *_router) reapExpiredMessages(interval time.Duration) {
+	defer rt.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rt.stopBackground:
+			return
+		case <-ticker.C:
+			deleted, err := rt.db.DeleteExpiredMessages(rt.backgroundCtx, globaltime.Now())
+			if err != nil {
+				rt.baseLogger.WithError(err).Error("error deleting expired messages")
+			}
+			if deleted > 0 {
+				rt.baseLogger.Debugf("deleted %d expired messages", deleted)
+			}
+		}
+	}
+}
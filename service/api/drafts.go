@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/julienschmidt/httprouter"
+)
+
+// DraftRequest rappresenta il payload per salvare la bozza di una conversazione
+type DraftRequest struct {
+	Text string `json:"text"`
+}
+
+// parseDraftParams legge gli id dal percorso e controlla che l'utente sia membro della conversazione;
+// in caso di errore scrive la risposta e restituisce false.
+func (rt *_router) parseDraftParams(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (int64, int64, bool) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return userId, conversationId, true
+}
+
+// Handler per salvare la bozza dell'utente in una conversazione; una bozza vuota viene cancellata.
+// Si collega a database/drafts-db.go.
+func (rt *_router) putDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, conversationId, ok := rt.parseDraftParams(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	var body DraftRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	if body.Text == "" {
+		if err := rt.db.DeleteDraft(ctx.Context, userId, conversationId); err != nil {
+			ctx.Logger.WithError(err).Error("errore cancellazione bozza")
+			http.Error(w, "Errore cancellazione bozza", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	draft, err := rt.db.SaveDraft(ctx.Context, userId, conversationId, body.Text)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore salvataggio bozza")
+		http.Error(w, "Errore salvataggio bozza", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Handler per ottenere la bozza dell'utente in una conversazione.
+// Si collega a database/drafts-db.go.
+func (rt *_router) getDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, conversationId, ok := rt.parseDraftParams(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	draft, err := rt.db.GetDraft(ctx.Context, userId, conversationId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Bozza non trovata", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero bozza")
+		http.Error(w, "Errore recupero bozza", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(draft)
+}
+
+// Handler per cancellare la bozza dell'utente in una conversazione.
+// Si collega a database/drafts-db.go.
+func (rt *_router) deleteDraft(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, conversationId, ok := rt.parseDraftParams(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	if err := rt.db.DeleteDraft(ctx.Context, userId, conversationId); err != nil {
+		ctx.Logger.WithError(err).Error("errore cancellazione bozza")
+		http.Error(w, "Errore cancellazione bozza", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clearDraft cancella la bozza dopo che l'utente ha inviato un messaggio nella conversazione.
+// Il messaggio è già stato salvato, quindi un errore viene solo registrato.
+func (rt *_router) clearDraft(ctx reqcontext.RequestContext, userId int64, conversationId int64) {
+	if err := rt.db.DeleteDraft(ctx.Context, userId, conversationId); err != nil {
+		ctx.Logger.WithError(err).Warn("errore cancellazione bozza dopo l'invio")
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Validazione delle reazioni emoji secondo la grammatica delle sequenze emoji di Unicode (UTS #51).
+// Una reazione valida è un'unica emoji, cioè un singolo grapheme cluster: un carattere emoji eventualmente seguito
+// dal selettore di variante o, se lo ammette, da un modificatore del tono della pelle, una bandiera, un keycap, una
+// sequenza di tag oppure più elementi uniti da ZWJ. Qualunque carattere in più rende la reazione non valida.
+// Si collega a commentMessage in put-entity.go.
+
+const (
+	// maxEmojiLength limita la lunghezza in byte di una reazione; le sequenze ZWJ più lunghe restano sotto i 40 byte.
+	maxEmojiLength = 64
+
+	zeroWidthJoiner   = '\u200D'
+	variationSelector = '\uFE0F'
+	combiningKeycap   = '\u20E3'
+	blackFlag         = '\U0001F3F4'
+	cancelTag         = '\U000E007F'
+)
+
+// isValidEmoji controlla se il contenuto è una reazione emoji valida
+func isValidEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength || !utf8.ValidString(emoji) {
+		return false
+	}
+	runes := []rune(emoji)
+	return isKeycapSequence(runes) || isFlagSequence(runes) || isTagSequence(runes) || isZWJSequence(runes)
+}
+
+// isKeycapSequence riconosce i keycap, ad esempio 1️⃣ (cifra, # o *, selettore di variante opzionale, U+20E3)
+func isKeycapSequence(runes []rune) bool {
+	if len(runes) == 3 && runes[1] == variationSelector {
+		runes = []rune{runes[0], runes[2]}
+	}
+	return len(runes) == 2 && strings.ContainsRune("0123456789#*", runes[0]) && runes[1] == combiningKeycap
+}
+
+// isFlagSequence riconosce le bandiere nazionali, formate da due regional indicator (ad esempio 🇮🇹)
+func isFlagSequence(runes []rune) bool {
+	return len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1])
+}
+
+// isTagSequence riconosce le bandiere delle suddivisioni, come quella della Scozia (bandiera nera, tag, cancel tag)
+func isTagSequence(runes []rune) bool {
+	if len(runes) < 3 || runes[0] != blackFlag || runes[len(runes)-1] != cancelTag {
+		return false
+	}
+	for _, r := range runes[1 : len(runes)-1] {
+		if r < '\U000E0020' || r > '\U000E007E' {
+			return false
+		}
+	}
+	return true
+}
+
+// isZWJSequence riconosce una singola emoji o più emoji unite da zero width joiner (ad esempio 👨‍👩‍👧 o 🏳️‍🌈)
+func isZWJSequence(runes []rune) bool {
+	elements := strings.Split(string(runes), string(zeroWidthJoiner))
+	for _, element := range elements {
+		if !isEmojiElement([]rune(element)) {
+			return false
+		}
+	}
+	if len(elements) > 1 {
+		return true
+	}
+
+	// Un carattere isolato deve essere mostrato come emoji e non come testo (ad esempio ❤ senza U+FE0F è testo)
+	return len(runes) == 2 || unicode.Is(emojiPresentation, runes[0])
+}
+
+// isEmojiElement riconosce un carattere emoji seguito al più da un selettore di variante o, se ammette il tono della
+// pelle, da un modificatore
+func isEmojiElement(runes []rune) bool {
+	if len(runes) == 0 || len(runes) > 2 || !unicode.Is(extendedPictographic, runes[0]) {
+		return false
+	}
+	return len(runes) == 1 || runes[1] == variationSelector ||
+		(isEmojiModifier(runes[1]) && unicode.Is(emojiModifierBase, runes[0]))
+}
+
+// isRegionalIndicator indica se il carattere è uno dei regional indicator usati per le bandiere
+func isRegionalIndicator(r rune) bool {
+	return r >= '\U0001F1E6' && r <= '\U0001F1FF'
+}
+
+// isEmojiModifier indica se il carattere è un modificatore del tono della pelle
+func isEmojiModifier(r rune) bool {
+	return r >= '\U0001F3FB' && r <= '\U0001F3FF'
+}
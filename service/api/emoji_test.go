@@ -0,0 +1,44 @@
+package api
+
+import "testing"
+
+func TestIsValidEmoji(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		emoji string
+		valid bool
+	}{
+		{"emoji", "👍", true},
+		{"emoji with variation selector", "❤️", true},
+		{"text presentation without variation selector", "❤", false},
+		{"skin tone", "👍🏽", true},
+		{"skin tone on a modifier base from a text character", "✌🏻", true},
+		{"skin tone on a face", "😀🏿", false},
+		{"skin tone on a heart", "❤🏻", false},
+		{"skin tone alone after variation selector", "👍️🏽", false},
+		{"family", "👨‍👩‍👧", true},
+		{"rainbow flag", "🏳️‍🌈", true},
+		{"profession with skin tone", "👩🏾‍💻", true},
+		{"dangling joiner", "👍‍", false},
+		{"leading joiner", "‍👍", false},
+		{"flag", "🇮🇹", true},
+		{"single regional indicator", "🇮", false},
+		{"two flags", "🇮🇹🇫🇷", false},
+		{"keycap", "1️⃣", true},
+		{"keycap without variation selector", "#⃣", true},
+		{"keycap of a letter", "a⃣", false},
+		{"digit", "1", false},
+		{"subdivision flag", "🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"tag sequence without cancel tag", "🏴󠁧󠁢󠁳󠁣󠁴", false},
+		{"two emoji", "👍👍", false},
+		{"emoji and text", "👍ok", false},
+		{"emoji and space", "👍 ", false},
+		{"text", "ok", false},
+		{"empty", "", false},
+		{"invalid UTF-8", "\xf0\x9f\x91", false},
+	} {
+		if got := isValidEmoji(test.emoji); got != test.valid {
+			t.Errorf("%s (%q): got %v, want %v", test.name, test.emoji, got, test.valid)
+		}
+	}
+}
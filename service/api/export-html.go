@@ -0,0 +1,93 @@
+package api
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// Pagine HTML delle esportazioni, pensate per essere consultate offline.
+// Una conversazione viene scritta come intestazione, un blocco per ogni messaggio e chiusura, così può essere
+// prodotta un messaggio alla volta.
+
+// exportMessage è un messaggio esportato: la foto non è inclusa nel JSON ma indicata da Media, che è il percorso
+// del file nell'archivio o un URL "data:".
+type exportMessage struct {
+	database.Message
+	Media string `json:"media,omitempty"`
+}
+
+// newExportMessage prepara un messaggio per l'esportazione, con la foto in `media`.
+func newExportMessage(message database.Message, media string) exportMessage {
+	message.Photo = nil
+	return exportMessage{Message: message, Media: media}
+}
+
+// mediaExtension restituisce l'estensione del file per il contenuto di una foto.
+func mediaExtension(data []byte) string {
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".bin"
+	}
+}
+
+var exportTemplates = template.Must(template.New("export").Funcs(template.FuncMap{
+	// I percorsi e gli URL "data:" delle foto sono prodotti dall'esportazione, non dagli utenti
+	"mediaURL": func(media string) template.URL { return template.URL(media) },
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="it">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 48em; margin: 2em auto; color: #222; }
+.message { border-bottom: 1px solid #ddd; padding: .5em 0; }
+.meta { color: #666; font-size: .85em; }
+.forward, .reply { color: #666; font-style: italic; font-size: .85em; }
+.reactions span { margin-right: .5em; }
+img { max-width: 100%; }
+</style>
+</head>
+<body>
+{{if .Back}}<p><a href="{{.Back}}">&larr; Indice</a></p>{{end}}
+<h1>{{.Title}}</h1>
+{{end}}
+
+{{define "message"}}<div class="message" id="m{{.MessageId}}">
+<div class="meta"><b>{{.Sender.ShownName}}</b> &middot; {{.Timestamp.Format "02/01/2006 15:04"}}</div>
+{{with .ForwardedFrom}}<div class="forward">Inoltrato{{with .Sender}} da {{.ShownName}}{{end}}</div>{{end}}
+{{with .ReplyToMessageId}}<div class="reply">In risposta a <a href="#m{{.}}">un messaggio</a></div>{{end}}
+{{if .Text}}<p>{{.Text}}</p>{{end}}
+{{if .Media}}<p><img src="{{mediaURL .Media}}" alt="foto"></p>{{end}}
+{{if .Reactions}}<div class="reactions">{{range .Reactions}}<span>{{.Emoji}} {{.Count}}</span>{{end}}</div>{{end}}
+</div>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "index"}}{{template "header" .}}
+<h2>{{.Profile.ShownName}}</h2>
+{{if .ProfilePhoto}}<p><img src="{{mediaURL .ProfilePhoto}}" alt="foto profilo" style="max-width: 8em"></p>{{end}}
+<p>Username: {{.Profile.Name}}</p>
+{{if .Profile.Status}}<p>Stato: {{.Profile.Status}}</p>{{end}}
+{{if .Profile.Bio}}<p>{{.Profile.Bio}}</p>{{end}}
+<p class="meta">Esportato il {{.ExportedAt.Format "02/01/2006 15:04"}}</p>
+<h2>Conversazioni</h2>
+<ul>
+{{range .Conversations}}<li><a href="{{.Page}}">{{.Name}}</a> <span class="meta">({{.Messages}} messaggi)</span></li>
+{{else}}<li>Nessuna conversazione</li>
+{{end}}</ul>
+{{template "footer"}}{{end}}
+`))
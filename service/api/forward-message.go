@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -13,8 +14,18 @@ import (
 // Handler per inoltrare un messaggio in un'altra conversazione.
 // Controlla che l'utente sia membro sia della conversazione di origine che di destinazione.
 // Si collega a ForwardMessage in database/message.go.
+// ConversationIds permette di inoltrare lo stesso messaggio a più conversazioni in una sola richiesta;
+// ConversationId resta supportato per un singolo destinatario.
 type ForwardMessageRequest struct {
-	ConversationId int64 `json:"conversationId"`
+	ConversationId  int64   `json:"conversationId"`
+	ConversationIds []int64 `json:"conversationIds"`
+}
+
+// ForwardResult rappresenta l'esito dell'inoltro verso una singola conversazione di destinazione
+type ForwardResult struct {
+	ConversationId int64             `json:"conversationId"`
+	Message        *database.Message `json:"message,omitempty"`
+	Error          string            `json:"error,omitempty"`
 }
 
 // forwardMessage gestisce la richiesta API
@@ -32,14 +43,14 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	// Controlla se l'utente esiste
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
 
 	// Controlla se l'utente è membro della conversazione
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil || !isMember {
 		http.Error(w, "Non autorizzato", http.StatusForbidden)
 		return
@@ -53,28 +64,80 @@ func (rt *_router) forwardMessage(w http.ResponseWriter, r *http.Request, ps htt
 	}
 
 	// Recupera il messaggio originale dal database
-	originalMessage, err := rt.db.GetMessageById(messageId, conversationId)
+	originalMessage, err := rt.db.GetMessageById(ctx.Context, messageId, conversationId)
 	if err != nil {
 		http.Error(w, "Messaggio non trovato", http.StatusNotFound)
 		return
 	}
 
-	// Controlla se l'utente è membro della conversazione di destinazione
-	isMember, err = rt.db.IsUserInConversation(userId, req.ConversationId)
-	if err != nil || !isMember {
-		http.Error(w, "Non autorizzato", http.StatusForbidden)
+	// Inoltro singolo (formato originale della richiesta)
+	if len(req.ConversationIds) == 0 {
+		// Controlla se l'utente è membro della conversazione di destinazione
+		isMember, err = rt.db.IsUserInConversation(ctx.Context, userId, req.ConversationId)
+		if err != nil || !isMember {
+			http.Error(w, "Non autorizzato", http.StatusForbidden)
+			return
+		}
+
+		// Inoltra il messaggio
+		forwardedMessages, err := rt.db.ForwardMessage(ctx.Context, userId, originalMessage, []int64{req.ConversationId})
+		if err != nil {
+			http.Error(w, "Errore inoltro messaggio", http.StatusInternalServerError)
+			return
+		}
+		rt.metrics.messagesSent.Add(float64(len(forwardedMessages)), "forward")
+
+		// Risponde con il messaggio inoltrato
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(forwardedMessages[0])
 		return
 	}
 
-	// Inoltra il messaggio
-	forwardedMessage, err := rt.db.ForwardMessage(userId, originalMessage, req.ConversationId)
-	if err != nil {
-		http.Error(w, "Errore inoltro messaggio", http.StatusInternalServerError)
-		return
+	// Inoltro multiplo: le destinazioni non valide vengono segnalate singolarmente,
+	// quelle valide vengono inserite tutte insieme in un'unica transazione
+	results := make([]ForwardResult, len(req.ConversationIds))
+	var targets []int64
+	targetIndex := make(map[int64]int)
+	for i, targetId := range req.ConversationIds {
+		results[i].ConversationId = targetId
+		if _, duplicate := targetIndex[targetId]; duplicate {
+			results[i].Error = "Conversazione duplicata"
+			continue
+		}
+		isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, targetId)
+		if err != nil || !isMember {
+			results[i].Error = "Non autorizzato"
+			continue
+		}
+		targetIndex[targetId] = i
+		targets = append(targets, targetId)
+	}
+
+	if len(targets) > 0 {
+		forwardedMessages, err := rt.db.ForwardMessage(ctx.Context, userId, originalMessage, targets)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("errore inoltro messaggio")
+			http.Error(w, "Errore inoltro messaggio", http.StatusInternalServerError)
+			return
+		}
+		rt.metrics.messagesSent.Add(float64(len(forwardedMessages)), "forward")
+		for i := range forwardedMessages {
+			results[targetIndex[forwardedMessages[i].ConversationId]].Message = &forwardedMessages[i]
+		}
 	}
 
-	// Risponde con il messaggio inoltrato
+	// Risponde con l'esito per ogni destinazione
+	response := struct {
+		Results []ForwardResult `json:"results"`
+	}{
+		Results: results,
+	}
 	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(forwardedMessage)
+	if len(targets) > 0 {
+		w.WriteHeader(http.StatusCreated)
+	} else {
+		w.WriteHeader(http.StatusForbidden)
+	}
+	json.NewEncoder(w).Encode(response)
 }
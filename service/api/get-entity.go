@@ -29,26 +29,36 @@ func (rt *_router) getConversation(w http.ResponseWriter, r *http.Request, ps ht
 	}
 
 	// Controlla se l'utente esiste
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
 
 	// Recupera i messaggi dal database
-	messages, err := rt.db.GetMessagesByConversation(userId, conversationId, sortOrder)
+	messages, err := rt.db.GetMessagesByConversation(ctx.Context, userId, conversationId, sortOrder)
 	if err != nil {
 		http.Error(w, "Errore recupero messaggi", http.StatusInternalServerError)
 		return
 	}
 
+	// Membri che stanno scrivendo
+	typing, err := rt.typingMembers(ctx.Context, userId, conversationId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero utenti che stanno scrivendo")
+		http.Error(w, "Errore recupero conversazione", http.StatusInternalServerError)
+		return
+	}
+
 	// Payload della risposta
 	response := struct {
 		ConversationID int64              `json:"conversationId"`
 		Messages       []database.Message `json:"messages"`
+		Typing         []database.User    `json:"typing"`
 	}{
 		ConversationID: conversationId,
 		Messages:       messages,
+		Typing:         typing,
 	}
 
 	// Risponde con i dettagli della conversazione
@@ -69,12 +79,14 @@ func (rt *_router) getConversations(w http.ResponseWriter, r *http.Request, ps h
 	if sortOrder != "asc" && sortOrder != "desc" {
 		sortOrder = "desc"
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	conversations, err := rt.db.GetConversationsByUser(userId, sortOrder)
+	// Le conversazioni archiviate vengono incluse solo se richiesto con ?archived=true
+	includeArchived := r.URL.Query().Get("archived") == "true"
+	conversations, err := rt.db.GetConversationsByUser(ctx.Context, userId, sortOrder, includeArchived)
 	if err != nil {
 		http.Error(w, "Errore recupero conversazioni", http.StatusInternalServerError)
 		return
@@ -93,12 +105,12 @@ func (rt *_router) getGroupMembers(w http.ResponseWriter, r *http.Request, ps ht
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	isMember, err := rt.db.IsUserMemberOfGroup(userId, groupId)
+	isMember, err := rt.db.IsUserMemberOfGroup(ctx.Context, userId, groupId)
 	if err != nil || !isMember {
 		http.Error(w, "Non autorizzato", http.StatusForbidden)
 		return
 	}
-	members, err := rt.db.GetGroupMembers(groupId)
+	members, err := rt.db.GetGroupMembers(ctx.Context, groupId)
 	if err != nil {
 		http.Error(w, "Errore recupero membri", http.StatusInternalServerError)
 		return
@@ -124,7 +136,7 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httpro
 	username := r.URL.Query().Get("username")
 
 	// Controlla se l'utente esiste
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("utente non trovato")
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
@@ -132,13 +144,22 @@ func (rt *_router) searchUsers(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 
 	// Esegue la ricerca nel database per username
-	users, err := rt.db.SearchUsersByUsername(username)
+	users, err := rt.db.SearchUsersByUsername(ctx.Context, username)
 	if err != nil {
 		ctx.Logger.WithError(err).Error("errore nella ricerca utenti nel database")
 		http.Error(w, "Errore ricerca utenti", http.StatusInternalServerError)
 		return
 	}
 
+	// Applica la privacy di ciascun utente a foto, stato online e ultimo accesso
+	for i := range users {
+		if err := rt.applyPrivacy(ctx.Context, userId, &users[i]); err != nil {
+			ctx.Logger.WithError(err).Error("errore recupero ultimo accesso")
+			http.Error(w, "Errore ricerca utenti", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// Restituisce l'elenco degli utenti corrispondenti
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(users); err != nil {
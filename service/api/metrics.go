@@ -0,0 +1,88 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/metrics"
+	"github.com/felixge/httpsnoop"
+	"github.com/julienschmidt/httprouter"
+)
+
+// dbBuckets are the upper bounds, in seconds, of the buckets of the histogram of the database calls, most of which
+// take less than a millisecond.
+var dbBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// apiMetrics are the metrics of the API, exported by the debug server of webapi.
+type apiMetrics struct {
+	// requests and requestDuration are labelled with the route registered in Handler(), such as
+	// /users/:userId/profile, instead of the path of the request
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+
+	dbDuration *metrics.Histogram
+
+	// messagesSent is labelled with how the message was sent: "message", "reply", "forward" or "scheduled"
+	messagesSent *metrics.Counter
+	usersCreated *metrics.Counter
+}
+
+// newAPIMetrics registers the metrics of the API in registry.
+func newAPIMetrics(registry *metrics.Registry) *apiMetrics {
+	return &apiMetrics{
+		requests: registry.NewCounter("wasatext_http_requests_total",
+			"HTTP requests served, by route, method and status code.", "route", "method", "code"),
+		requestDuration: registry.NewHistogram("wasatext_http_request_duration_seconds",
+			"Time taken to serve the HTTP requests, by route and method.", metrics.DefaultBuckets, "route", "method"),
+		dbDuration: registry.NewHistogram("wasatext_db_call_duration_seconds",
+			"Time taken by the calls to the database, by method of AppDatabase.", dbBuckets, "method"),
+		messagesSent: registry.NewCounter("wasatext_messages_sent_total",
+			"Messages sent, by kind: message, reply, forward or scheduled.", "kind"),
+		usersCreated: registry.NewCounter("wasatext_users_created_total",
+			"Users created by logging in with a new name."),
+	}
+}
+
+// instrument returns db reporting the duration of its calls to dbDuration.
+func (m *apiMetrics) instrument(db database.AppDatabase) database.AppDatabase {
+	return database.Instrument(db, func(method string, elapsed time.Duration, _ error) {
+		m.dbDuration.Observe(elapsed.Seconds(), method)
+	})
+}
+
+// instrumentedRouter is the httprouter.Router of the API, recording the metrics of the requests of every route it
+// registers.
+type instrumentedRouter struct {
+	*httprouter.Router
+	metrics *apiMetrics
+}
+
+// Handle registers handle for the requests with method and path, as httprouter.Router.Handle does. The GET, POST,
+// PUT and DELETE shortcuts below use it too.
+func (r *instrumentedRouter) Handle(method string, path string, handle httprouter.Handle) {
+	r.Router.Handle(method, path, func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+		served := httpsnoop.CaptureMetricsFn(w, func(w http.ResponseWriter) {
+			handle(w, req, ps)
+		})
+		r.metrics.requests.Inc(path, method, strconv.Itoa(served.Code))
+		r.metrics.requestDuration.Observe(served.Duration.Seconds(), path, method)
+	})
+}
+
+func (r *instrumentedRouter) GET(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodGet, path, handle)
+}
+
+func (r *instrumentedRouter) POST(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPost, path, handle)
+}
+
+func (r *instrumentedRouter) PUT(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodPut, path, handle)
+}
+
+func (r *instrumentedRouter) DELETE(path string, handle httprouter.Handle) {
+	r.Handle(http.MethodDelete, path, handle)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Handler per fissare un messaggio in una conversazione.
+// Controlla che l'utente sia membro della conversazione e che il messaggio esista, poi chiama PinMessage.
+// Il numero di messaggi fissati per conversazione è limitato da Config.MaxPinnedMessages.
+// Si collega a database/pinned-messages-db.go.
+func (rt *_router) pinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	messageId, _ := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 || messageId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	_, err = rt.db.GetMessageById(ctx.Context, messageId, conversationId)
+	if err != nil {
+		http.Error(w, "Messaggio non trovato", http.StatusNotFound)
+		return
+	}
+	pinned, err := rt.db.PinMessage(ctx.Context, conversationId, messageId, userId, rt.maxPinnedMessages)
+	if errors.Is(err, database.ErrPinLimitReached) {
+		http.Error(w, "Numero massimo di messaggi fissati raggiunto", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore nel fissare il messaggio")
+		http.Error(w, "Errore nel fissare il messaggio", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(pinned)
+}
+
+// Handler per togliere un messaggio dai messaggi fissati.
+// Controlla che l'utente sia membro della conversazione e chiama UnpinMessage.
+// Si collega a database/pinned-messages-db.go.
+func (rt *_router) unpinMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	messageId, _ := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 || messageId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	if err := rt.db.UnpinMessage(ctx.Context, conversationId, messageId); err != nil {
+		ctx.Logger.WithError(err).Error("errore nel togliere il messaggio fissato")
+		http.Error(w, "Errore nel togliere il messaggio fissato", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler per ottenere i messaggi fissati di una conversazione.
+// Controlla che l'utente sia membro della conversazione e chiama GetPinnedMessages.
+// Si collega a database/pinned-messages-db.go.
+func (rt *_router) getPinnedMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	pinned, err := rt.db.GetPinnedMessages(ctx.Context, conversationId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero messaggi fissati")
+		http.Error(w, "Errore recupero messaggi fissati", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pinned)
+}
@@ -38,7 +38,7 @@ func (rt *_router) postSession(w http.ResponseWriter, r *http.Request, ps httpro
 		http.Error(w, "Nome non conforme", http.StatusBadRequest)
 		return
 	}
-	existingUser, err := rt.db.GetUserByName(body.Name)
+	existingUser, err := rt.db.GetUserByName(ctx.Context, body.Name)
 	if err != nil {
 		http.Error(w, "Errore ricerca utente", http.StatusInternalServerError)
 		return
@@ -50,11 +50,12 @@ func (rt *_router) postSession(w http.ResponseWriter, r *http.Request, ps httpro
 		json.NewEncoder(w).Encode(response)
 		return
 	}
-	newUser, err := rt.db.CreateUser(body.Name)
+	newUser, err := rt.db.CreateUser(ctx.Context, body.Name)
 	if err != nil {
 		http.Error(w, "Errore creazione utente", http.StatusInternalServerError)
 		return
 	}
+	rt.metrics.usersCreated.Inc()
 	response := UserResponse{Id: newUser.UserId}
 	w.Header().Set("content-type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -71,12 +72,12 @@ func (rt *_router) postMessage(w http.ResponseWriter, r *http.Request, ps httpro
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil || !isMember {
 		http.Error(w, "Non autorizzato", http.StatusForbidden)
 		return
@@ -94,22 +95,26 @@ func (rt *_router) postMessage(w http.ResponseWriter, r *http.Request, ps httpro
 			http.Error(w, "Errore lettura foto", http.StatusInternalServerError)
 			return
 		}
-		newMessage, err := rt.db.AddMessage(conversationId, userId, "", "received", "photo", photoBytes)
+		newMessage, err := rt.db.AddMessage(ctx.Context, conversationId, userId, "", "received", "photo", photoBytes)
 		if err != nil {
 			http.Error(w, "Errore salvataggio foto", http.StatusInternalServerError)
 			return
 		}
+		rt.metrics.messagesSent.Inc("message")
+		rt.clearDraft(ctx, userId, conversationId)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(newMessage)
 		return
 	}
 	if content != "" {
-		newMessage, err := rt.db.AddMessage(conversationId, userId, content, "received", "text", nil)
+		newMessage, err := rt.db.AddMessage(ctx.Context, conversationId, userId, content, "received", "text", nil)
 		if err != nil {
 			http.Error(w, "Errore salvataggio messaggio", http.StatusInternalServerError)
 			return
 		}
+		rt.metrics.messagesSent.Inc("message")
+		rt.clearDraft(ctx, userId, conversationId)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(newMessage)
@@ -127,12 +132,12 @@ func (rt *_router) replyMessage(w http.ResponseWriter, r *http.Request, ps httpr
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil || !isMember {
 		http.Error(w, "Non autorizzato", http.StatusForbidden)
 		return
@@ -150,22 +155,26 @@ func (rt *_router) replyMessage(w http.ResponseWriter, r *http.Request, ps httpr
 			http.Error(w, "Errore lettura foto", http.StatusInternalServerError)
 			return
 		}
-		newMessage, err := rt.db.ReplyMessage(conversationId, userId, messageId, "", "received", "photo", photoBytes)
+		newMessage, err := rt.db.ReplyMessage(ctx.Context, conversationId, userId, messageId, "", "received", "photo", photoBytes)
 		if err != nil {
 			http.Error(w, "Errore salvataggio risposta", http.StatusInternalServerError)
 			return
 		}
+		rt.metrics.messagesSent.Inc("reply")
+		rt.clearDraft(ctx, userId, conversationId)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(newMessage)
 		return
 	}
 	if content != "" {
-		newMessage, err := rt.db.ReplyMessage(conversationId, userId, messageId, content, "received", "text", nil)
+		newMessage, err := rt.db.ReplyMessage(ctx.Context, conversationId, userId, messageId, content, "received", "text", nil)
 		if err != nil {
 			http.Error(w, "Errore salvataggio risposta", http.StatusInternalServerError)
 			return
 		}
+		rt.metrics.messagesSent.Inc("reply")
+		rt.clearDraft(ctx, userId, conversationId)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(newMessage)
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// Size of the load of TestConcurrentPostMessage: each client posts its messages while another one lists the
+// conversations, and the command line tools write to the same file.
+const (
+	concurrentClients  = 16
+	messagesPerClient  = 25
+	concurrentListings = 25
+	externalWrites     = 50
+)
+
+// postText sends a text message as userId, and returns the status code.
+func (s *testServer) postText(userId int64, conversationId int64, text string) (int, error) {
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/users/%d/conversations/%d/messages/", s.URL, userId, conversationId),
+		strings.NewReader(url.Values{"content": {text}}.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", strconv.FormatInt(userId, 10))
+	resp, err := s.Client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// TestConcurrentPostMessage sends messages to the same group from many clients at once through postMessage, while
+// other clients list the conversations and a second pool on the same file, like the one of wasatext-admin, writes
+// too. No request may fail, for example with "database is locked", and every message must be saved.
+func TestConcurrentPostMessage(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	members := make([]int64, concurrentClients)
+	for i := range members {
+		members[i] = s.login(t, fmt.Sprintf("client%d", i))
+	}
+	group, err := s.db.CreateConversation(ctx, members[0], members[1], "group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, member := range members[2:] {
+		if err := s.db.AddUserToGroup(ctx, group.ConversationId, member); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writer, reader, err := database.OpenSQLite(ctx, s.filename, database.DefaultSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	}()
+	external, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*concurrentClients+1)
+	for _, member := range members {
+		wg.Add(2)
+		go func(userId int64) {
+			defer wg.Done()
+			for i := 0; i < messagesPerClient; i++ {
+				code, err := s.postText(userId, group.ConversationId, fmt.Sprintf("message %d", i))
+				if err != nil || code != http.StatusCreated {
+					errs <- fmt.Errorf("sending message %d: status %d, %v", i, code, err)
+					return
+				}
+			}
+		}(member)
+		go func(userId int64) {
+			defer wg.Done()
+			for i := 0; i < concurrentListings; i++ {
+				resp, err := s.request(http.MethodGet, fmt.Sprintf("/users/%d/conversations/", userId), userId, nil)
+				if err != nil {
+					errs <- fmt.Errorf("listing conversations: %w", err)
+					return
+				}
+				_ = resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					errs <- fmt.Errorf("listing conversations: status %d", resp.StatusCode)
+					return
+				}
+			}
+		}(member)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < externalWrites; i++ {
+			if _, err := external.AddMessage(ctx, group.ConversationId, members[0], "from the tools", "received", "text", nil); err != nil {
+				errs <- fmt.Errorf("writing from another pool: %w", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	messages, err := s.db.GetMessagesByConversation(ctx, members[0], group.ConversationId, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := concurrentClients*messagesPerClient + externalWrites; len(messages) != want {
+		t.Fatalf("got %d messages, want %d", len(messages), want)
+	}
+	problems, err := s.db.CheckConversations(ctx, group.ConversationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("conversation has problems: %v", problems)
+	}
+}
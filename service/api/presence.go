@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// presenceTimeout è il tempo senza richieste dopo cui un utente è considerato offline
+	presenceTimeout = time.Minute
+
+	// typingTTL è la durata dell'indicatore "sta scrivendo" se il client non lo rinnova
+	typingTTL = 5 * time.Second
+)
+
+// presenceTracker tiene in memoria gli utenti online e chi sta scrivendo in ogni conversazione.
+// L'ultimo accesso viene salvato nel database solo quando l'utente va offline (vedi trackPresence).
+type presenceTracker struct {
+	mu sync.Mutex
+
+	// lastActivity è l'ora dell'ultima richiesta autenticata degli utenti online
+	lastActivity map[int64]time.Time
+
+	// typing associa a ogni conversazione gli utenti che stanno scrivendo, con la scadenza dell'indicatore
+	typing map[int64]map[int64]time.Time
+}
+
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{
+		lastActivity: make(map[int64]time.Time),
+		typing:       make(map[int64]map[int64]time.Time),
+	}
+}
+
+// touch registra un'attività dell'utente.
+func (p *presenceTracker) touch(userId int64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastActivity[userId] = now
+}
+
+// lastActivityOf restituisce l'ultima attività dell'utente, se è online.
+func (p *presenceTracker) lastActivityOf(userId int64, now time.Time) (time.Time, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last, ok := p.lastActivity[userId]
+	if !ok || now.Sub(last) > presenceTimeout {
+		return time.Time{}, false
+	}
+	return last, true
+}
+
+// forget dimentica un utente, ad esempio perché ha cancellato l'account.
+func (p *presenceTracker) forget(userId int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.lastActivity, userId)
+	for conversationId, users := range p.typing {
+		delete(users, userId)
+		if len(users) == 0 {
+			delete(p.typing, conversationId)
+		}
+	}
+}
+
+// setTyping segna che l'utente sta scrivendo nella conversazione, fino a typingTTL da `now`.
+func (p *presenceTracker) setTyping(conversationId int64, userId int64, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.typing[conversationId] == nil {
+		p.typing[conversationId] = make(map[int64]time.Time)
+	}
+	p.typing[conversationId][userId] = now.Add(typingTTL)
+}
+
+// stopTyping toglie l'indicatore "sta scrivendo" dell'utente nella conversazione.
+func (p *presenceTracker) stopTyping(conversationId int64, userId int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.typing[conversationId], userId)
+	if len(p.typing[conversationId]) == 0 {
+		delete(p.typing, conversationId)
+	}
+}
+
+// typingUsers restituisce gli utenti che stanno scrivendo nella conversazione, in ordine di id.
+func (p *presenceTracker) typingUsers(conversationId int64, now time.Time) []int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var userIds []int64
+	for userId, expiration := range p.typing[conversationId] {
+		if expiration.After(now) {
+			userIds = append(userIds, userId)
+		}
+	}
+	sort.Slice(userIds, func(i, j int) bool { return userIds[i] < userIds[j] })
+	return userIds
+}
+
+// expire rimuove gli utenti inattivi da più di presenceTimeout (tutti, se `all` è vero) e gli indicatori scaduti,
+// e restituisce l'ultima attività degli utenti andati offline.
+func (p *presenceTracker) expire(now time.Time, all bool) map[int64]time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offline := make(map[int64]time.Time)
+	for userId, last := range p.lastActivity {
+		if all || now.Sub(last) > presenceTimeout {
+			offline[userId] = last
+			delete(p.lastActivity, userId)
+		}
+	}
+	for conversationId, users := range p.typing {
+		for userId, expiration := range users {
+			if !expiration.After(now) {
+				delete(users, userId)
+			}
+		}
+		if len(users) == 0 {
+			delete(p.typing, conversationId)
+		}
+	}
+	return offline
+}
+
+// trackPresence salva periodicamente l'ultimo accesso degli utenti andati offline, finché Close() non ferma il
+// router; alla chiusura salva quello di tutti gli utenti ancora online.
+func (rt *_router) trackPresence() {
+	defer rt.background.Done()
+	ticker := time.NewTicker(presenceTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rt.stopBackground:
+			// Il contesto dei task in background è già annullato: l'ultimo salvataggio deve comunque avvenire
+			rt.saveLastSeen(context.Background(), rt.presence.expire(globaltime.Now(), true))
+			return
+		case <-ticker.C:
+			rt.saveLastSeen(rt.backgroundCtx, rt.presence.expire(globaltime.Now(), false))
+		}
+	}
+}
+
+// saveLastSeen salva nel database l'ultimo accesso degli utenti indicati.
+func (rt *_router) saveLastSeen(ctx context.Context, offline map[int64]time.Time) {
+	for userId, lastSeen := range offline {
+		if err := rt.db.UpdateLastSeen(ctx, userId, lastSeen); err != nil {
+			rt.baseLogger.WithError(err).WithField("user_id", userId).Error("error saving last seen")
+		}
+	}
+}
+
+// Handler per segnalare che l'utente sta scrivendo in una conversazione.
+// Il client lo ripete mentre l'utente scrive: l'indicatore scade dopo typingTTL.
+func (rt *_router) startTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, conversationId, ok := rt.parseTypingParams(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	rt.presence.setTyping(conversationId, userId, globaltime.Now())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler per segnalare che l'utente ha smesso di scrivere in una conversazione.
+func (rt *_router) stopTyping(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, conversationId, ok := rt.parseTypingParams(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	rt.presence.stopTyping(conversationId, userId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTypingParams legge gli id dal percorso e controlla che l'utente sia membro della conversazione;
+// in caso di errore scrive la risposta e restituisce false.
+func (rt *_router) parseTypingParams(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (int64, int64, bool) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return 0, 0, false
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return 0, 0, false
+	}
+	return userId, conversationId, true
+}
+
+// typingMembers restituisce gli altri membri che stanno scrivendo nella conversazione.
+func (rt *_router) typingMembers(ctx context.Context, userId int64, conversationId int64) ([]database.User, error) {
+	typing := []database.User{}
+	for _, typingId := range rt.presence.typingUsers(conversationId, globaltime.Now()) {
+		if typingId == userId {
+			continue
+		}
+		user, err := rt.db.GetUserById(ctx, typingId)
+		if err != nil {
+			return nil, err
+		}
+		typing = append(typing, database.User{UserId: user.UserId, Name: user.Name, DisplayName: user.DisplayName})
+	}
+	return typing, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// Lunghezze massime, in caratteri, dei campi del profilo
+	maxDisplayNameLength = 32
+	maxBioLength         = 140
+	maxStatusLength      = 64
+)
+
+// Handler per modificare il profilo dell'utente (nome visualizzato, bio e stato).
+// I campi assenti restano invariati; una stringa vuota li cancella.
+// Si collega a database/profile-db.go.
+func (rt *_router) setMyProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	var body database.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	if body.DisplayName != nil {
+		displayName := strings.TrimSpace(*body.DisplayName)
+		body.DisplayName = &displayName
+	}
+	if !validProfileField(body.DisplayName, maxDisplayNameLength) || !validProfileField(body.Bio, maxBioLength) ||
+		!validProfileField(body.Status, maxStatusLength) {
+		http.Error(w, "Profilo non valido", http.StatusBadRequest)
+		return
+	}
+	profile, err := rt.db.UpdateUserProfile(ctx.Context, userId, body)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore aggiornamento profilo")
+		http.Error(w, "Errore aggiornamento profilo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
+
+// validProfileField controlla la lunghezza di un campo del profilo, se presente.
+func validProfileField(value *string, maxLength int) bool {
+	return value == nil || (utf8.ValidString(*value) && utf8.RuneCountInString(*value) <= maxLength)
+}
+
+// Handler per ottenere il profilo di un utente; foto, stato online e ultimo accesso dipendono dalla sua privacy.
+// Si collega a database/profile-db.go.
+func (rt *_router) getProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	targetId, _ := strconv.ParseInt(ps.ByName("targetId"), 10, 64)
+	if userId <= 0 || targetId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	profile, err := rt.db.GetUserProfile(ctx.Context, targetId)
+	if err != nil {
+		http.Error(w, "Utente non trovato", http.StatusNotFound)
+		return
+	}
+	if err := rt.applyPrivacy(ctx.Context, userId, &profile.User); err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero ultimo accesso")
+		http.Error(w, "Errore recupero profilo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(profile)
+}
@@ -7,7 +7,6 @@ import (
 	"net/http"
 	"regexp"
 	"strconv"
-	"unicode/utf8"
 
 	"github.com/Mortifer97/WASAText/service/api/reqcontext"
 	"github.com/julienschmidt/httprouter"
@@ -29,12 +28,12 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	isMember, err := rt.db.IsUserInConversation(userId, conversationId)
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
 	if err != nil || !isMember {
 		http.Error(w, "Non autorizzato", http.StatusForbidden)
 		return
@@ -48,12 +47,12 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 		http.Error(w, "Formato emoji non valido", http.StatusBadRequest)
 		return
 	}
-	_, err = rt.db.GetMessageById(messageId, conversationId)
+	_, err = rt.db.GetMessageById(ctx.Context, messageId, conversationId)
 	if err != nil {
 		http.Error(w, "Messaggio non trovato", http.StatusNotFound)
 		return
 	}
-	newComment, err := rt.db.AddCommentToMessage(messageId, userId, commentRequest.Content)
+	newComment, err := rt.db.AddCommentToMessage(ctx.Context, messageId, userId, commentRequest.Content)
 	if err != nil {
 		http.Error(w, "Errore aggiunta commento", http.StatusInternalServerError)
 		return
@@ -63,18 +62,6 @@ func (rt *_router) commentMessage(w http.ResponseWriter, r *http.Request, ps htt
 	json.NewEncoder(w).Encode(newComment)
 }
 
-// isValidEmoji controlla se il contenuto è una reazione emoji valida
-func isValidEmoji(emoji string) bool {
-	r, _ := utf8.DecodeRuneInString(emoji)
-	if (r >= '\U0001F600' && r <= '\U0001F64F') ||
-		(r >= '\U0001F300' && r <= '\U0001F5FF') ||
-		(r >= '\U0001F680' && r <= '\U0001F6FF') ||
-		(r >= '\U0001F700' && r <= '\U0001F77F') {
-		return true
-	}
-	return false
-}
-
 // Handler per avviare una nuova conversazione (diretta o gruppo).
 // Controlla che l'utente e il target esistano, poi chiama CreateConversation.
 // Si collega a database/conversation.go.
@@ -84,7 +71,7 @@ func (rt *_router) addConversation(w http.ResponseWriter, r *http.Request, ps ht
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	user, err := rt.db.GetUserById(userId)
+	user, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
@@ -97,8 +84,8 @@ func (rt *_router) addConversation(w http.ResponseWriter, r *http.Request, ps ht
 		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
 		return
 	}
-	targetUser, err := rt.db.GetUserByName(body.TargetUsername)
-	if err != nil {
+	targetUser, err := rt.db.GetUserByName(ctx.Context, body.TargetUsername)
+	if err != nil || targetUser == nil {
 		http.Error(w, "Utente target non trovato", http.StatusNotFound)
 		return
 	}
@@ -106,7 +93,18 @@ func (rt *_router) addConversation(w http.ResponseWriter, r *http.Request, ps ht
 		http.Error(w, "Tipo conversazione non valido", http.StatusBadRequest)
 		return
 	}
-	conversation, err := rt.db.CreateConversation(user.UserId, targetUser.UserId, body.Type)
+	// La privacy dell'utente target decide chi può avviare una chat diretta con lui o aggiungerlo a un gruppo
+	allowed, err := rt.allowedBy(ctx.Context, targetUser.UserId, user.UserId, body.Type)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore controllo privacy")
+		http.Error(w, "Errore creazione conversazione", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "L'utente non accetta questa conversazione", http.StatusForbidden)
+		return
+	}
+	conversation, err := rt.db.CreateConversation(ctx.Context, user.UserId, targetUser.UserId, body.Type)
 	if err != nil {
 		http.Error(w, "Errore creazione conversazione", http.StatusInternalServerError)
 		return
@@ -137,22 +135,22 @@ func (rt *_router) setGroupName(w http.ResponseWriter, r *http.Request, ps httpr
 		http.Error(w, "Nome gruppo non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	group, err := rt.db.GetGroupById(int64(groupId))
+	group, err := rt.db.GetGroupById(ctx.Context, int64(groupId))
 	if err != nil {
 		http.Error(w, "Gruppo non trovato", http.StatusNotFound)
 		return
 	}
-	isMember, err := rt.db.IsUserMemberOfGroup(userId, int64(groupId))
+	isMember, err := rt.db.IsUserMemberOfGroup(ctx.Context, userId, int64(groupId))
 	if err != nil || !isMember {
 		http.Error(w, "Non sei membro del gruppo", http.StatusNotFound)
 		return
 	}
-	err = rt.db.UpdateGroupName(int64(groupId), requestBody.Name)
+	err = rt.db.UpdateGroupName(ctx.Context, int64(groupId), requestBody.Name)
 	if err != nil {
 		http.Error(w, "Errore aggiornamento nome gruppo", http.StatusInternalServerError)
 		return
@@ -186,17 +184,17 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	group, err := rt.db.GetGroupById(int64(groupId))
+	group, err := rt.db.GetGroupById(ctx.Context, int64(groupId))
 	if err != nil {
 		http.Error(w, "Gruppo non trovato", http.StatusNotFound)
 		return
 	}
-	isMember, err := rt.db.IsUserMemberOfGroup(userId, int64(groupId))
+	isMember, err := rt.db.IsUserMemberOfGroup(ctx.Context, userId, int64(groupId))
 	if err != nil || !isMember {
 		http.Error(w, "Non sei membro del gruppo", http.StatusNotFound)
 		return
@@ -217,7 +215,7 @@ func (rt *_router) setGroupPhoto(w http.ResponseWriter, r *http.Request, ps http
 		http.Error(w, "Errore lettura foto", http.StatusInternalServerError)
 		return
 	}
-	err = rt.db.UpdateGroupPhoto(group.ConversationId, photoData)
+	err = rt.db.UpdateGroupPhoto(ctx.Context, group.ConversationId, photoData)
 	if err != nil {
 		http.Error(w, "Errore aggiornamento foto gruppo", http.StatusInternalServerError)
 		return
@@ -243,7 +241,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	user, err := rt.db.GetUserById(userId)
+	user, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
@@ -264,7 +262,7 @@ func (rt *_router) setMyPhoto(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Errore lettura foto", http.StatusInternalServerError)
 		return
 	}
-	err = rt.db.UpdateUserPhoto(user.UserId, photoData)
+	err = rt.db.UpdateUserPhoto(ctx.Context, user.UserId, photoData)
 	if err != nil {
 		http.Error(w, "Errore aggiornamento foto", http.StatusInternalServerError)
 		return
@@ -294,7 +292,7 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Id non valido", http.StatusBadRequest)
 		return
 	}
-	_, err := rt.db.GetUserById(userId)
+	_, err := rt.db.GetUserById(ctx.Context, userId)
 	if err != nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
@@ -306,17 +304,27 @@ func (rt *_router) addToGroup(w http.ResponseWriter, r *http.Request, ps httprou
 		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
 		return
 	}
-	existingUser, err := rt.db.GetUserByName(body.Username)
-	if err != nil {
+	existingUser, err := rt.db.GetUserByName(ctx.Context, body.Username)
+	if err != nil || existingUser == nil {
 		http.Error(w, "Utente non trovato", http.StatusNotFound)
 		return
 	}
-	_, err = rt.db.GetGroupById(int64(groupId))
+	_, err = rt.db.GetGroupById(ctx.Context, int64(groupId))
 	if err != nil {
 		http.Error(w, "Gruppo non trovato", http.StatusNotFound)
 		return
 	}
-	if err := rt.db.AddUserToGroup(int64(groupId), existingUser.UserId); err != nil {
+	allowed, err := rt.allowedBy(ctx.Context, existingUser.UserId, userId, "group")
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore controllo privacy")
+		http.Error(w, "Errore aggiunta utente", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "L'utente non accetta di essere aggiunto ai gruppi", http.StatusForbidden)
+		return
+	}
+	if err := rt.db.AddUserToGroup(ctx.Context, int64(groupId), existingUser.UserId); err != nil {
 		http.Error(w, "Errore aggiunta utente", http.StatusInternalServerError)
 		return
 	}
@@ -366,7 +374,7 @@ func (rt *_router) putUsername(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 
 	// Verifica se il nome utente esiste già
-	existingUser, err := rt.db.GetUserByName(body.Username)
+	existingUser, err := rt.db.GetUserByName(ctx.Context, body.Username)
 	if err != nil {
 		http.Error(w, "Errore ricerca username", http.StatusInternalServerError)
 		return
@@ -379,7 +387,7 @@ func (rt *_router) putUsername(w http.ResponseWriter, r *http.Request, ps httpro
 	}
 
 	// Aggiorna il nome dell'utente nel database
-	err = rt.db.UpdateUsername(userId, body.Username)
+	err = rt.db.UpdateUsername(ctx.Context, userId, body.Username)
 	if err != nil {
 		http.Error(w, "Errore aggiornamento username", http.StatusInternalServerError)
 		return
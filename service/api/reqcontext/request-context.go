@@ -7,6 +7,8 @@ Each value here should be assumed valid only per request only, with some excepti
 package reqcontext
 
 import (
+	"context"
+
 	"github.com/gofrs/uuid"
 	"github.com/sirupsen/logrus"
 )
@@ -18,4 +20,11 @@ type RequestContext struct {
 
 	// Logger is a custom field logger for the request
 	Logger logrus.FieldLogger
+
+	// Context is cancelled when the client goes away, when the server shuts down or when the query timeout of the
+	// request expires; it is passed to every database call made for the request
+	Context context.Context
+
+	// UserID is the user named by the Authorization header, set by AuthHandler for the authenticated routes
+	UserID int64
 }
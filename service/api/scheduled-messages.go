@@ -0,0 +1,234 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxScheduleAhead è il massimo anticipo con cui si può programmare un messaggio
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// ScheduledMessageUpdateRequest rappresenta il payload per modificare un messaggio programmato.
+// I campi assenti restano invariati; il testo si può cambiare solo nei messaggi di testo.
+type ScheduledMessageUpdateRequest struct {
+	Text   *string    `json:"text"`
+	SendAt *time.Time `json:"sendAt"`
+}
+
+// validSendAt controlla che l'orario di invio sia nel futuro e non troppo lontano.
+func validSendAt(sendAt time.Time) bool {
+	now := globaltime.Now()
+	return sendAt.After(now) && sendAt.Sub(now) <= maxScheduleAhead
+}
+
+// Handler per programmare un messaggio (testo o foto) da inviare all'orario "sendAt" (RFC 3339).
+// Il contenuto arriva nel campo "content" come in postMessage.
+// Si collega a database/scheduled-messages-db.go.
+func (rt *_router) scheduleMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	sendAt, err := time.Parse(time.RFC3339, r.FormValue("sendAt"))
+	if err != nil || !validSendAt(sendAt) {
+		http.Error(w, "Orario di invio non valido", http.StatusBadRequest)
+		return
+	}
+	scheduled := database.ScheduledMessage{
+		ConversationId: conversationId,
+		SenderId:       userId,
+		SendAt:         sendAt,
+	}
+	content := r.FormValue("content")
+	if content == "" {
+		file, _, err := r.FormFile("content")
+		if err != nil {
+			http.Error(w, "Foto non valida", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		photoBytes, err := io.ReadAll(file)
+		if err != nil {
+			http.Error(w, "Errore lettura foto", http.StatusInternalServerError)
+			return
+		}
+		scheduled.Type = "photo"
+		scheduled.Photo = photoBytes
+	} else {
+		scheduled.Type = "text"
+		scheduled.Text = content
+	}
+	scheduled, err = rt.db.CreateScheduledMessage(ctx.Context, scheduled)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore salvataggio messaggio programmato")
+		http.Error(w, "Errore salvataggio messaggio programmato", http.StatusInternalServerError)
+		return
+	}
+	rt.clearDraft(ctx, userId, conversationId)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// Handler per ottenere i messaggi programmati dall'utente in una conversazione e non ancora inviati.
+// Si collega a database/scheduled-messages-db.go.
+func (rt *_router) getScheduledMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	scheduledMessages, err := rt.db.GetScheduledMessages(ctx.Context, userId, conversationId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero messaggi programmati")
+		http.Error(w, "Errore recupero messaggi programmati", http.StatusInternalServerError)
+		return
+	}
+	if scheduledMessages == nil {
+		scheduledMessages = []database.ScheduledMessage{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduledMessages)
+}
+
+// getOwnScheduledMessage legge gli id dal percorso e restituisce il messaggio programmato, se appartiene all'utente
+// e alla conversazione indicati; altrimenti scrive la risposta di errore e restituisce false.
+func (rt *_router) getOwnScheduledMessage(ctx context.Context, w http.ResponseWriter, ps httprouter.Params) (database.ScheduledMessage, bool) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	conversationId, _ := strconv.ParseInt(ps.ByName("conversationId"), 10, 64)
+	scheduledId, _ := strconv.ParseInt(ps.ByName("scheduledId"), 10, 64)
+	if userId <= 0 || conversationId <= 0 || scheduledId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return database.ScheduledMessage{}, false
+	}
+	scheduled, err := rt.db.GetScheduledMessageById(ctx, scheduledId)
+	if err != nil || scheduled.ConversationId != conversationId {
+		http.Error(w, "Messaggio programmato non trovato", http.StatusNotFound)
+		return database.ScheduledMessage{}, false
+	}
+	if scheduled.SenderId != userId {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return database.ScheduledMessage{}, false
+	}
+	return scheduled, true
+}
+
+// Handler per modificare il testo o l'orario di invio di un messaggio programmato non ancora inviato.
+// Si collega a database/scheduled-messages-db.go.
+func (rt *_router) updateScheduledMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	scheduled, ok := rt.getOwnScheduledMessage(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	var body ScheduledMessageUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	if body.Text != nil {
+		if scheduled.Type != "text" || *body.Text == "" {
+			http.Error(w, "Testo non valido", http.StatusBadRequest)
+			return
+		}
+		scheduled.Text = *body.Text
+	}
+	if body.SendAt != nil {
+		if !validSendAt(*body.SendAt) {
+			http.Error(w, "Orario di invio non valido", http.StatusBadRequest)
+			return
+		}
+		scheduled.SendAt = body.SendAt.UTC()
+	}
+	if err := rt.db.UpdateScheduledMessage(ctx.Context, scheduled.ScheduledId, scheduled.Text, scheduled.SendAt); err != nil {
+		ctx.Logger.WithError(err).Error("errore modifica messaggio programmato")
+		http.Error(w, "Errore modifica messaggio programmato", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(scheduled)
+}
+
+// Handler per annullare un messaggio programmato non ancora inviato.
+// Si collega a database/scheduled-messages-db.go.
+func (rt *_router) cancelScheduledMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	scheduled, ok := rt.getOwnScheduledMessage(ctx.Context, w, ps)
+	if !ok {
+		return
+	}
+	deleted, err := rt.db.DeleteScheduledMessage(ctx.Context, scheduled.ScheduledId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore annullamento messaggio programmato")
+		http.Error(w, "Errore annullamento messaggio programmato", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		// Lo scheduler l'ha già inviato nel frattempo
+		http.Error(w, "Messaggio programmato non trovato", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deliverScheduledMessages periodicamente invia i messaggi programmati giunti all'orario di invio, finché Close()
+// non ferma il router. I messaggi sono salvati nel database, quindi quelli scaduti durante un riavvio vengono
+// inviati al primo controllo.
+func (rt *_router) deliverScheduledMessages(interval time.Duration) {
+	defer rt.background.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rt.sendDueScheduledMessages(rt.backgroundCtx, globaltime.Now())
+		select {
+		case <-rt.stopBackground:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendDueScheduledMessages invia con SendScheduledMessage i messaggi programmati con orario di invio non successivo
+// a `now`. Ogni messaggio viene rimosso dalla coda e inviato nella stessa transazione, così non viene mai inviato due
+// volte né perso: se l'invio fallisce, o il router viene chiuso nel frattempo, resta in coda per il controllo
+// successivo. Se il mittente non è più membro della conversazione il messaggio viene scartato.
+func (rt *_router) sendDueScheduledMessages(ctx context.Context, now time.Time) {
+	due, err := rt.db.GetDueScheduledMessages(ctx, now)
+	if err != nil {
+		rt.baseLogger.WithError(err).Error("error retrieving scheduled messages")
+		return
+	}
+	for _, scheduled := range due {
+		logger := rt.baseLogger.WithField("scheduled_id", scheduled.ScheduledId)
+		sent, err := rt.db.SendScheduledMessage(ctx, scheduled.ScheduledId, now)
+		if err != nil {
+			logger.WithError(err).Error("error sending scheduled message")
+			continue
+		}
+		if sent == nil {
+			logger.Info("scheduled message not sent: cancelled, postponed or sender no longer a member of the conversation")
+			continue
+		}
+		rt.metrics.messagesSent.Inc("scheduled")
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	// defaultPageSize è il numero di elementi restituiti quando la richiesta non specifica "limit"
+	defaultPageSize = 20
+
+	// maxPageSize è il numero massimo di elementi restituiti in una singola pagina
+	maxPageSize = 100
+)
+
+// parsePagination legge i parametri di query "limit" e "offset".
+// Restituisce false se i valori non sono numeri validi.
+func parsePagination(r *http.Request) (int, int, bool) {
+	limit, offset := defaultPageSize, 0
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPageSize {
+			return 0, 0, false
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// Handler per salvare un messaggio nella raccolta personale dell'utente.
+// Controlla che l'utente sia membro della conversazione del messaggio, poi chiama StarMessage.
+// Si collega a database/starred-messages-db.go.
+func (rt *_router) starMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	messageId, _ := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if userId <= 0 || messageId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	conversationId, err := rt.db.GetMessageConversationId(ctx.Context, messageId)
+	if err != nil {
+		http.Error(w, "Messaggio non trovato", http.StatusNotFound)
+		return
+	}
+	isMember, err := rt.db.IsUserInConversation(ctx.Context, userId, conversationId)
+	if err != nil || !isMember {
+		http.Error(w, "Non autorizzato", http.StatusForbidden)
+		return
+	}
+	if err := rt.db.StarMessage(ctx.Context, userId, messageId); err != nil {
+		ctx.Logger.WithError(err).Error("errore salvataggio messaggio")
+		http.Error(w, "Errore salvataggio messaggio", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler per togliere un messaggio dalla raccolta personale dell'utente.
+// Si collega a database/starred-messages-db.go.
+func (rt *_router) unstarMessage(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	messageId, _ := strconv.ParseInt(ps.ByName("messageId"), 10, 64)
+	if userId <= 0 || messageId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	if err := rt.db.UnstarMessage(ctx.Context, userId, messageId); err != nil {
+		ctx.Logger.WithError(err).Error("errore rimozione messaggio salvato")
+		http.Error(w, "Errore rimozione messaggio salvato", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler per ottenere i messaggi salvati dall'utente, con la conversazione di provenienza.
+// Supporta la paginazione tramite i parametri di query "limit" e "offset".
+// Si collega a database/starred-messages-db.go.
+func (rt *_router) getStarredMessages(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	limit, offset, ok := parsePagination(r)
+	if !ok {
+		http.Error(w, "Parametri di paginazione non validi", http.StatusBadRequest)
+		return
+	}
+	starred, err := rt.db.GetStarredMessages(ctx.Context, userId, limit, offset)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero messaggi salvati")
+		http.Error(w, "Errore recupero messaggi salvati", http.StatusInternalServerError)
+		return
+	}
+	if starred == nil {
+		starred = []database.StarredMessage{}
+	}
+	response := struct {
+		Limit    int                       `json:"limit"`
+		Offset   int                       `json:"offset"`
+		Messages []database.StarredMessage `json:"messages"`
+	}{
+		Limit:    limit,
+		Offset:   offset,
+		Messages: starred,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
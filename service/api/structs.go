@@ -9,11 +9,21 @@ import (
 // Ad esempio, Message viene usato sia negli handler API che nelle funzioni database.
 // Conversation represent a conversation object
 type Conversation struct {
-	ConversationId int64        `json:"conversationId"`
-	Name           string       `json:"name"`
-	Photo          []byte       `json:"photo,omitempty"`
-	LastMessage    *LastMessage `json:"lastMessage,omitempty"`
-	Type           string       `json:"type"`
+	ConversationId       int64        `json:"conversationId"`
+	Name                 string       `json:"name"`
+	Photo                []byte       `json:"photo,omitempty"`
+	LastMessage          *LastMessage `json:"lastMessage,omitempty"`
+	Type                 string       `json:"type"`
+	UnreadMentions       int          `json:"unreadMentions"`
+	UnreadCount          int          `json:"unreadCount"`
+	FirstUnreadMessageId *int64       `json:"firstUnreadMessageId,omitempty"`
+	MarkedUnread         bool         `json:"markedUnread"`
+	MessageTTL           int64        `json:"messageTtl"`
+	Muted                bool         `json:"muted"`
+	MutedUntil           *time.Time   `json:"mutedUntil,omitempty"`
+	Archived             bool         `json:"archived"`
+	Pinned               bool         `json:"pinned"`
+	PinOrder             *int         `json:"pinOrder,omitempty"`
 }
 
 // LastMessage represents the details of the last message in a conversation
@@ -25,15 +35,42 @@ type LastMessage struct {
 
 // Message represents a single message in a conversation.
 type Message struct {
-	MessageId        int64     `json:"id"`
-	Timestamp        time.Time `json:"timestamp"`
-	Text             string    `json:"text,omitempty"`
-	Photo            []byte    `json:"photo,omitempty"`
-	Sender           User      `json:"sender"`
-	Status           string    `json:"status"`
-	Comments         []Comment `json:"comments,omitempty"`
-	Type             string    `json:"type"`
-	ReplyToMessageId *int64    `json:"replyToMessageId,omitempty"`
+	MessageId        int64        `json:"id"`
+	Timestamp        time.Time    `json:"timestamp"`
+	Text             string       `json:"text,omitempty"`
+	Photo            []byte       `json:"photo,omitempty"`
+	Sender           User         `json:"sender"`
+	Status           string       `json:"status"`
+	Comments         []Comment    `json:"comments,omitempty"`
+	Type             string       `json:"type"`
+	ReplyToMessageId *int64       `json:"replyToMessageId,omitempty"`
+	ConversationId   int64        `json:"conversationId,omitempty"`
+	ForwardedFrom    *ForwardInfo `json:"forwardedFrom,omitempty"`
+	Reactions        []Reaction   `json:"reactions,omitempty"`
+	Mentions         []Mention    `json:"mentions,omitempty"`
+	ExpiresAt        *time.Time   `json:"expiresAt,omitempty"`
+}
+
+// Mention is an @username reference to a conversation member inside the text of a message.
+type Mention struct {
+	UserId   int64  `json:"userId"`
+	Username string `json:"username"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// Reaction aggregates the reactions with the same emoji on a message.
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
+}
+
+// ForwardInfo describes where a forwarded message originally came from.
+type ForwardInfo struct {
+	Sender         *User     `json:"sender,omitempty"`
+	ConversationId int64     `json:"conversationId"`
+	Timestamp      time.Time `json:"timestamp"`
 }
 
 // Comment represents a comment on a message.
@@ -45,9 +82,12 @@ type Comment struct {
 
 // User represents the user schema used in messages and comments.
 type User struct {
-	UserId int64  `json:"userId"`
-	Name   string `json:"name"`
-	Photo  []byte `json:"photo,omitempty"`
+	UserId      int64      `json:"userId"`
+	Name        string     `json:"name"`
+	Photo       []byte     `json:"photo,omitempty"`
+	DisplayName string     `json:"displayName,omitempty"`
+	Online      bool       `json:"online,omitempty"`
+	LastSeen    *time.Time `json:"lastSeen,omitempty"`
 }
 
 // Group represents a single group.
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Mortifer97/WASAText/service/api/reqcontext"
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+	"github.com/julienschmidt/httprouter"
+)
+
+// Handler per ottenere le impostazioni di privacy dell'utente.
+// Si collega a database/user-settings-db.go.
+func (rt *_router) getUserSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	settings, err := rt.db.GetUserSettings(ctx.Context, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero impostazioni utente")
+		http.Error(w, "Errore recupero impostazioni", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// Handler per modificare le impostazioni di privacy dell'utente.
+// Si collega a database/user-settings-db.go.
+func (rt *_router) setUserSettings(w http.ResponseWriter, r *http.Request, ps httprouter.Params, ctx reqcontext.RequestContext) {
+	userId, _ := strconv.ParseInt(ps.ByName("userId"), 10, 64)
+	if userId <= 0 {
+		http.Error(w, "Id non valido", http.StatusBadRequest)
+		return
+	}
+	settings, err := rt.db.GetUserSettings(ctx.Context, userId)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("errore recupero impostazioni utente")
+		http.Error(w, "Errore recupero impostazioni", http.StatusInternalServerError)
+		return
+	}
+	// I campi assenti restano invariati
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		http.Error(w, "Richiesta non valida", http.StatusBadRequest)
+		return
+	}
+	for _, visibility := range []string{settings.Photo, settings.LastSeen, settings.ReadReceipts, settings.GroupAdd,
+		settings.DirectChat, settings.Forwards} {
+		if visibility != database.VisibilityEveryone && visibility != database.VisibilityGroups && visibility != database.VisibilityNobody {
+			http.Error(w, "Impostazioni non valide", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := rt.db.UpdateUserSettings(ctx.Context, userId, settings); err != nil {
+		ctx.Logger.WithError(err).Error("errore aggiornamento impostazioni utente")
+		http.Error(w, "Errore aggiornamento impostazioni", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// applyPrivacy prepara l'utente per `viewerId` secondo le impostazioni di privacy dell'utente: toglie la foto se
+// non è visibile e imposta Online e LastSeen se lo sono.
+func (rt *_router) applyPrivacy(ctx context.Context, viewerId int64, user *database.User) error {
+	settings, err := rt.db.GetUserSettings(ctx, user.UserId)
+	if err != nil {
+		return err
+	}
+	photoVisible, err := rt.db.UserAllows(ctx, user.UserId, viewerId, settings.Photo)
+	if err != nil {
+		return err
+	}
+	if !photoVisible {
+		user.Photo = nil
+	}
+	lastSeenVisible, err := rt.db.UserAllows(ctx, user.UserId, viewerId, settings.LastSeen)
+	if err != nil || !lastSeenVisible {
+		return err
+	}
+	if last, online := rt.presence.lastActivityOf(user.UserId, globaltime.Now()); online {
+		user.Online = true
+		user.LastSeen = &last
+		return nil
+	}
+	lastSeen, err := rt.db.GetLastSeen(ctx, user.UserId)
+	if err != nil {
+		return err
+	}
+	user.LastSeen = lastSeen
+	return nil
+}
+
+// allowedBy controlla se le impostazioni di privacy di `targetId` permettono a `userId` di avviare con lui una
+// conversazione di tipo `conversationType` ("direct") o di aggiungerlo a un gruppo ("group").
+func (rt *_router) allowedBy(ctx context.Context, targetId int64, userId int64, conversationType string) (bool, error) {
+	settings, err := rt.db.GetUserSettings(ctx, targetId)
+	if err != nil {
+		return false, err
+	}
+	if conversationType == "direct" {
+		return rt.db.UserAllows(ctx, targetId, userId, settings.DirectChat)
+	}
+	return rt.db.UserAllows(ctx, targetId, userId, settings.GroupAdd)
+}
@@ -0,0 +1,234 @@
+/*
+Package backup takes snapshots of the WASAText database and restores them.
+
+A Manager writes the snapshots in a directory, named after the time they were taken
+(`wasatext-20060102T150405.000Z.db`), using the online backup of database.AppDatabase so that the server does not
+need to stop. Every snapshot is checked with database.VerifyDatabase before being kept, and only the most recent ones
+are kept.
+
+Restore replaces the database file with a snapshot, and refuses to do it while the server is using the database.
+*/
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+const (
+	snapshotPrefix = "wasatext-"
+	snapshotSuffix = ".db"
+	// snapshotTime is the format of the time in the name of the snapshots; it sorts like the times themselves.
+	snapshotTime = "20060102T150405.000Z"
+)
+
+// Snapshot is a backup of the database.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Path      string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+// Manager takes the snapshots of a database in a directory, keeping only the most recent `retention` of them
+// (0 keeps all of them). It is safe for concurrent use.
+type Manager struct {
+	db        database.AppDatabase
+	directory string
+	retention int
+
+	// mu serializes the snapshots, so that two snapshots never run at the same time
+	mu sync.Mutex
+}
+
+// NewManager returns a Manager that writes the snapshots of `db` in `directory`, creating it if needed.
+func NewManager(db database.AppDatabase, directory string, retention int) (*Manager, error) {
+	if retention < 0 {
+		return nil, fmt.Errorf("invalid retention %d", retention)
+	}
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, fmt.Errorf("creating backup directory: %w", err)
+	}
+	return &Manager{db: db, directory: directory, retention: retention}, nil
+}
+
+// Create takes a new snapshot, verifies it and removes the snapshots beyond the retention. A snapshot that fails the
+// verification is deleted and an error is returned.
+func (m *Manager) Create(ctx context.Context) (Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	createdAt := globaltime.Now().UTC()
+	name := snapshotPrefix + createdAt.Format(snapshotTime) + snapshotSuffix
+	final := filepath.Join(m.directory, name)
+	// Written with another name until verified, so that an incomplete snapshot is never listed
+	partial := final + ".partial"
+	if err := m.db.Backup(ctx, partial); err != nil {
+		_ = os.Remove(partial)
+		return Snapshot{}, fmt.Errorf("backing up database: %w", err)
+	}
+	if err := database.VerifyDatabase(partial); err != nil {
+		_ = os.Remove(partial)
+		return Snapshot{}, fmt.Errorf("verifying snapshot: %w", err)
+	}
+	if err := os.Rename(partial, final); err != nil {
+		_ = os.Remove(partial)
+		return Snapshot{}, fmt.Errorf("saving snapshot: %w", err)
+	}
+	info, err := os.Stat(final)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("reading snapshot: %w", err)
+	}
+	if err := m.rotate(); err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Name: name, Path: final, CreatedAt: createdAt, Size: info.Size()}, nil
+}
+
+// rotate removes the oldest snapshots beyond the retention.
+func (m *Manager) rotate() error {
+	if m.retention == 0 {
+		return nil
+	}
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	for i := m.retention; i < len(snapshots); i++ {
+		if err := os.Remove(snapshots[i].Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("removing old snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// List returns the snapshots in the directory, from the most recent.
+func (m *Manager) List() ([]Snapshot, error) {
+	return List(m.directory)
+}
+
+// Get returns the snapshot with the given name.
+func (m *Manager) Get(name string) (Snapshot, error) {
+	snapshots, err := m.List()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+	return Snapshot{}, os.ErrNotExist
+}
+
+// List returns the snapshots in a directory, from the most recent.
+func List(directory string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		return nil, fmt.Errorf("reading backup directory: %w", err)
+	}
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotSuffix) {
+			continue
+		}
+		createdAt, err := time.Parse(snapshotTime, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotSuffix))
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name:      name,
+			Path:      filepath.Join(directory, name),
+			CreatedAt: createdAt,
+			Size:      info.Size(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Restore replaces the database file `dbFile` with the snapshot in the file `snapshot`, after verifying it. The current
+// database is kept next to it, with the suffix `.before-restore-<time>`, and its path is returned (empty if there was
+// no database). The server must be stopped, since it would keep using the replaced file: the database is not restored
+// if database.CheckUnused finds it in use.
+func Restore(snapshot string, dbFile string) (string, error) {
+	if err := database.VerifyDatabase(snapshot); err != nil {
+		return "", fmt.Errorf("verifying snapshot: %w", err)
+	}
+	if _, err := os.Stat(dbFile); err == nil {
+		if err := database.CheckUnused(dbFile); err != nil {
+			return "", fmt.Errorf("%w: stop the server before restoring", err)
+		}
+	}
+
+	// The snapshot is copied next to the database first, so that the swap is a rename in the same file system
+	restoring := dbFile + ".restoring"
+	if err := copyFile(snapshot, restoring); err != nil {
+		_ = os.Remove(restoring)
+		return "", fmt.Errorf("copying snapshot: %w", err)
+	}
+
+	var previous string
+	if _, err := os.Stat(dbFile); err == nil {
+		previous = dbFile + ".before-restore-" + globaltime.Now().UTC().Format(snapshotTime)
+		if err := os.Rename(dbFile, previous); err != nil {
+			_ = os.Remove(restoring)
+			return "", fmt.Errorf("moving current database: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		_ = os.Remove(restoring)
+		return "", fmt.Errorf("reading current database: %w", err)
+	}
+	// The journal of the replaced database must not be applied to the snapshot
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if _, err := os.Stat(dbFile + suffix); err == nil && previous != "" {
+			if err := os.Rename(dbFile+suffix, previous+suffix); err != nil {
+				_ = os.Rename(previous, dbFile)
+				_ = os.Remove(restoring)
+				return "", fmt.Errorf("moving %s file of current database: %w", suffix, err)
+			}
+		}
+	}
+	if err := os.Rename(restoring, dbFile); err != nil {
+		return "", fmt.Errorf("replacing database: %w", err)
+	}
+	return previous, nil
+}
+
+// copyFile copies the file `from` to `to`, flushing it to disk.
+func copyFile(from string, to string) error {
+	source, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		_ = target.Close()
+		return err
+	}
+	if err := target.Sync(); err != nil {
+		_ = target.Close()
+		return err
+	}
+	return target.Close()
+}
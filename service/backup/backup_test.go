@@ -0,0 +1,62 @@
+package backup_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/backup"
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// TestRestoreRefusesDatabaseInUse restores a snapshot while the database is open like webapi opens it, which must
+// fail and leave the database alone, and then once it is closed.
+func TestRestoreRefusesDatabaseInUse(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.db")
+	writer, reader, err := database.OpenSQLite(ctx, filename, database.DefaultSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager, err := backup.NewManager(db, filepath.Join(dir, "backups"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := manager.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The connections are idle, as in a server waiting for requests
+	if _, err := db.ListUsers(ctx, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := backup.Restore(snapshot.Path, filename); err == nil {
+		t.Fatal("restored a database in use")
+	}
+	if _, err := os.Stat(filename + ".restoring"); !os.IsNotExist(err) {
+		t.Errorf("the snapshot was copied next to the database in use: %v", err)
+	}
+	if err := db.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = reader.Close()
+	_ = writer.Close()
+	previous, err := backup.Restore(snapshot.Path, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous == "" {
+		t.Error("the previous database was not kept")
+	}
+	if err := database.VerifyDatabase(filename); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,379 @@
+/*
+Package chatimport reads the chat exports of other messengers and imports them into WASAText through the database
+package. Two formats are supported:
+
+  - WhatsApp: the `.txt` file written by "Export chat", alone or together with the attachments (as exported in a
+    `.zip` archive or extracted in a directory);
+  - Telegram: the `result.json` file of a single chat written by Telegram Desktop in "Machine-readable JSON" format,
+    with the `photos/` and `files/` directories next to it.
+
+Every participant is mapped to a WASAText user: to the username given in Options.Users, or else to a new user with a
+username derived from their name and their original name as display name. Existing accounts are reused only when
+listed in Options.Users, so that a participant never takes over the account of someone with the same name. Importing
+the same chat again is safe: the participants are mapped to the same users as the first time, and only the messages
+not imported yet are added to the conversation created then.
+
+Example:
+
+	fsys, name, closeFn, err := chatimport.Open("WhatsApp Chat with Mario.zip")
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+	chat, err := chatimport.Load(fsys, name, chatimport.Options{})
+	if err != nil {
+		return err
+	}
+	result, err := chatimport.Import(db, fsys, chat, chatimport.Options{})
+*/
+package chatimport
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// Names of the supported sources, also stored with the imported chats.
+const (
+	SourceWhatsApp = "whatsapp"
+	SourceTelegram = "telegram"
+)
+
+// maxAttachmentSize is the largest attachment imported as a photo, the same limit of the photos sent through the API.
+const maxAttachmentSize = 10 << 20
+
+// ErrUnknownFormat is returned when no chat export can be found or recognized.
+var ErrUnknownFormat = errors.New("unrecognized chat export")
+
+// Chat is a chat read from an export, before its participants are mapped to users.
+type Chat struct {
+	Source string
+	// Id identifies the chat in the source, so that it is recognized when imported again.
+	Id    string
+	Title string
+	Group bool
+	// Participants are listed in order of first appearance.
+	Participants []Participant
+	Messages     []Message
+}
+
+// Participant is an author of messages in a Chat. Key is unique within the chat.
+type Participant struct {
+	Key  string
+	Name string
+}
+
+// Message is a message of a Chat. Sender is the Key of a participant; Attachment, if set, is the path of the attached
+// file in the export and ReplyTo the Id of the message it answers.
+type Message struct {
+	Id         string
+	Sender     string
+	Timestamp  time.Time
+	Text       string
+	Attachment string
+	ReplyTo    string
+}
+
+// Options change how a chat is read and imported. The zero value detects everything automatically.
+type Options struct {
+	// Source is SourceWhatsApp or SourceTelegram; empty to detect it from the file name.
+	Source string
+	// Title replaces the title found in the export.
+	Title string
+	// Users maps participant names to usernames; only these users are reused if they exist.
+	Users map[string]string
+	// Location is the time zone of WhatsApp timestamps, which have none (default: the local time zone).
+	Location *time.Location
+	// DateOrder is "dmy" or "mdy" for WhatsApp dates; empty to detect it from the dates themselves.
+	DateOrder string
+}
+
+// Open opens an export on disk: a chat file, a directory containing one, or a `.zip` archive. It returns the file
+// system with the export, the name of the chat file in it (empty to let Load find it) and a function to release it.
+func Open(name string) (fs.FS, string, func() error, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("opening export: %w", err)
+	}
+	if info.IsDir() {
+		return os.DirFS(name), "", func() error { return nil }, nil
+	}
+	if strings.EqualFold(filepath.Ext(name), ".zip") {
+		archive, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("opening archive: %w", err)
+		}
+		return archive, "", archive.Close, nil
+	}
+	return os.DirFS(filepath.Dir(name)), filepath.Base(name), func() error { return nil }, nil
+}
+
+// Load reads the chat file `name` of an export; when name is empty it looks for `result.json` (Telegram) or for the
+// `.txt` file (WhatsApp) at the top of the file system.
+func Load(fsys fs.FS, name string, opts Options) (Chat, error) {
+	if name == "" {
+		var err error
+		if name, err = findChatFile(fsys); err != nil {
+			return Chat{}, err
+		}
+	}
+	f, err := fsys.Open(name)
+	if err != nil {
+		return Chat{}, fmt.Errorf("opening chat file: %w", err)
+	}
+	defer f.Close()
+	return Parse(f, name, opts)
+}
+
+// findChatFile returns the name of the chat file at the top of an export.
+func findChatFile(fsys fs.FS) (string, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return "", fmt.Errorf("reading export: %w", err)
+	}
+	var texts []string
+	for _, entry := range entries {
+		switch {
+		case entry.IsDir():
+		case entry.Name() == "result.json":
+			return entry.Name(), nil
+		case strings.EqualFold(path.Ext(entry.Name()), ".txt"):
+			texts = append(texts, entry.Name())
+		}
+	}
+	if len(texts) != 1 {
+		return "", ErrUnknownFormat
+	}
+	return texts[0], nil
+}
+
+// Parse reads a chat file. The format is opts.Source or, if empty, guessed from the name: `.json` files are Telegram
+// exports, `.txt` files WhatsApp exports.
+func Parse(r io.Reader, name string, opts Options) (Chat, error) {
+	source := opts.Source
+	if source == "" {
+		switch strings.ToLower(path.Ext(name)) {
+		case ".json":
+			source = SourceTelegram
+		case ".txt":
+			source = SourceWhatsApp
+		}
+	}
+	var chat Chat
+	var err error
+	switch source {
+	case SourceWhatsApp:
+		chat, err = parseWhatsApp(r, name, opts)
+	case SourceTelegram:
+		chat, err = parseTelegram(r, opts)
+	default:
+		return Chat{}, ErrUnknownFormat
+	}
+	if err != nil {
+		return Chat{}, err
+	}
+	if opts.Title != "" {
+		chat.Title = opts.Title
+	}
+	if chat.Title == "" {
+		names := make([]string, 0, len(chat.Participants))
+		for _, participant := range chat.Participants {
+			names = append(names, participant.Name)
+		}
+		chat.Title = strings.Join(names, ", ")
+	}
+	return chat, nil
+}
+
+// Import maps the participants of a chat to users and imports its messages. Attachments are read from fsys, which
+// may be nil when only the chat file is available: attachments that cannot be read, or are not pictures, are replaced
+// by a note in the text of the message.
+func Import(ctx context.Context, db database.AppDatabase, fsys fs.FS, chat Chat, opts Options) (database.ImportResult, error) {
+	userIds, err := mapParticipants(ctx, db, chat, opts.Users)
+	if err != nil {
+		return database.ImportResult{}, err
+	}
+
+	imported := database.ImportedChat{
+		Source:         chat.Source,
+		ExternalId:     chat.Id,
+		Name:           chat.Title,
+		Type:           "direct",
+		ParticipantIds: userIds,
+	}
+	if chat.Group || len(chat.Participants) > 2 {
+		imported.Type = "group"
+	}
+	for _, participant := range chat.Participants {
+		imported.MemberIds = append(imported.MemberIds, userIds[participant.Key])
+	}
+	for _, message := range chat.Messages {
+		text := message.Text
+		var photo []byte
+		if message.Attachment != "" {
+			photo = readAttachment(fsys, message.Attachment)
+			if photo == nil {
+				text = strings.TrimSpace("[allegato: " + path.Base(message.Attachment) + "]\n" + text)
+			}
+		}
+		if text == "" && photo == nil {
+			continue
+		}
+		imported.Messages = append(imported.Messages, database.ImportedMessage{
+			ExternalId: message.Id,
+			SenderId:   userIds[message.Sender],
+			Timestamp:  message.Timestamp,
+			Text:       text,
+			Photo:      photo,
+			ReplyTo:    message.ReplyTo,
+		})
+	}
+
+	result, err := db.ImportConversation(ctx, imported)
+	if err != nil {
+		return database.ImportResult{}, fmt.Errorf("importing conversation: %w", err)
+	}
+	return result, nil
+}
+
+// readAttachment returns the content of an attached picture, or nil if it is missing, too big or not a picture.
+func readAttachment(fsys fs.FS, name string) []byte {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if fsys == nil || !fs.ValidPath(name) {
+		return nil
+	}
+	info, err := fs.Stat(fsys, name)
+	if err != nil || info.IsDir() || info.Size() > maxAttachmentSize {
+		return nil
+	}
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil || !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return nil
+	}
+	return data
+}
+
+// mapParticipants returns the id of the user of each participant of chat, by Key. Participants listed in `users` are
+// mapped to that username, creating the user if it does not exist, and the ones mapped by a previous import of the
+// chat to the same user as then. Every other participant gets a new user, with a username derived from their name and
+// a numeric suffix if it is taken: an existing account is never reused unless it is listed in `users`.
+func mapParticipants(ctx context.Context, db database.AppDatabase, chat Chat, users map[string]string) (map[string]int64, error) {
+	previous, err := db.GetImportedParticipants(ctx, chat.Source, chat.Id)
+	if err != nil {
+		return nil, fmt.Errorf("looking up previous imports: %w", err)
+	}
+	// The usernames given explicitly are not available to the other participants
+	taken := make(map[string]bool, len(chat.Participants))
+	for _, participant := range chat.Participants {
+		if username, explicit := users[participant.Name]; explicit {
+			taken[username] = true
+		}
+	}
+
+	ids := make(map[string]int64, len(chat.Participants))
+	for _, participant := range chat.Participants {
+		username, explicit := users[participant.Name]
+		if userId, ok := previous[participant.Key]; ok && !explicit {
+			ids[participant.Key] = userId
+			continue
+		}
+		if explicit {
+			if len(username) < 3 || len(username) > 16 {
+				return nil, fmt.Errorf("invalid username %q for %q", username, participant.Name)
+			}
+			user, err := db.GetUserByName(ctx, username)
+			if err != nil {
+				return nil, fmt.Errorf("looking up user %q: %w", username, err)
+			}
+			if user != nil {
+				ids[participant.Key] = user.UserId
+				continue
+			}
+		} else if username, err = freeUsername(ctx, db, participant.Name, taken); err != nil {
+			return nil, err
+		}
+		taken[username] = true
+
+		created, err := db.CreateUser(ctx, username)
+		if err != nil {
+			return nil, fmt.Errorf("creating user %q: %w", username, err)
+		}
+		if displayName := truncate(participant.Name, 32); displayName != username {
+			if _, err := db.UpdateUserProfile(ctx, created.UserId, database.ProfileUpdate{DisplayName: &displayName}); err != nil {
+				return nil, fmt.Errorf("setting display name of %q: %w", username, err)
+			}
+		}
+		ids[participant.Key] = created.UserId
+	}
+	return ids, nil
+}
+
+// freeUsername returns a username derived from name that no user has and is not in taken, adding the suffix _2, _3,
+// and so on if needed.
+func freeUsername(ctx context.Context, db database.AppDatabase, name string, taken map[string]bool) (string, error) {
+	username := usernameFor(name)
+	for i := 2; ; i++ {
+		if !taken[username] {
+			user, err := db.GetUserByName(ctx, username)
+			if err != nil {
+				return "", fmt.Errorf("looking up user %q: %w", username, err)
+			}
+			if user == nil {
+				return username, nil
+			}
+		}
+		suffix := fmt.Sprintf("_%d", i)
+		username = truncate(usernameFor(name), 16-len(suffix)) + suffix
+	}
+}
+
+// usernameFor derives a valid username (3 to 16 characters) from the name of a participant, keeping only lowercase
+// letters and digits and replacing everything else with underscores.
+func usernameFor(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+	username := strings.TrimRight(truncate(b.String(), 16), "_")
+	if len(username) < 3 {
+		sum := sha256.Sum256([]byte(name))
+		username = truncate(username+"_"+hex.EncodeToString(sum[:4]), 16)
+	}
+	return username
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
+
+// hashId returns a short stable identifier for the given parts.
+func hashId(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:12])
+}
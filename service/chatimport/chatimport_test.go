@@ -0,0 +1,95 @@
+package chatimport_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/chatimport"
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+const whatsAppChat = `31/12/21, 21:41 - Mario Rossi: Buon anno!
+31/12/21, 21:42 - Anna: Anche a te!
+`
+
+func newDatabase(t *testing.T) database.AppDatabase {
+	t.Helper()
+	writer, reader, err := database.OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "test.db"), database.DefaultSQLiteConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = reader.Close()
+		_ = writer.Close()
+	})
+	db, err := database.NewSQLite(writer, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// importChat imports whatsAppChat with the given mapping of the participants, and returns the sender of each message
+// as seen by the member viewer.
+func importChat(t *testing.T, db database.AppDatabase, users map[string]string, viewer int64) []int64 {
+	t.Helper()
+	ctx := context.Background()
+	opts := chatimport.Options{Users: users}
+	chat, err := chatimport.Parse(strings.NewReader(whatsAppChat), "WhatsApp Chat with Mario Rossi.txt", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := chatimport.Import(ctx, db, nil, chat, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages, err := db.GetMessagesByConversation(ctx, viewer, result.ConversationId, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	senders := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		senders = append(senders, message.Sender.UserId)
+	}
+	return senders
+}
+
+// TestImportDoesNotReuseAccounts checks that the participants are mapped to existing accounts only when listed in
+// Options.Users, and to the same users when the chat is imported again.
+func TestImportDoesNotReuseAccounts(t *testing.T) {
+	ctx := context.Background()
+	db := newDatabase(t)
+	mario, err := db.CreateUser(ctx, "mario_rossi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	anna, err := db.CreateUser(ctx, "anna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	senders := importChat(t, db, map[string]string{"Anna": "anna"}, anna.UserId)
+	if len(senders) != 2 {
+		t.Fatalf("imported messages: got %d, want 2", len(senders))
+	}
+	if senders[0] == mario.UserId {
+		t.Error("Mario Rossi was mapped to the existing mario_rossi without being listed")
+	}
+	if senders[1] != anna.UserId {
+		t.Errorf("Anna: got user %d, want the listed anna %d", senders[1], anna.UserId)
+	}
+	created, err := db.GetUserById(ctx, senders[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Name != "mario_rossi_2" {
+		t.Errorf("username of the new user: got %q, want mario_rossi_2", created.Name)
+	}
+
+	again := importChat(t, db, nil, anna.UserId)
+	if again[0] != senders[0] || again[1] != senders[1] {
+		t.Errorf("importing again: got senders %v, want %v", again, senders)
+	}
+}
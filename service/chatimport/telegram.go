@@ -0,0 +1,159 @@
+package chatimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramChat is the `result.json` of a single chat exported by Telegram Desktop. Only the fields used by the import
+// are decoded.
+type telegramChat struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Id       int64             `json:"id"`
+	Messages []telegramMessage `json:"messages"`
+	// Set only in the export of the whole account, which is not supported
+	Chats json.RawMessage `json:"chats"`
+}
+
+type telegramMessage struct {
+	Id            int64           `json:"id"`
+	Type          string          `json:"type"`
+	Date          string          `json:"date"`
+	DateUnixtime  string          `json:"date_unixtime"`
+	From          *string         `json:"from"`
+	FromId        string          `json:"from_id"`
+	Text          json.RawMessage `json:"text"`
+	Photo         string          `json:"photo"`
+	File          string          `json:"file"`
+	ReplyTo       int64           `json:"reply_to_message_id"`
+	ForwardedFrom *string         `json:"forwarded_from"`
+}
+
+// telegramTextPart is a formatted piece of the text of a message, such as a link or a bold word.
+type telegramTextPart struct {
+	Text string `json:"text"`
+}
+
+func parseTelegram(r io.Reader, opts Options) (Chat, error) {
+	var export telegramChat
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return Chat{}, fmt.Errorf("decoding Telegram export: %w", err)
+	}
+	if len(export.Chats) > 0 {
+		return Chat{}, fmt.Errorf("%w: export a single chat instead of the whole account", ErrUnknownFormat)
+	}
+	if export.Id == 0 {
+		return Chat{}, ErrUnknownFormat
+	}
+	location := opts.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	chat := Chat{
+		Source: SourceTelegram,
+		Id:     strconv.FormatInt(export.Id, 10),
+		Title:  export.Name,
+		Group:  export.Type != "personal_chat" && export.Type != "bot_chat" && export.Type != "saved_messages",
+	}
+	seen := make(map[string]bool)
+	for _, m := range export.Messages {
+		// Service messages (group created, user joined, ...) have no author
+		if m.Type != "message" {
+			continue
+		}
+		timestamp, err := telegramTime(m, location)
+		if err != nil {
+			return Chat{}, err
+		}
+		name := "Deleted Account"
+		if m.From != nil && *m.From != "" {
+			name = *m.From
+		}
+		key := m.FromId
+		if key == "" {
+			key = name
+		}
+		if !seen[key] {
+			seen[key] = true
+			chat.Participants = append(chat.Participants, Participant{Key: key, Name: name})
+		}
+
+		text, err := telegramText(m.Text)
+		if err != nil {
+			return Chat{}, fmt.Errorf("decoding text of message %d: %w", m.Id, err)
+		}
+		if m.ForwardedFrom != nil {
+			text = strings.TrimSpace("[inoltrato da " + *m.ForwardedFrom + "]\n" + text)
+		}
+		message := Message{
+			Id:        strconv.FormatInt(m.Id, 10),
+			Sender:    key,
+			Timestamp: timestamp,
+			Text:      text,
+		}
+		// Files not downloaded during the export are listed as "(File not included. ...)"
+		for _, attachment := range []string{m.Photo, m.File} {
+			if attachment != "" && !strings.HasPrefix(attachment, "(") {
+				message.Attachment = attachment
+				break
+			}
+		}
+		if m.ReplyTo != 0 {
+			message.ReplyTo = strconv.FormatInt(m.ReplyTo, 10)
+		}
+		chat.Messages = append(chat.Messages, message)
+	}
+	return chat, nil
+}
+
+// telegramTime returns the time of a message, preferring the Unix time of recent exports to the local date.
+func telegramTime(m telegramMessage, location *time.Location) (time.Time, error) {
+	if m.DateUnixtime != "" {
+		seconds, err := strconv.ParseInt(m.DateUnixtime, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date of message %d: %w", m.Id, err)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", m.Date, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date of message %d: %w", m.Id, err)
+	}
+	return t, nil
+}
+
+// telegramText returns the plain text of a message, which is either a string or a list of strings and formatted
+// parts.
+func telegramText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, part := range parts {
+		var s string
+		if err := json.Unmarshal(part, &s); err == nil {
+			b.WriteString(s)
+			continue
+		}
+		var formatted telegramTextPart
+		if err := json.Unmarshal(part, &formatted); err != nil {
+			return "", err
+		}
+		b.WriteString(formatted.Text)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,182 @@
+package chatimport
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WhatsApp writes one message per line, followed by the continuation lines of multi-line messages. The header of a
+// message differs between Android and iOS, and the date and time formats follow the locale of the phone:
+//
+//	31/12/21, 21:41 - Mario Rossi: Buon anno!
+//	12/31/21, 9:41 PM - Mario Rossi: Happy new year!
+//	[31/12/21, 21:41:05] Mario Rossi: Buon anno!
+//
+// Lines without a sender ("Mario created group ...") are system messages and are skipped.
+var (
+	whatsAppHeader = regexp.MustCompile(`^\[?(\d{1,4})[./-](\d{1,2})[./-](\d{1,4}),? (\d{1,2})[:.](\d{2})(?:[:.](\d{2}))? ?([AaPp])?\.? ?(?:[Mm]\.?)?(?:\] | - )(.*)$`)
+	// iOS attachment: "<attached: 00000012-PHOTO-2021-12-31-21-41-05.jpg>"
+	whatsAppAttachedTag = regexp.MustCompile(`<(?:attached|allegato): ([^>]+)>`)
+	// Android attachment: "IMG-20211231-WA0001.jpg (file attached)"
+	whatsAppAttachedFile = regexp.MustCompile(`^(\S.*\.\w{2,5}) \((?:file attached|file allegato)\)`)
+	// Direction marks and special spaces added by WhatsApp around names and times
+	whatsAppInvisible = strings.NewReplacer("\u200e", "", "\u200f", "", "\ufeff", "", "\u202f", " ", "\u00a0", " ")
+	// Prefixes of the names given by WhatsApp to the exported files, followed by the name of the chat
+	whatsAppTitlePrefixes = []string{"WhatsApp Chat with ", "WhatsApp Chat - ", "Chat WhatsApp con ", "Chat di WhatsApp con "}
+)
+
+// whatsAppLine is a message header read from a WhatsApp export, before the order of the date fields is known.
+type whatsAppLine struct {
+	date   [3]int
+	clock  [3]int
+	pm     string
+	sender string
+	text   string
+}
+
+func parseWhatsApp(r io.Reader, name string, opts Options) (Chat, error) {
+	var lines []whatsAppLine
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	inMessage := false
+	for scanner.Scan() {
+		text := whatsAppInvisible.Replace(scanner.Text())
+		match := whatsAppHeader.FindStringSubmatch(text)
+		if match == nil {
+			// Continuation of the previous message
+			if inMessage {
+				lines[len(lines)-1].text += "\n" + text
+			}
+			continue
+		}
+		separator := strings.Index(match[8], ": ")
+		inMessage = separator > 0
+		if !inMessage {
+			continue
+		}
+		line := whatsAppLine{sender: strings.TrimSpace(match[8][:separator]), text: match[8][separator+2:], pm: strings.ToLower(match[7])}
+		for i := 0; i < 3; i++ {
+			line.date[i], _ = strconv.Atoi(match[1+i])
+			line.clock[i], _ = strconv.Atoi(match[4+i])
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return Chat{}, fmt.Errorf("reading WhatsApp export: %w", err)
+	}
+	if len(lines) == 0 {
+		return Chat{}, ErrUnknownFormat
+	}
+
+	dayFirst, err := whatsAppDayFirst(lines, opts.DateOrder)
+	if err != nil {
+		return Chat{}, err
+	}
+	location := opts.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	chat := Chat{Source: SourceWhatsApp, Title: whatsAppTitle(name)}
+	seen := make(map[string]bool)
+	occurrences := make(map[string]int)
+	for _, line := range lines {
+		timestamp, err := whatsAppTime(line, dayFirst, location)
+		if err != nil {
+			return Chat{}, err
+		}
+		if !seen[line.sender] {
+			seen[line.sender] = true
+			chat.Participants = append(chat.Participants, Participant{Key: line.sender, Name: line.sender})
+		}
+		message := Message{Sender: line.sender, Timestamp: timestamp, Text: strings.TrimSpace(line.text)}
+		if match := whatsAppAttachedTag.FindStringSubmatch(message.Text); match != nil {
+			message.Attachment = path.Base(match[1])
+			message.Text = strings.TrimSpace(strings.Replace(message.Text, match[0], "", 1))
+		} else if match := whatsAppAttachedFile.FindStringSubmatch(message.Text); match != nil {
+			message.Attachment = path.Base(match[1])
+			message.Text = strings.TrimSpace(message.Text[len(match[0]):])
+		}
+
+		// The export has no message ids: identical messages sent in the same minute are told apart by their order
+		key := hashId(timestamp.UTC().Format(time.RFC3339), line.sender, line.text)
+		occurrences[key]++
+		message.Id = fmt.Sprintf("%s-%d", key, occurrences[key])
+		chat.Messages = append(chat.Messages, message)
+	}
+	// The first message does not change when the same chat is exported again later
+	first := chat.Messages[0]
+	chat.Id = hashId(first.Id, first.Sender)
+	return chat, nil
+}
+
+// whatsAppDayFirst tells whether the dates of an export are day/month/year or month/day/year, from the given order
+// ("dmy" or "mdy") or, when empty, from the dates themselves; ambiguous exports are read as day/month/year.
+func whatsAppDayFirst(lines []whatsAppLine, order string) (bool, error) {
+	switch order {
+	case "dmy":
+		return true, nil
+	case "mdy":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("invalid date order %q", order)
+	}
+	for _, line := range lines {
+		if line.date[0] > 31 {
+			continue // year/month/day
+		}
+		if line.date[0] > 12 {
+			return true, nil
+		}
+		if line.date[1] > 12 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// whatsAppTime returns the time of a message header.
+func whatsAppTime(line whatsAppLine, dayFirst bool, location *time.Location) (time.Time, error) {
+	var year, month, day int
+	switch {
+	case line.date[0] > 31:
+		year, month, day = line.date[0], line.date[1], line.date[2]
+	case dayFirst:
+		day, month, year = line.date[0], line.date[1], line.date[2]
+	default:
+		month, day, year = line.date[0], line.date[1], line.date[2]
+	}
+	if year < 100 {
+		year += 2000
+	}
+	hour := line.clock[0]
+	switch {
+	case line.pm == "p" && hour < 12:
+		hour += 12
+	case line.pm == "a" && hour == 12:
+		hour = 0
+	}
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || line.clock[1] > 59 || line.clock[2] > 59 {
+		return time.Time{}, fmt.Errorf("invalid date %d/%d/%d %d:%02d in WhatsApp export", line.date[0], line.date[1],
+			line.date[2], line.clock[0], line.clock[1])
+	}
+	return time.Date(year, time.Month(month), day, hour, line.clock[1], line.clock[2], 0, location), nil
+}
+
+// whatsAppTitle returns the name of the chat from the name of the exported file, if WhatsApp named it.
+func whatsAppTitle(name string) string {
+	base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	for _, prefix := range whatsAppTitlePrefixes {
+		if strings.HasPrefix(base, prefix) {
+			return strings.TrimSpace(base[len(prefix):])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// Funzioni per la cancellazione degli account.
+// I messaggi e i commenti di un account cancellato restano nelle conversazioni, attribuiti all'utente segnaposto
+// DeletedUserId, così GetMessagesByConversation continua a trovare un mittente per ogni messaggio.
+
+// DeletedUserId is the id of the placeholder user that replaces deleted accounts.
+const DeletedUserId int64 = 0
+
+const (
+	deletedUserName        = "deleted-user-placeholder"
+	deletedUserDisplayName = "Deleted user"
+)
+
+// DeleteUser deletes the account of a user in a single transaction. The user leaves every group and is replaced by
+// the placeholder in direct conversations; authored messages, comments, pins and forward attributions are moved to
+// the placeholder, and the personal data of the user (photo, profile, settings, drafts, scheduled and starred
+// messages) is removed together with the account, freeing the username.
+func (db *appdbimpl) DeleteUser(ctx context.Context, userId int64) error {
+	if userId == DeletedUserId {
+		return fmt.Errorf("cannot delete the deleted user placeholder")
+	}
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		// Contents authored by the user are moved to the placeholder
+		for _, statement := range []struct{ query, what string }{
+			// The other member of a direct conversation keeps it, with the placeholder in place of the user. If the other
+			// member deleted their account first, the placeholder is already there and the row is deleted below
+			{`UPDATE conversation_members SET user_id = ?1
+				WHERE user_id = ?2 AND EXISTS (
+					SELECT 1 FROM conversations c
+					WHERE c.conversation_id = conversation_members.conversation_id AND c.type = 'direct'
+				)
+				AND NOT EXISTS (
+					SELECT 1 FROM conversation_members p
+					WHERE p.conversation_id = conversation_members.conversation_id AND p.user_id = ?1
+				)`, "replacing user in direct conversations"},
+			{`UPDATE messages SET sender_id = ? WHERE sender_id = ?`, "anonymizing messages"},
+			{`UPDATE messages SET forwarded_from_sender_id = ? WHERE forwarded_from_sender_id = ?`,
+				"anonymizing forward attributions"},
+			// A reaction equal to one already attributed to the placeholder is merged into it (and deleted below)
+			{`UPDATE comments SET sender_id = ?1 WHERE sender_id = ?2 AND NOT EXISTS (
+					SELECT 1 FROM comments d
+					WHERE d.message_id = comments.message_id AND d.sender_id = ?1 AND d.content = comments.content
+				)`, "anonymizing comments"},
+			{`UPDATE pinned_messages SET pinned_by = ? WHERE pinned_by = ?`, "anonymizing pinned messages"},
+		} {
+			if _, err := tx.q.ExecContext(ctx, statement.query, DeletedUserId, userId); err != nil {
+				return fmt.Errorf("error %s: %w", statement.what, err)
+			}
+		}
+
+		// Everything else belonging to the user is deleted
+		for _, statement := range []struct{ query, what string }{
+			{`DELETE FROM conversation_members WHERE user_id = ?`, "removing user from groups"},
+			{`DELETE FROM comments WHERE sender_id = ?`, "removing duplicate comments"},
+			{`DELETE FROM message_mentions WHERE user_id = ?`, "removing mentions"},
+			{`DELETE FROM starred_messages WHERE user_id = ?`, "removing starred messages"},
+			{`DELETE FROM drafts WHERE user_id = ?`, "removing drafts"},
+			{`DELETE FROM scheduled_messages WHERE sender_id = ?`, "removing scheduled messages"},
+			{`DELETE FROM user_settings WHERE user_id = ?`, "removing user settings"},
+			{`DELETE FROM export_jobs WHERE user_id = ?`, "removing export jobs"},
+			{`DELETE FROM imported_participants WHERE user_id = ?`, "removing imported participants"},
+			{`DELETE FROM users WHERE id = ?`, "deleting user"},
+		} {
+			if _, err := tx.q.ExecContext(ctx, statement.query, userId); err != nil {
+				return fmt.Errorf("error %s: %w", statement.what, err)
+			}
+		}
+		return nil
+	})
+}
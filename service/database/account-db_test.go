@@ -0,0 +1,49 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+func TestDeleteUserBothMembersOfDirectConversation(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLite(t)
+	alice, err := db.CreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bobby, err := db.CreateUser(ctx, "bobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversation, err := db.CreateConversation(ctx, alice.UserId, bobby.UserId, "direct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddMessage(ctx, conversation.ConversationId, bobby.UserId, "hi", "received", "text", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteUser(ctx, alice.UserId); err != nil {
+		t.Fatalf("deleting the first member: %v", err)
+	}
+	if err := db.DeleteUser(ctx, bobby.UserId); err != nil {
+		t.Fatalf("deleting the second member: %v", err)
+	}
+	messages, err := db.GetMessagesByConversation(ctx, database.DeletedUserId, conversation.ConversationId, "asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 || messages[0].Sender.UserId != database.DeletedUserId {
+		t.Fatalf("messages of the conversation: got %+v", messages)
+	}
+	problems, err := db.CheckConversations(ctx, conversation.ConversationId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("conversation has problems: %v", problems)
+	}
+}
@@ -0,0 +1,359 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// faultChecks are writes that must be atomic: each one fails the write between its steps through faults, and checks
+// that nothing of it is left in the database. They run in this order on the same database.
+var faultChecks = []struct {
+	name string
+	run  func(ctx context.Context, db database.AppDatabase, faults *faultInjector) error
+}{
+	{"add message", checkAtomicAddMessage},
+	{"reply", checkAtomicReply},
+	{"forward", checkAtomicForward},
+	{"delete message", checkAtomicDeleteMessage},
+	{"create conversation", checkAtomicCreateConversation},
+	{"pin", checkAtomicPin},
+	{"send scheduled message", checkAtomicSendScheduledMessage},
+	{"delete user", checkAtomicDeleteUser},
+	{"import", checkAtomicImport},
+}
+
+// TestAtomicWrites makes the writes made of several statements fail between their steps, and checks that each of
+// them happens in a single transaction.
+func TestAtomicWrites(t *testing.T) {
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			faults := &faultInjector{}
+			db := backend.open(t, faults.Connector)
+			for _, check := range faultChecks {
+				check := check
+				t.Run(check.name, func(t *testing.T) {
+					defer faults.Reset()
+					if err := check.run(context.Background(), db, faults); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// fault is a statement to fail: the one containing pattern after `skip` others containing it.
+type fault struct {
+	pattern string
+	skip    int
+}
+
+// interrupt runs write once for each fault, and checks that the write fails with the fault and that state, which
+// describes what the write would change, is the same before and after it.
+func interrupt(faults *faultInjector, faultList []fault, state func() (string, error), write func() error) error {
+	before, err := state()
+	if err != nil {
+		return err
+	}
+	for _, f := range faultList {
+		faults.Fail(f.pattern, f.skip)
+		err := write()
+		if !faults.Reset() {
+			return fmt.Errorf("%q: no statement failed", f.pattern)
+		}
+		if !errors.Is(err, errInjected) {
+			return fmt.Errorf("%q: got error %v, want the injected fault", f.pattern, err)
+		}
+		after, err := state()
+		if err != nil {
+			return err
+		}
+		if after != before {
+			return fmt.Errorf("%q: the failed write changed %s into %s", f.pattern, before, after)
+		}
+	}
+	return nil
+}
+
+// conversationState describes the messages of some conversations, as seen by userId, and their last message. A
+// dangling last message or any other problem found by CheckConversations is returned as an error.
+func conversationState(ctx context.Context, db database.AppDatabase, userId int64, conversationIds ...int64) (string, error) {
+	var b strings.Builder
+	for _, conversationId := range conversationIds {
+		problems, err := db.CheckConversations(ctx, conversationId)
+		if err != nil {
+			return "", err
+		}
+		if len(problems) != 0 {
+			return "", fmt.Errorf("conversation %d has problems: %v", conversationId, problems)
+		}
+		messages, err := db.GetMessagesByConversation(ctx, userId, conversationId, "asc")
+		if err != nil {
+			return "", err
+		}
+		conversations, err := db.GetConversationsByUser(ctx, userId, "desc", true)
+		if err != nil {
+			return "", err
+		}
+		listed := findConversation(conversations, conversationId)
+		_, _ = fmt.Fprintf(&b, "[conversation %d: messages", conversationId)
+		for _, message := range messages {
+			_, _ = fmt.Fprintf(&b, " %d (%d comments, %d mentions)", message.MessageId, len(message.Comments), len(message.Mentions))
+		}
+		if listed != nil && listed.LastMessage != nil {
+			_, _ = fmt.Fprintf(&b, ", last %d", listed.LastMessage.MessageID)
+		}
+		b.WriteString("]")
+	}
+	return b.String(), nil
+}
+
+// statisticsState describes the counters of the database that a failed write must not change.
+func statisticsState(ctx context.Context, db database.AppDatabase) (string, error) {
+	stats, err := db.GetStatistics(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("[%d users, %d direct conversations, %d groups, %d messages, %d imported chats]",
+		stats.Users, stats.DirectConversations, stats.Groups, stats.Messages, stats.ImportedChats), nil
+}
+
+func checkAtomicAddMessage(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uowAlice", "uowBob")
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "first", "sent", "text", nil); err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"INSERT INTO messages", 0},
+		{"UPDATE conversations", 0},
+		{"UPDATE conversation_members", 0},
+		{"INSERT INTO message_mentions", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return conversationState(ctx, db, members[0].UserId, conversation.ConversationId)
+	}, func() error {
+		_, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "hi @uowBob", "sent", "text", nil)
+		return err
+	})
+}
+
+func checkAtomicReply(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uorAlice", "uorBob")
+	if err != nil {
+		return err
+	}
+	first, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "first", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"UPDATE conversations", 0},
+		{"INSERT INTO message_mentions", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return conversationState(ctx, db, members[1].UserId, conversation.ConversationId)
+	}, func() error {
+		_, err := db.ReplyMessage(ctx, conversation.ConversationId, members[1].UserId, first.MessageId, "@uorAlice yes",
+			"sent", "text", nil)
+		return err
+	})
+}
+
+func checkAtomicForward(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uofAlice", "uofBob", "uofCarol")
+	if err != nil {
+		return err
+	}
+	other, err := db.CreateConversation(ctx, members[0].UserId, members[2].UserId, "direct")
+	if err != nil {
+		return err
+	}
+	message, err := db.AddMessage(ctx, conversation.ConversationId, members[1].UserId, "forward me", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	// The copy for the second conversation fails after the first one was written
+	return interrupt(faults, []fault{
+		{"INSERT INTO messages", 1},
+		{"UPDATE conversations", 1},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return conversationState(ctx, db, members[0].UserId, conversation.ConversationId, other.ConversationId)
+	}, func() error {
+		_, err := db.ForwardMessage(ctx, members[0].UserId, message,
+			[]int64{conversation.ConversationId, other.ConversationId})
+		return err
+	})
+}
+
+func checkAtomicDeleteMessage(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uodAlice", "uodBob")
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "first", "sent", "text", nil); err != nil {
+		return err
+	}
+	last, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "last @uodBob", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddCommentToMessage(ctx, last.MessageId, members[1].UserId, "👍"); err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"DELETE FROM comments", 0},
+		{"DELETE FROM message_mentions", 0},
+		{"DELETE FROM messages", 0},
+		{"UPDATE conversations", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return conversationState(ctx, db, members[1].UserId, conversation.ConversationId)
+	}, func() error {
+		return db.DeleteMessageById(ctx, last.MessageId)
+	})
+}
+
+func checkAtomicCreateConversation(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, err := users(ctx, db, "uocAlice", "uocBob")
+	if err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"INSERT INTO conversation_members", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return statisticsState(ctx, db)
+	}, func() error {
+		_, err := db.CreateConversation(ctx, members[0].UserId, members[1].UserId, "direct")
+		return err
+	})
+}
+
+func checkAtomicPin(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uopAlice", "uopBob")
+	if err != nil {
+		return err
+	}
+	message, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "pin me", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"INSERT INTO messages", 0},
+		{"UPDATE conversations", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		state, err := conversationState(ctx, db, members[0].UserId, conversation.ConversationId)
+		if err != nil {
+			return "", err
+		}
+		pinned, err := db.GetPinnedMessages(ctx, conversation.ConversationId)
+		return fmt.Sprintf("%s, %d pinned", state, len(pinned)), err
+	}, func() error {
+		_, err := db.PinMessage(ctx, conversation.ConversationId, message.MessageId, members[1].UserId, 0)
+		return err
+	})
+}
+
+func checkAtomicSendScheduledMessage(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uosAlice", "uosBob")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	scheduled, err := db.CreateScheduledMessage(ctx, database.ScheduledMessage{ConversationId: conversation.ConversationId,
+		SenderId: members[0].UserId, Text: "later", Type: "text", SendAt: now.Add(-time.Minute)})
+	if err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"DELETE FROM scheduled_messages", 0},
+		{"INSERT INTO messages", 0},
+		{"UPDATE conversations", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		state, err := conversationState(ctx, db, members[0].UserId, conversation.ConversationId)
+		if err != nil {
+			return "", err
+		}
+		pending, err := db.GetScheduledMessages(ctx, members[0].UserId, conversation.ConversationId)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s, %d scheduled", state, len(pending)), nil
+	}, func() error {
+		_, err := db.SendScheduledMessage(ctx, scheduled.ScheduledId, now)
+		return err
+	})
+}
+
+func checkAtomicDeleteUser(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, conversation, err := direct(ctx, db, "uouAlice", "uouBob")
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "hello", "sent", "text", nil); err != nil {
+		return err
+	}
+	return interrupt(faults, []fault{
+		{"UPDATE messages", 0},
+		{"DELETE FROM users", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		state, err := conversationState(ctx, db, members[1].UserId, conversation.ConversationId)
+		if err != nil {
+			return "", err
+		}
+		messages, err := db.GetMessagesByConversation(ctx, members[1].UserId, conversation.ConversationId, "asc")
+		if err != nil {
+			return "", err
+		}
+		user, err := db.GetUserByName(ctx, "uouAlice")
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s, sender %d, user found %t", state, messages[0].Sender.UserId, user != nil), nil
+	}, func() error {
+		return db.DeleteUser(ctx, members[0].UserId)
+	})
+}
+
+func checkAtomicImport(ctx context.Context, db database.AppDatabase, faults *faultInjector) error {
+	members, err := users(ctx, db, "uoiAlice", "uoiBob")
+	if err != nil {
+		return err
+	}
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	chat := database.ImportedChat{
+		Source:     "conformance",
+		ExternalId: "chat-faults",
+		Name:       "Interrupted",
+		Type:       "direct",
+		MemberIds:  []int64{members[0].UserId, members[1].UserId},
+		Messages: []database.ImportedMessage{
+			{ExternalId: "1", SenderId: members[0].UserId, Timestamp: start, Text: "first"},
+			{ExternalId: "2", SenderId: members[1].UserId, Timestamp: start.Add(time.Minute), Text: "second"},
+		},
+	}
+	return interrupt(faults, []fault{
+		{"INSERT INTO imported_messages", 1},
+		{"UPDATE conversations", 0},
+		{"COMMIT", 0},
+	}, func() (string, error) {
+		return statisticsState(ctx, db)
+	}, func() error {
+		_, err := db.ImportConversation(ctx, chat)
+		return err
+	})
+}
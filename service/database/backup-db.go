@@ -0,0 +1,146 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup copies the whole database into the file `destination` with the SQLite online backup API, while the
+// database keeps being used. The pages are copied in a single step, within one read transaction of a connection of its
+// own, so the copy is a consistent snapshot and the pool that writes is never held: in WAL mode the writers keep going
+// during the copy, in the other journal modes they wait for it to end. PostgreSQL databases are backed up with pg_dump
+// instead.
+func (db *appdbimpl) Backup(ctx context.Context, destination string) error {
+	if _, ok := db.c.dialect.(sqliteDialect); !ok {
+		return fmt.Errorf("online backup is available only for SQLite databases, use the tools of %s", db.c.dialect.name())
+	}
+	source := db.c.read
+	if source == nil {
+		// Without the read pool, the pages are read through a read only connection opened for the copy
+		filename, err := db.filename(ctx)
+		if err != nil {
+			return err
+		}
+		source, err = sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+		if err != nil {
+			return fmt.Errorf("error opening database: %w", err)
+		}
+		defer source.Close()
+	}
+	target, err := sql.Open("sqlite3", destination)
+	if err != nil {
+		return fmt.Errorf("error opening backup file: %w", err)
+	}
+	defer target.Close()
+	targetConn, err := target.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to backup file: %w", err)
+	}
+	defer targetConn.Close()
+	sourceConn, err := source.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	defer sourceConn.Close()
+
+	return targetConn.Raw(func(targetDriver interface{}) error {
+		return sourceConn.Raw(func(sourceDriver interface{}) error {
+			targetSQLite, ok := targetDriver.(*sqlite3.SQLiteConn)
+			sourceSQLite, ok2 := sourceDriver.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return errors.New("online backup is available only for SQLite databases")
+			}
+			backup, err := targetSQLite.Backup("main", sourceSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("error starting backup: %w", err)
+			}
+			done, err := backup.Step(-1)
+			if err != nil {
+				_ = backup.Finish()
+				return fmt.Errorf("error copying database: %w", err)
+			}
+			if !done {
+				_ = backup.Finish()
+				return errors.New("backup not completed")
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("error finishing backup: %w", err)
+			}
+			// The copy of a database in WAL mode is in WAL mode too: it is switched back to a single file
+			if _, err := targetSQLite.Exec(`PRAGMA journal_mode = DELETE`, nil); err != nil {
+				return fmt.Errorf("error setting backup journal mode: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// filename returns the file of the main database, as reported by SQLite.
+func (db *appdbimpl) filename(ctx context.Context) (string, error) {
+	var seq int
+	var name, filename string
+	if err := db.c.QueryRowContext(ctx, `PRAGMA database_list`).Scan(&seq, &name, &filename); err != nil {
+		return "", fmt.Errorf("error reading database file name: %w", err)
+	}
+	if filename == "" {
+		return "", errors.New("online backup is not available for in-memory databases")
+	}
+	return filename, nil
+}
+
+// VerifyDatabase checks the integrity of the SQLite database in the file `path`, such as a backup, opening it read
+// only. It returns an error describing the problems found by `PRAGMA integrity_check`, if any.
+func VerifyDatabase(path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer conn.Close()
+
+	rows, err := conn.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("error checking integrity: %w", err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return fmt.Errorf("error scanning integrity check: %w", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error checking integrity: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CheckUnused checks that no other connection, such as the ones of a running webapi, is using the SQLite database in
+// the file `path`, by locking it exclusively for a moment. In WAL mode every open connection keeps the lock from being
+// taken; in the other journal modes only the ones reading or writing do, so an idle server goes unnoticed.
+func CheckUnused(path string) error {
+	conn, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=0&_locking_mode=EXCLUSIVE&_txlock=exclusive")
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer conn.Close()
+	tx, err := conn.Begin()
+	if err != nil {
+		return fmt.Errorf("database in use: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("database in use: %w", err)
+	}
+	return nil
+}
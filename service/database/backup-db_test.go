@@ -0,0 +1,64 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// TestBackup takes a snapshot of a database with and without the read pool, and finds the data in it.
+func TestBackup(t *testing.T) {
+	for _, readPool := range []bool{true, false} {
+		readPool := readPool
+		name := "read pool"
+		if !readPool {
+			name = "no read pool"
+		}
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			cfg := database.DefaultSQLiteConfig
+			cfg.ReadPool = readPool
+			writer, reader, err := database.OpenSQLite(ctx, filepath.Join(dir, "test.db"), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if reader != nil {
+					_ = reader.Close()
+				}
+				_ = writer.Close()
+			}()
+			db, err := database.NewSQLite(writer, reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := users(ctx, db, "bkpAlice"); err != nil {
+				t.Fatal(err)
+			}
+
+			snapshot := filepath.Join(dir, "snapshot.db")
+			if err := db.Backup(ctx, snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if err := database.VerifyDatabase(snapshot); err != nil {
+				t.Fatal(err)
+			}
+			copied, err := sql.Open("sqlite3", "file:"+snapshot+"?mode=ro")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer copied.Close()
+			var count int
+			if err := copied.QueryRow(`SELECT COUNT(*) FROM users WHERE name = 'bkpAlice'`).Scan(&count); err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("users named bkpAlice in the snapshot: got %d, want 1", count)
+			}
+		})
+	}
+}
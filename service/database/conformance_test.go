@@ -0,0 +1,1089 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// conformanceChecks are behaviours that every AppDatabase must have, so that the storage backends stay
+// interchangeable. They go through the public methods of database.AppDatabase only, and cover what differs the most
+// between the engines: generated ids, upserts and duplicates, booleans, NULL ordering, timestamps, binary data,
+// case-insensitive search and transactions. They are run in this order on the same database; each of them creates its
+// own users and conversations.
+var conformanceChecks = []struct {
+	name string
+	run  func(ctx context.Context, db database.AppDatabase) error
+}{
+	{"users", checkUsers},
+	{"messages", checkMessages},
+	{"conversation list", checkConversationList},
+	{"groups", checkGroups},
+	{"pins and stars", checkPinsAndStars},
+	{"scheduled messages", checkScheduledMessages},
+	{"drafts and settings", checkDraftsAndSettings},
+	{"disappearing messages", checkDisappearingMessages},
+	{"import", checkImport},
+	{"delete user", checkDeleteUser},
+	{"export jobs", checkExportJobs},
+	{"concurrent export jobs", checkConcurrentExportJobs},
+	{"foreign keys", checkForeignKeys},
+	{"lookups", checkLookups},
+	{"cancellation", checkCancellation},
+	{"maintenance", checkMaintenance},
+	{"long conversations", checkLongConversation},
+	{"expiration across time zones", checkExpirationTimeZones},
+}
+
+// TestConformance runs the conformance checks on a new database of every backend. A failed check does not stop the
+// following ones.
+func TestConformance(t *testing.T) {
+	for _, backend := range backends {
+		backend := backend
+		t.Run(backend.name, func(t *testing.T) {
+			db := backend.open(t, nil)
+			for _, check := range conformanceChecks {
+				check := check
+				t.Run(check.name, func(t *testing.T) {
+					if err := check.run(context.Background(), db); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+		})
+	}
+}
+
+// Timestamps are compared with the precision of PostgreSQL, microseconds, and a margin for the clock.
+const timePrecision = time.Millisecond
+
+func sameTime(a time.Time, b time.Time) bool {
+	d := a.Sub(b)
+	return d > -timePrecision && d < timePrecision
+}
+
+func checkUsers(ctx context.Context, db database.AppDatabase) error {
+	created, err := users(ctx, db, "usrAlice", "usrBob")
+	if err != nil {
+		return err
+	}
+	alice, bob := created[0], created[1]
+	if alice.UserId <= database.DeletedUserId || bob.UserId <= alice.UserId {
+		return fmt.Errorf("ids not generated in order: %d, %d", alice.UserId, bob.UserId)
+	}
+	if _, err := db.CreateUser(ctx, "usrAlice"); err == nil {
+		return errors.New("duplicate username accepted")
+	}
+
+	found, err := db.GetUserByName(ctx, "usrBob")
+	if err != nil {
+		return err
+	}
+	if found == nil || found.UserId != bob.UserId {
+		return fmt.Errorf("user by name: got %v, want %d", found, bob.UserId)
+	}
+	if missing, err := db.GetUserByName(ctx, "usrNobody"); err != nil || missing != nil {
+		return fmt.Errorf("missing user by name: got %v, %v", missing, err)
+	}
+
+	if err := db.UpdateUsername(ctx, alice.UserId, "usrAlicia"); err != nil {
+		return err
+	}
+	renamed, err := db.GetUserById(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	if renamed.Name != "usrAlicia" {
+		return fmt.Errorf("renamed user: got %q", renamed.Name)
+	}
+
+	// The search ignores the case, and never returns the placeholder of deleted users
+	results, err := db.SearchUsersByUsername(ctx, "USRBO")
+	if err != nil {
+		return err
+	}
+	if len(results) != 1 || results[0].UserId != bob.UserId {
+		return fmt.Errorf("search: got %v, want user %d", results, bob.UserId)
+	}
+	all, err := db.SearchUsersByUsername(ctx, "")
+	if err != nil {
+		return err
+	}
+	for _, user := range all {
+		if user.UserId == database.DeletedUserId {
+			return errors.New("search returned the placeholder of deleted users")
+		}
+	}
+
+	photo := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff, '\'', '?'}
+	if err := db.UpdateUserPhoto(ctx, bob.UserId, photo); err != nil {
+		return err
+	}
+	withPhoto, err := db.GetUserById(ctx, bob.UserId)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(withPhoto.Photo, photo) {
+		return fmt.Errorf("photo: got %v, want %v", withPhoto.Photo, photo)
+	}
+
+	displayName, bio := "Bob ?", "it's me"
+	profile, err := db.UpdateUserProfile(ctx, bob.UserId, database.ProfileUpdate{DisplayName: &displayName, Bio: &bio})
+	if err != nil {
+		return err
+	}
+	if profile.DisplayName != displayName || profile.Bio != bio {
+		return fmt.Errorf("profile: got %q, %q", profile.DisplayName, profile.Bio)
+	}
+	return nil
+}
+
+func checkMessages(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "msgAlice", "msgBob")
+	if err != nil {
+		return err
+	}
+	alice, bob := members[0], members[1]
+	if conversation.Type != "direct" || conversation.Name != bob.Name {
+		return fmt.Errorf("conversation: got type %q and name %q", conversation.Type, conversation.Name)
+	}
+	if in, err := db.IsUserInConversation(ctx, alice.UserId, conversation.ConversationId); err != nil || !in {
+		return fmt.Errorf("member not in conversation: %v", err)
+	}
+
+	first, err := db.AddMessage(ctx, conversation.ConversationId, alice.UserId, "hello? 'bob'", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	photo := []byte{0, 1, 2, 0xfe, 0xff}
+	second, err := db.AddMessage(ctx, conversation.ConversationId, alice.UserId, "", "received", "photo", photo)
+	if err != nil {
+		return err
+	}
+	if second.MessageId <= first.MessageId {
+		return fmt.Errorf("message ids not generated in order: %d, %d", first.MessageId, second.MessageId)
+	}
+	stored, err := db.GetMessageById(ctx, second.MessageId, conversation.ConversationId)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored.Photo, photo) || !sameTime(stored.Timestamp, second.Timestamp) {
+		return fmt.Errorf("photo message: got %v at %v", stored.Photo, stored.Timestamp)
+	}
+
+	messages, err := db.GetMessagesByConversation(ctx, bob.UserId, conversation.ConversationId, "asc")
+	if err != nil {
+		return err
+	}
+	if len(messages) != 2 || messages[0].MessageId != first.MessageId || messages[0].Text != "hello? 'bob'" {
+		return fmt.Errorf("messages in ascending order: got %v", messages)
+	}
+
+	// Bob never opened the conversation: both messages are unread
+	conversations, err := db.GetConversationsByUser(ctx, bob.UserId, "desc", false)
+	if err != nil {
+		return err
+	}
+	listed := findConversation(conversations, conversation.ConversationId)
+	if listed == nil || listed.LastMessage == nil || listed.LastMessage.MessageID != second.MessageId {
+		return fmt.Errorf("last message: got %v", listed)
+	}
+	if listed.UnreadCount != 2 || listed.FirstUnreadMessageId == nil || *listed.FirstUnreadMessageId != first.MessageId {
+		return fmt.Errorf("unread messages: got %d from %v", listed.UnreadCount, listed.FirstUnreadMessageId)
+	}
+	if err := db.UpdateLastAccess(ctx, bob.UserId, conversation.ConversationId); err != nil {
+		return err
+	}
+	if err := db.MarkConversationUnread(ctx, bob.UserId, conversation.ConversationId); err != nil {
+		return err
+	}
+	conversations, err = db.GetConversationsByUser(ctx, bob.UserId, "desc", false)
+	if err != nil {
+		return err
+	}
+	listed = findConversation(conversations, conversation.ConversationId)
+	if listed == nil || listed.UnreadCount != 0 || !listed.MarkedUnread {
+		return fmt.Errorf("read conversation marked as unread: got %v", listed)
+	}
+
+	reply, err := db.ReplyMessage(ctx, conversation.ConversationId, bob.UserId, first.MessageId, "yes?", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	if reply.ReplyToMessageId == nil || *reply.ReplyToMessageId != first.MessageId {
+		return fmt.Errorf("reply: got %v", reply.ReplyToMessageId)
+	}
+
+	// Reacting twice with the same emoji keeps one reaction
+	reaction, err := db.AddCommentToMessage(ctx, first.MessageId, bob.UserId, "👍")
+	if err != nil {
+		return err
+	}
+	again, err := db.AddCommentToMessage(ctx, first.MessageId, bob.UserId, "👍")
+	if err != nil {
+		return err
+	}
+	if again.CommentId != reaction.CommentId {
+		return fmt.Errorf("duplicate reaction: got %d and %d", reaction.CommentId, again.CommentId)
+	}
+	comments, err := db.GetCommentsByMessage(ctx, first.MessageId)
+	if err != nil {
+		return err
+	}
+	if len(comments) != 1 {
+		return fmt.Errorf("reactions: got %d, want 1", len(comments))
+	}
+	if err := db.DeleteCommentById(ctx, reaction.CommentId); err != nil {
+		return err
+	}
+
+	if err := db.DeleteMessageById(ctx, reply.MessageId); err != nil {
+		return err
+	}
+	if _, err := db.GetMessageById(ctx, reply.MessageId, conversation.ConversationId); err == nil {
+		return errors.New("deleted message still found")
+	}
+	return nil
+}
+
+func checkConversationList(ctx context.Context, db database.AppDatabase) error {
+	members, active, err := direct(ctx, db, "lstAlice", "lstBob", "lstCarol")
+	if err != nil {
+		return err
+	}
+	alice, carol := members[0], members[2]
+	empty, err := db.CreateConversation(ctx, alice.UserId, carol.UserId, "direct")
+	if err != nil {
+		return err
+	}
+	if _, err := db.AddMessage(ctx, active.ConversationId, alice.UserId, "hi", "received", "text", nil); err != nil {
+		return err
+	}
+
+	// Conversations without messages sort as the oldest ones
+	for _, order := range []struct {
+		sort  string
+		first int64
+	}{{"desc", active.ConversationId}, {"asc", empty.ConversationId}} {
+		conversations, err := db.GetConversationsByUser(ctx, alice.UserId, order.sort, false)
+		if err != nil {
+			return err
+		}
+		if len(conversations) != 2 || conversations[0].ConversationId != order.first {
+			return fmt.Errorf("%s order: got %v", order.sort, conversations)
+		}
+	}
+
+	// Pinned conversations come first, archived ones are listed only on request
+	yes := true
+	if _, err := db.UpdateConversationSettings(ctx, alice.UserId, empty.ConversationId, database.MemberSettingsUpdate{Pinned: &yes}); err != nil {
+		return err
+	}
+	// The end of the mute comes in the time zone of the client: it is still an hour from now in any other zone
+	mutedUntil := time.Now().Add(time.Hour).In(time.FixedZone("UTC-12", -12*60*60))
+	settings, err := db.UpdateConversationSettings(ctx, alice.UserId, active.ConversationId,
+		database.MemberSettingsUpdate{Archived: &yes, Muted: &yes, MutedUntil: &mutedUntil})
+	if err != nil {
+		return err
+	}
+	if !settings.Archived || !settings.Muted || settings.MutedUntil == nil || !sameTime(*settings.MutedUntil, mutedUntil) {
+		return fmt.Errorf("settings: got %+v", settings)
+	}
+	conversations, err := db.GetConversationsByUser(ctx, alice.UserId, "desc", false)
+	if err != nil {
+		return err
+	}
+	if len(conversations) != 1 || conversations[0].ConversationId != empty.ConversationId || !conversations[0].Pinned {
+		return fmt.Errorf("without archived: got %v", conversations)
+	}
+	conversations, err = db.GetConversationsByUser(ctx, alice.UserId, "desc", true)
+	if err != nil {
+		return err
+	}
+	if len(conversations) != 2 || conversations[0].ConversationId != empty.ConversationId {
+		return fmt.Errorf("with archived: got %v", conversations)
+	}
+	archived := findConversation(conversations, active.ConversationId)
+	if archived == nil || !archived.Archived || archived.MutedUntil == nil || !sameTime(*archived.MutedUntil, mutedUntil) {
+		return fmt.Errorf("archived conversation: got %+v", archived)
+	}
+
+	// A new message does not bring back a conversation that is still muted
+	if _, err := db.AddMessage(ctx, active.ConversationId, members[1].UserId, "still muted", "sent", "text", nil); err != nil {
+		return err
+	}
+	conversations, err = db.GetConversationsByUser(ctx, alice.UserId, "desc", true)
+	if err != nil {
+		return err
+	}
+	if archived := findConversation(conversations, active.ConversationId); archived == nil || !archived.Archived {
+		return fmt.Errorf("muted conversation after a new message: got %+v", archived)
+	}
+	return nil
+}
+
+func checkGroups(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "grpAlice", "grpBob", "grpCarol")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol := members[0], members[1], members[2]
+	group, err := db.CreateConversation(ctx, alice.UserId, bob.UserId, "group")
+	if err != nil {
+		return err
+	}
+	if err := db.AddUserToGroup(ctx, group.ConversationId, carol.UserId); err != nil {
+		return err
+	}
+	if err := db.AddUserToGroup(ctx, group.ConversationId, carol.UserId); err == nil {
+		return errors.New("member added twice")
+	}
+	names, err := db.GetGroupMembers(ctx, group.ConversationId)
+	if err != nil {
+		return err
+	}
+	if len(names) != 3 {
+		return fmt.Errorf("members: got %v", names)
+	}
+	if err := db.UpdateGroupName(ctx, group.ConversationId, "it's a group"); err != nil {
+		return err
+	}
+	renamed, err := db.GetGroupById(ctx, group.ConversationId)
+	if err != nil {
+		return err
+	}
+	if renamed == nil || renamed.Name != "it's a group" {
+		return fmt.Errorf("group: got %v", renamed)
+	}
+	if err := db.RemoveUserFromGroup(ctx, group.ConversationId, carol.UserId); err != nil {
+		return err
+	}
+	if member, err := db.IsUserMemberOfGroup(ctx, carol.UserId, group.ConversationId); err != nil || member {
+		return fmt.Errorf("removed member still in group: %v", err)
+	}
+
+	original, err := db.AddMessage(ctx, conversation.ConversationId, alice.UserId, "forward me", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	forwarded, err := db.ForwardMessage(ctx, bob.UserId, original, []int64{group.ConversationId})
+	if err != nil {
+		return err
+	}
+	if len(forwarded) != 1 || forwarded[0].ForwardedFrom == nil ||
+		forwarded[0].ForwardedFrom.ConversationId != conversation.ConversationId ||
+		!sameTime(forwarded[0].ForwardedFrom.Timestamp, original.Timestamp) || forwarded[0].ForwardedFrom.Sender == nil ||
+		forwarded[0].ForwardedFrom.Sender.UserId != alice.UserId {
+		return fmt.Errorf("forwarded message: got %+v", forwarded)
+	}
+
+	// The original author is shown only to the users allowed by the Forwards setting of the author
+	settings, err := db.GetUserSettings(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	settings.Forwards = database.VisibilityNobody
+	if err := db.UpdateUserSettings(ctx, alice.UserId, settings); err != nil {
+		return err
+	}
+	forwarded, err = db.ForwardMessage(ctx, bob.UserId, original, []int64{group.ConversationId})
+	if err != nil {
+		return err
+	}
+	if len(forwarded) != 1 || forwarded[0].ForwardedFrom == nil || forwarded[0].ForwardedFrom.Sender != nil {
+		return fmt.Errorf("forwarded message with hidden author: got %+v", forwarded)
+	}
+	for _, viewer := range []struct {
+		user  database.User
+		shown bool
+	}{{bob, false}, {alice, true}} {
+		messages, err := db.GetMessagesByConversation(ctx, viewer.user.UserId, group.ConversationId, "asc")
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if message.ForwardedFrom != nil && (message.ForwardedFrom.Sender != nil) != viewer.shown {
+				return fmt.Errorf("author of forwarded message for %s: got %+v", viewer.user.Name, message.ForwardedFrom)
+			}
+		}
+	}
+	settings.Forwards = database.VisibilityGroups
+	if err := db.UpdateUserSettings(ctx, alice.UserId, settings); err != nil {
+		return err
+	}
+	messages, err := db.GetMessagesByConversation(ctx, bob.UserId, group.ConversationId, "asc")
+	if err != nil {
+		return err
+	}
+	if len(messages) == 0 || messages[len(messages)-1].ForwardedFrom == nil ||
+		messages[len(messages)-1].ForwardedFrom.Sender == nil {
+		return fmt.Errorf("author of forwarded message for a member of the same group: got %+v", messages)
+	}
+	return nil
+}
+
+func checkPinsAndStars(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "pinAlice", "pinBob")
+	if err != nil {
+		return err
+	}
+	alice := members[0]
+
+	// The messages and the pins follow the clock of the service; no message is sent after them in the conversation
+	pinnedAt := time.Now().Add(time.Minute)
+	globaltime.FixedTime = pinnedAt
+	message, err := db.AddMessage(ctx, conversation.ConversationId, alice.UserId, "pin me", "received", "text", nil)
+	if err == nil {
+		_, err = db.PinMessage(ctx, conversation.ConversationId, message.MessageId, alice.UserId, 5)
+	}
+	globaltime.FixedTime = time.Time{}
+	if err != nil {
+		return err
+	}
+	pinned, err := db.GetPinnedMessages(ctx, conversation.ConversationId)
+	if err != nil {
+		return err
+	}
+	if len(pinned) != 1 || pinned[0].Message.MessageId != message.MessageId || pinned[0].PinnedBy.UserId != alice.UserId ||
+		!sameTime(pinned[0].PinnedAt, pinnedAt) {
+		return fmt.Errorf("pinned messages: got %v", pinned)
+	}
+	if err := db.UnpinMessage(ctx, conversation.ConversationId, message.MessageId); err != nil {
+		return err
+	}
+
+	// Starring twice is not an error, and the first time is kept
+	for i := 0; i < 2; i++ {
+		globaltime.FixedTime = pinnedAt.Add(time.Duration(i) * time.Minute)
+		err := db.StarMessage(ctx, alice.UserId, message.MessageId)
+		globaltime.FixedTime = time.Time{}
+		if err != nil {
+			return err
+		}
+	}
+	starred, err := db.GetStarredMessages(ctx, alice.UserId, 10, 0)
+	if err != nil {
+		return err
+	}
+	if len(starred) != 1 || starred[0].Message.MessageId != message.MessageId ||
+		starred[0].Conversation.ConversationId != conversation.ConversationId || !sameTime(starred[0].StarredAt, pinnedAt) {
+		return fmt.Errorf("starred messages: got %v", starred)
+	}
+	return db.UnstarMessage(ctx, alice.UserId, message.MessageId)
+}
+
+func checkScheduledMessages(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "schAlice", "schBob")
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	due, err := db.CreateScheduledMessage(ctx, database.ScheduledMessage{ConversationId: conversation.ConversationId,
+		SenderId: members[0].UserId, Text: "due", Type: "text", SendAt: now.Add(-time.Minute)})
+	if err != nil {
+		return err
+	}
+	later, err := db.CreateScheduledMessage(ctx, database.ScheduledMessage{ConversationId: conversation.ConversationId,
+		SenderId: members[0].UserId, Photo: []byte{1, 2, 3}, Type: "photo", SendAt: now.Add(time.Hour)})
+	if err != nil {
+		return err
+	}
+	if later.ScheduledId <= due.ScheduledId {
+		return fmt.Errorf("scheduled ids not generated in order: %d, %d", due.ScheduledId, later.ScheduledId)
+	}
+	dueMessages, err := db.GetDueScheduledMessages(ctx, now)
+	if err != nil {
+		return err
+	}
+	if len(dueMessages) != 1 || dueMessages[0].ScheduledId != due.ScheduledId {
+		return fmt.Errorf("due messages: got %v", dueMessages)
+	}
+	sendAt := now.Add(2 * time.Hour)
+	if err := db.UpdateScheduledMessage(ctx, later.ScheduledId, "changed", sendAt); err != nil {
+		return err
+	}
+	updated, err := db.GetScheduledMessageById(ctx, later.ScheduledId)
+	if err != nil {
+		return err
+	}
+	if updated.Text != "changed" || !sameTime(updated.SendAt, sendAt) || !bytes.Equal(updated.Photo, []byte{1, 2, 3}) {
+		return fmt.Errorf("updated message: got %+v", updated)
+	}
+	if sent, err := db.SendScheduledMessage(ctx, later.ScheduledId, now); err != nil || sent != nil {
+		return fmt.Errorf("sending a message not due yet: %v, %v", sent, err)
+	}
+	sent, err := db.SendScheduledMessage(ctx, due.ScheduledId, now)
+	if err != nil {
+		return err
+	}
+	if sent == nil || sent.Text != "due" || sent.Sender.UserId != members[0].UserId {
+		return fmt.Errorf("sent message: got %+v", sent)
+	}
+	if sent, err := db.SendScheduledMessage(ctx, due.ScheduledId, now); err != nil || sent != nil {
+		return fmt.Errorf("sending a scheduled message twice: %v, %v", sent, err)
+	}
+	if deleted, err := db.DeleteScheduledMessage(ctx, later.ScheduledId); err != nil || !deleted {
+		return fmt.Errorf("deleting scheduled message: %v, %v", deleted, err)
+	}
+	if deleted, err := db.DeleteScheduledMessage(ctx, later.ScheduledId); err != nil || deleted {
+		return fmt.Errorf("deleting scheduled message twice: %v, %v", deleted, err)
+	}
+	return nil
+}
+
+func checkDraftsAndSettings(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "drfAlice", "drfBob")
+	if err != nil {
+		return err
+	}
+	alice, bob := members[0], members[1]
+	// The drafts follow the clock of the service
+	savedAt := time.Now().Add(-time.Hour)
+	for i, text := range []string{"first", "second"} {
+		globaltime.FixedTime = savedAt.Add(time.Duration(i) * time.Minute)
+		_, err := db.SaveDraft(ctx, alice.UserId, conversation.ConversationId, text)
+		globaltime.FixedTime = time.Time{}
+		if err != nil {
+			return err
+		}
+	}
+	draft, err := db.GetDraft(ctx, alice.UserId, conversation.ConversationId)
+	if err != nil {
+		return err
+	}
+	if draft.Text != "second" || !sameTime(draft.UpdatedAt, savedAt.Add(time.Minute)) {
+		return fmt.Errorf("draft: got %+v", draft)
+	}
+	if err := db.DeleteDraft(ctx, alice.UserId, conversation.ConversationId); err != nil {
+		return err
+	}
+	if _, err := db.GetDraft(ctx, alice.UserId, conversation.ConversationId); err == nil {
+		return errors.New("deleted draft still found")
+	}
+
+	settings, err := db.GetUserSettings(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	if settings.LastSeen != database.VisibilityEveryone {
+		return fmt.Errorf("default settings: got %+v", settings)
+	}
+	settings.LastSeen = database.VisibilityNobody
+	if err := db.UpdateUserSettings(ctx, alice.UserId, settings); err != nil {
+		return err
+	}
+	if allowed, err := db.UserAllows(ctx, alice.UserId, bob.UserId, database.VisibilityNobody); err != nil || allowed {
+		return fmt.Errorf("visibility nobody: %v, %v", allowed, err)
+	}
+	if allowed, err := db.UserAllows(ctx, alice.UserId, bob.UserId, database.VisibilityEveryone); err != nil || !allowed {
+		return fmt.Errorf("visibility everyone: %v, %v", allowed, err)
+	}
+	stored, err := db.GetUserSettings(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	if stored != settings {
+		return fmt.Errorf("settings: got %+v, want %+v", stored, settings)
+	}
+
+	lastSeen := time.Now().Add(-time.Minute)
+	if err := db.UpdateLastSeen(ctx, alice.UserId, lastSeen); err != nil {
+		return err
+	}
+	seen, err := db.GetLastSeen(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	if seen == nil || !sameTime(*seen, lastSeen) {
+		return fmt.Errorf("last seen: got %v, want %v", seen, lastSeen)
+	}
+	return nil
+}
+
+func checkDisappearingMessages(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "ttlAlice", "ttlBob")
+	if err != nil {
+		return err
+	}
+	kept, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "kept", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	if err := db.SetMessageTTL(ctx, conversation.ConversationId, time.Hour); err != nil {
+		return err
+	}
+	message, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "gone", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	if message.ExpiresAt == nil || !sameTime(*message.ExpiresAt, message.Timestamp.Add(time.Hour)) {
+		return fmt.Errorf("expiration: got %v", message.ExpiresAt)
+	}
+
+	// Expired messages are hidden at the time of the service clock, before DeleteExpiredMessages runs
+	later := time.Now().Add(2 * time.Hour)
+	globaltime.FixedTime = later
+	visible, err := db.GetMessagesByConversation(ctx, members[1].UserId, conversation.ConversationId, "asc")
+	globaltime.FixedTime = time.Time{}
+	if err != nil {
+		return err
+	}
+	if len(visible) != 1 || visible[0].MessageId != kept.MessageId {
+		return fmt.Errorf("messages once expired: got %v", visible)
+	}
+	deleted, err := db.DeleteExpiredMessages(ctx, later)
+	if err != nil {
+		return err
+	}
+	if deleted < 1 {
+		return fmt.Errorf("expired messages: deleted %d", deleted)
+	}
+	if _, err := db.GetMessageById(ctx, message.MessageId, conversation.ConversationId); err == nil {
+		return errors.New("expired message still found")
+	}
+	summary, err := db.GetConversationById(ctx, conversation.ConversationId)
+	if err != nil {
+		return err
+	}
+	if summary.LastMessage == nil || summary.LastMessage.MessageID != kept.MessageId {
+		return fmt.Errorf("last message after expiration: got %v", summary.LastMessage)
+	}
+	return nil
+}
+
+// checkExpirationTimeZones sends a disappearing message while the local time zone is behind UTC, and reads it once
+// the zone is ahead of UTC, as across a change of daylight saving time: the message must disappear at the right time,
+// whatever the zone.
+func checkExpirationTimeZones(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "tzAlice", "tzBob")
+	if err != nil {
+		return err
+	}
+	if err := db.SetMessageTTL(ctx, conversation.ConversationId, time.Hour); err != nil {
+		return err
+	}
+	local := time.Local
+	defer func() {
+		time.Local = local
+		globaltime.FixedTime = time.Time{}
+	}()
+
+	sentAt := time.Now().Truncate(time.Second)
+	time.Local = time.FixedZone("UTC-10", -10*60*60)
+	globaltime.FixedTime = sentAt.Local()
+	message, err := db.AddMessage(ctx, conversation.ConversationId, members[0].UserId, "soon gone", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	if !sameTime(message.Timestamp, sentAt) {
+		return fmt.Errorf("timestamp: got %v, want %v", message.Timestamp, sentAt)
+	}
+
+	time.Local = time.FixedZone("UTC+10", 10*60*60)
+	for _, read := range []struct {
+		after   time.Duration
+		visible bool
+	}{{30 * time.Minute, true}, {2 * time.Hour, false}} {
+		globaltime.FixedTime = sentAt.Add(read.after).Local()
+		messages, err := db.GetMessagesByConversation(ctx, members[1].UserId, conversation.ConversationId, "asc")
+		if err != nil {
+			return err
+		}
+		if visible := len(messages) == 1; visible != read.visible {
+			return fmt.Errorf("message %v after sending: got %d messages, want visible %v", read.after, len(messages), read.visible)
+		}
+		if _, err := db.GetMessageById(ctx, message.MessageId, conversation.ConversationId); (err == nil) != read.visible {
+			return fmt.Errorf("message %v after sending by id: got %v, want visible %v", read.after, err, read.visible)
+		}
+	}
+	deleted, err := db.DeleteExpiredMessages(ctx, sentAt.Add(30*time.Minute).Local())
+	if err != nil {
+		return err
+	}
+	if deleted != 0 {
+		return fmt.Errorf("messages deleted before expiring: %d", deleted)
+	}
+	return nil
+}
+
+func checkImport(ctx context.Context, db database.AppDatabase) error {
+	members, err := users(ctx, db, "impAlice", "impBob")
+	if err != nil {
+		return err
+	}
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	chat := database.ImportedChat{
+		Source:         "conformance",
+		ExternalId:     "chat-1",
+		Name:           "Imported",
+		Type:           "group",
+		MemberIds:      []int64{members[0].UserId, members[1].UserId},
+		ParticipantIds: map[string]int64{"alice": members[0].UserId, "bob": members[1].UserId},
+		Messages: []database.ImportedMessage{
+			{ExternalId: "1", SenderId: members[0].UserId, Timestamp: start, Text: "first"},
+			{ExternalId: "2", SenderId: members[1].UserId, Timestamp: start.Add(time.Minute), Text: "reply", ReplyTo: "1"},
+		},
+	}
+	result, err := db.ImportConversation(ctx, chat)
+	if err != nil {
+		return err
+	}
+	if !result.Created || result.Imported != 2 || result.Skipped != 0 {
+		return fmt.Errorf("first import: got %+v", result)
+	}
+	participants, err := db.GetImportedParticipants(ctx, chat.Source, chat.ExternalId)
+	if err != nil {
+		return err
+	}
+	if len(participants) != 2 || participants["alice"] != members[0].UserId || participants["bob"] != members[1].UserId {
+		return fmt.Errorf("imported participants: got %v", participants)
+	}
+	if none, err := db.GetImportedParticipants(ctx, chat.Source, "chat-2"); err != nil || len(none) != 0 {
+		return fmt.Errorf("participants of a chat never imported: got %v, %v", none, err)
+	}
+
+	// Importing again only adds the new messages
+	chat.Messages = append(chat.Messages, database.ImportedMessage{ExternalId: "3", SenderId: members[0].UserId,
+		Timestamp: start.Add(2 * time.Minute), Photo: []byte{9, 8, 7}})
+	again, err := db.ImportConversation(ctx, chat)
+	if err != nil {
+		return err
+	}
+	if again.Created || again.ConversationId != result.ConversationId || again.Imported != 1 || again.Skipped != 2 {
+		return fmt.Errorf("second import: got %+v", again)
+	}
+
+	messages, err := db.GetMessagesByConversation(ctx, members[1].UserId, result.ConversationId, "asc")
+	if err != nil {
+		return err
+	}
+	if len(messages) != 3 || !messages[0].Timestamp.Equal(start) {
+		return fmt.Errorf("imported messages: got %v", messages)
+	}
+	if messages[1].ReplyToMessageId == nil || *messages[1].ReplyToMessageId != messages[0].MessageId {
+		return fmt.Errorf("imported reply: got %v", messages[1].ReplyToMessageId)
+	}
+	return nil
+}
+
+// checkLongConversation reads a conversation longer than a batch of eachMessage, with many messages sent at the same
+// time, while writing to the database: every message must be visited once, in order.
+func checkLongConversation(ctx context.Context, db database.AppDatabase) error {
+	members, other, err := direct(ctx, db, "longAlice", "longBob")
+	if err != nil {
+		return err
+	}
+	const count = 250
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	chat := database.ImportedChat{Source: "conformance", ExternalId: "long", Name: "Long", Type: "group",
+		MemberIds: []int64{members[0].UserId, members[1].UserId}}
+	for i := 0; i < count; i++ {
+		// Three messages per second, so that the batches end within messages sent at the same time
+		chat.Messages = append(chat.Messages, database.ImportedMessage{ExternalId: fmt.Sprint(i),
+			SenderId: members[i%2].UserId, Timestamp: start.Add(time.Duration(i/3) * time.Second), Text: fmt.Sprint(i)})
+	}
+	result, err := db.ImportConversation(ctx, chat)
+	if err != nil {
+		return err
+	}
+
+	var visited []database.Message
+	if err := db.IterateMessages(ctx, members[0].UserId, result.ConversationId, time.Time{}, time.Time{},
+		func(message database.Message) error {
+			visited = append(visited, message)
+			// The query must not hold the database while the messages are handled
+			if len(visited)%50 == 0 {
+				_, err := db.AddMessage(ctx, other.ConversationId, members[0].UserId, "meanwhile", "sent", "text", nil)
+				return err
+			}
+			return nil
+		}); err != nil {
+		return err
+	}
+	if len(visited) != count {
+		return fmt.Errorf("iterated messages: got %d, want %d", len(visited), count)
+	}
+	for i, message := range visited {
+		if message.Text != fmt.Sprint(i) {
+			return fmt.Errorf("iterated message %d: got %q", i, message.Text)
+		}
+	}
+
+	newest, err := db.GetMessagesByConversation(ctx, members[0].UserId, result.ConversationId, "desc")
+	if err != nil {
+		return err
+	}
+	if len(newest) != count {
+		return fmt.Errorf("messages newest first: got %d, want %d", len(newest), count)
+	}
+	for i, message := range newest {
+		if message.MessageId != visited[count-1-i].MessageId {
+			return fmt.Errorf("message %d newest first: got %d, want %d", i, message.MessageId, visited[count-1-i].MessageId)
+		}
+	}
+	return nil
+}
+
+func checkDeleteUser(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "delAlice", "delBob", "delCarol")
+	if err != nil {
+		return err
+	}
+	alice, bob, carol := members[0], members[1], members[2]
+	message, err := db.AddMessage(ctx, conversation.ConversationId, alice.UserId, "hi", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	group, err := db.CreateConversation(ctx, bob.UserId, carol.UserId, "group")
+	if err != nil {
+		return err
+	}
+	if err := db.AddUserToGroup(ctx, group.ConversationId, alice.UserId); err != nil {
+		return err
+	}
+	groupMessage, err := db.AddMessage(ctx, group.ConversationId, bob.UserId, "hello", "received", "text", nil)
+	if err != nil {
+		return err
+	}
+	// The same reaction of two deleted users is merged into one of the placeholder
+	for _, user := range []database.User{alice, carol} {
+		if _, err := db.AddCommentToMessage(ctx, groupMessage.MessageId, user.UserId, "❤️"); err != nil {
+			return err
+		}
+	}
+	for _, user := range []database.User{alice, carol} {
+		if err := db.DeleteUser(ctx, user.UserId); err != nil {
+			return err
+		}
+	}
+
+	if _, err := db.GetUserById(ctx, alice.UserId); err == nil {
+		return errors.New("deleted user still found")
+	}
+	stored, err := db.GetMessageById(ctx, message.MessageId, conversation.ConversationId)
+	if err != nil {
+		return err
+	}
+	if stored.Sender.UserId != database.DeletedUserId {
+		return fmt.Errorf("message of deleted user: sender %d", stored.Sender.UserId)
+	}
+	if in, err := db.IsUserInConversation(ctx, bob.UserId, conversation.ConversationId); err != nil || !in {
+		return fmt.Errorf("direct conversation lost by the other member: %v", err)
+	}
+	comments, err := db.GetCommentsByMessage(ctx, groupMessage.MessageId)
+	if err != nil {
+		return err
+	}
+	if len(comments) != 1 || comments[0].Sender.UserId != database.DeletedUserId {
+		return fmt.Errorf("reactions of deleted users: got %v", comments)
+	}
+	names, err := db.GetGroupMembers(ctx, group.ConversationId)
+	if err != nil {
+		return err
+	}
+	if len(names) != 1 {
+		return fmt.Errorf("group members after deletion: got %v", names)
+	}
+	return nil
+}
+
+func checkExportJobs(ctx context.Context, db database.AppDatabase) error {
+	created, err := users(ctx, db, "expAlice")
+	if err != nil {
+		return err
+	}
+	// The jobs follow the clock of the service, like their expiration
+	createdAt := time.Now().Add(-3 * time.Hour)
+	globaltime.FixedTime = createdAt
+	first, isNew, err := db.CreateExportJob(ctx, created[0].UserId)
+	globaltime.FixedTime = time.Time{}
+	if err != nil {
+		return err
+	}
+	if !isNew {
+		return errors.New("first export job not created")
+	}
+	if stored, err := db.GetExportJob(ctx, first.JobId); err != nil || !sameTime(stored.CreatedAt, createdAt) {
+		return fmt.Errorf("export job creation time: got %+v, %v", stored, err)
+	}
+	again, isNew, err := db.CreateExportJob(ctx, created[0].UserId)
+	if err != nil {
+		return err
+	}
+	if isNew || again.JobId != first.JobId {
+		return fmt.Errorf("second job created while the first is pending: got %+v, want %+v", again, first)
+	}
+
+	completedAt := time.Now().Add(-2 * time.Hour)
+	expiresAt := completedAt.Add(time.Hour)
+	first.Status, first.CompletedAt, first.ExpiresAt, first.FilePath = database.ExportDone, &completedAt, &expiresAt, "x.zip"
+	if err := db.UpdateExportJob(ctx, first); err != nil {
+		return err
+	}
+	second, isNew, err := db.CreateExportJob(ctx, created[0].UserId)
+	if err != nil {
+		return err
+	}
+	if !isNew || second.JobId <= first.JobId {
+		return fmt.Errorf("job after a completed one: got %+v, first %d", second, first.JobId)
+	}
+	expired, err := db.GetExpiredExportJobs(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if len(expired) != 1 || expired[0].JobId != first.JobId || expired[0].ExpiresAt == nil ||
+		!sameTime(*expired[0].ExpiresAt, expiresAt) {
+		return fmt.Errorf("expired jobs: got %+v", expired)
+	}
+	failed, err := db.FailUnfinishedExportJobs(ctx)
+	if err != nil {
+		return err
+	}
+	if failed != 1 {
+		return fmt.Errorf("unfinished jobs: failed %d, want 1", failed)
+	}
+	jobs, err := db.GetExportJobs(ctx, created[0].UserId)
+	if err != nil {
+		return err
+	}
+	if len(jobs) != 2 || jobs[0].JobId != second.JobId || jobs[0].Status != database.ExportFailed {
+		return fmt.Errorf("jobs: got %+v", jobs)
+	}
+	return nil
+}
+
+// checkConcurrentExportJobs requests the export of the same user many times at once: only one job may be created,
+// and every request must get it.
+func checkConcurrentExportJobs(ctx context.Context, db database.AppDatabase) error {
+	created, err := users(ctx, db, "cexAlice")
+	if err != nil {
+		return err
+	}
+	const requests = 16
+	jobs := make([]database.ExportJob, requests)
+	isNew := make([]bool, requests)
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := range jobs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			jobs[i], isNew[i], errs[i] = db.CreateExportJob(ctx, created[0].UserId)
+		}(i)
+	}
+	wg.Wait()
+	var news int
+	for i := range jobs {
+		if errs[i] != nil {
+			return fmt.Errorf("request %d: %w", i, errs[i])
+		}
+		if jobs[i].JobId != jobs[0].JobId {
+			return fmt.Errorf("request %d: got job %d, want %d", i, jobs[i].JobId, jobs[0].JobId)
+		}
+		if isNew[i] {
+			news++
+		}
+	}
+	if news != 1 {
+		return fmt.Errorf("jobs created: got %d, want 1", news)
+	}
+	stored, err := db.GetExportJobs(ctx, created[0].UserId)
+	if err != nil {
+		return err
+	}
+	if len(stored) != 1 {
+		return fmt.Errorf("stored jobs: got %d, want 1", len(stored))
+	}
+	return nil
+}
+
+func checkCancellation(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "ctxAlice", "ctxBob")
+	if err != nil {
+		return err
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := db.GetUserByName(cancelled, "ctxAlice"); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("read with a cancelled context: got %v", err)
+	}
+	if _, err := db.AddMessage(cancelled, conversation.ConversationId, members[0].UserId, "lost", "sent", "text", nil); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("write with a cancelled context: got %v", err)
+	}
+	expired, cancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer cancel()
+	if _, err := db.GetConversationsByUser(expired, members[0].UserId, "desc", false); !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("read after the deadline: got %v", err)
+	}
+
+	// Nothing was written by the cancelled calls
+	messages, err := db.GetMessagesByConversation(ctx, members[0].UserId, conversation.ConversationId, "asc")
+	if err != nil {
+		return err
+	}
+	if len(messages) != 0 {
+		return fmt.Errorf("messages after cancelled writes: got %d", len(messages))
+	}
+	return nil
+}
+
+// checkForeignKeys checks that the rows referring to a missing one are refused.
+func checkForeignKeys(ctx context.Context, db database.AppDatabase) error {
+	members, conversation, err := direct(ctx, db, "fkAlice", "fkBob")
+	if err != nil {
+		return err
+	}
+	const missing = 1 << 40
+	if err := db.StarMessage(ctx, members[0].UserId, missing); err == nil {
+		return errors.New("starred a missing message")
+	}
+	if _, err := db.SaveDraft(ctx, missing, conversation.ConversationId, "draft"); err == nil {
+		return errors.New("saved the draft of a missing user")
+	}
+	if _, err := db.AddMessage(ctx, missing, members[0].UserId, "lost", "sent", "text", nil); err == nil {
+		return errors.New("added a message to a missing conversation")
+	}
+	starred, err := db.GetStarredMessages(ctx, members[0].UserId, 10, 0)
+	if err != nil {
+		return err
+	}
+	if len(starred) != 0 {
+		return fmt.Errorf("starred messages: got %d, want 0", len(starred))
+	}
+	return nil
+}
+
+func checkMaintenance(ctx context.Context, db database.AppDatabase) error {
+	problems, err := db.CheckConversations(ctx, 0)
+	if err != nil {
+		return err
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("problems after the other checks: %v", problems)
+	}
+	if _, err := db.RepairConversations(ctx, 0); err != nil {
+		return err
+	}
+	stats, err := db.GetStatistics(ctx)
+	if err != nil {
+		return err
+	}
+	if stats.Users == 0 || stats.Messages == 0 || stats.Groups == 0 || stats.ImportedChats != 1 || stats.DatabaseSize <= 0 {
+		return fmt.Errorf("statistics: got %+v", stats)
+	}
+	list, err := db.ListUsers(ctx, 2, 1)
+	if err != nil {
+		return err
+	}
+	if len(list) != 2 {
+		return fmt.Errorf("users page: got %v", list)
+	}
+	return db.Vacuum(ctx)
+}
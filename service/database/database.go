@@ -31,71 +31,148 @@ Then you can initialize the AppDatabase and pass it to the api package.
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 )
 
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
-	GetName() (string, error)
-	SetName(name string) error
-
-	Ping() error
-
-	CreateUser(name string) (User, error)
-	GetUserByName(name string) (*User, error)
-	UpdateUsername(userId int64, newUsername string) error
-	GetUserById(userId int64) (User, error)
-	IsUserInConversation(userId int64, conversationId int64) (bool, error)
-	GetConversationsByUser(userId int64, sortOrder string) ([]Conversation, error)
-	GetMessagesByConversation(userId int64, conversationId int64, sortOrder string) ([]Message, error)
-	GetCommentsByMessage(messageId int64) ([]Comment, error)
-	AddMessage(conversationId int64, senderId int64, content string, status string, messageType string, photo []byte) (Message, error)
-	ForwardMessage(userId int64, originalMessage Message, targetConversationId int64) (Message, error)
-	GetMessageById(messageId int64, conversationId int64) (Message, error)
-	AddCommentToMessage(messageId int64, senderId int64, content string) (Comment, error)
-	GetCommentById(commentId int64) (Comment, error)
-	DeleteCommentById(commentId int64) error
-	DeleteMessageById(messageId int64) error
-	GetGroupById(conversationId int64) (*Conversation, error)
-	AddUserToGroup(conversationId int64, userId int64) error
-	IsUserMemberOfGroup(userId int64, conversationId int64) (bool, error)
-	RemoveUserFromGroup(conversationId int64, userId int64) error
-	UpdateGroupName(conversationId int64, newName string) error
-	UpdateUserPhoto(userId int64, photoData []byte) error
-	UpdateGroupPhoto(conversationId int64, photoData []byte) error
-	GetConversationById(conversationId int64) (Conversation, error)
-	CreateConversation(user1Id, user2Id int64, conversationType string) (Conversation, error)
-	SearchUsersByUsername(username string) ([]User, error)
-	GetGroupMembers(groupId int64) ([]string, error)
-	ReplyMessage(conversationId int64, senderId int64, replyMessageId int64, text string, status string, messageType string, photo []byte) (Message, error)
+	GetName(ctx context.Context) (string, error)
+	SetName(ctx context.Context, name string) error
+
+	Ping(ctx context.Context) error
+
+	CreateUser(ctx context.Context, name string) (User, error)
+	GetUserByName(ctx context.Context, name string) (*User, error)
+	UpdateUsername(ctx context.Context, userId int64, newUsername string) error
+	GetUserById(ctx context.Context, userId int64) (User, error)
+	IsUserInConversation(ctx context.Context, userId int64, conversationId int64) (bool, error)
+	GetConversationsByUser(ctx context.Context, userId int64, sortOrder string, includeArchived bool) ([]Conversation, error)
+	GetMessagesByConversation(ctx context.Context, userId int64, conversationId int64, sortOrder string) ([]Message, error)
+	IterateMessages(ctx context.Context, userId int64, conversationId int64, from time.Time, to time.Time, fn func(Message) error) error
+	GetConversationSummary(ctx context.Context, conversationId int64, userId int64) (Conversation, error)
+	GetCommentsByMessage(ctx context.Context, messageId int64) ([]Comment, error)
+	AddMessage(ctx context.Context, conversationId int64, senderId int64, content string, status string, messageType string, photo []byte) (Message, error)
+	ForwardMessage(ctx context.Context, userId int64, originalMessage Message, targetConversationIds []int64) ([]Message, error)
+	GetMessageById(ctx context.Context, messageId int64, conversationId int64) (Message, error)
+	AddCommentToMessage(ctx context.Context, messageId int64, senderId int64, content string) (Comment, error)
+	GetCommentById(ctx context.Context, commentId int64) (Comment, error)
+	DeleteCommentById(ctx context.Context, commentId int64) error
+	DeleteMessageById(ctx context.Context, messageId int64) error
+	GetGroupById(ctx context.Context, conversationId int64) (*Conversation, error)
+	AddUserToGroup(ctx context.Context, conversationId int64, userId int64) error
+	IsUserMemberOfGroup(ctx context.Context, userId int64, conversationId int64) (bool, error)
+	RemoveUserFromGroup(ctx context.Context, conversationId int64, userId int64) error
+	UpdateGroupName(ctx context.Context, conversationId int64, newName string) error
+	UpdateUserPhoto(ctx context.Context, userId int64, photoData []byte) error
+	UpdateGroupPhoto(ctx context.Context, conversationId int64, photoData []byte) error
+	GetConversationById(ctx context.Context, conversationId int64) (Conversation, error)
+	CreateConversation(ctx context.Context, user1Id, user2Id int64, conversationType string) (Conversation, error)
+	ImportConversation(ctx context.Context, chat ImportedChat) (ImportResult, error)
+	GetImportedParticipants(ctx context.Context, source string, externalId string) (map[string]int64, error)
+	SearchUsersByUsername(ctx context.Context, username string) ([]User, error)
+	GetGroupMembers(ctx context.Context, groupId int64) ([]string, error)
+	ReplyMessage(ctx context.Context, conversationId int64, senderId int64, replyMessageId int64, text string, status string, messageType string, photo []byte) (Message, error)
+	PinMessage(ctx context.Context, conversationId int64, messageId int64, userId int64, limit int) (PinnedMessage, error)
+	UnpinMessage(ctx context.Context, conversationId int64, messageId int64) error
+	GetPinnedMessages(ctx context.Context, conversationId int64) ([]PinnedMessage, error)
+	GetMessageConversationId(ctx context.Context, messageId int64) (int64, error)
+	StarMessage(ctx context.Context, userId int64, messageId int64) error
+	UnstarMessage(ctx context.Context, userId int64, messageId int64) error
+	GetStarredMessages(ctx context.Context, userId int64, limit int, offset int) ([]StarredMessage, error)
+	UpdateLastAccess(ctx context.Context, userId int64, conversationId int64) error
+	MarkConversationUnread(ctx context.Context, userId int64, conversationId int64) error
+	UpdateConversationSettings(ctx context.Context, userId int64, conversationId int64, update MemberSettingsUpdate) (MemberSettings, error)
+	SetMessageTTL(ctx context.Context, conversationId int64, ttl time.Duration) error
+	DeleteExpiredMessages(ctx context.Context, now time.Time) (int, error)
+	CreateScheduledMessage(ctx context.Context, scheduled ScheduledMessage) (ScheduledMessage, error)
+	GetScheduledMessageById(ctx context.Context, scheduledId int64) (ScheduledMessage, error)
+	GetScheduledMessages(ctx context.Context, userId int64, conversationId int64) ([]ScheduledMessage, error)
+	GetDueScheduledMessages(ctx context.Context, now time.Time) ([]ScheduledMessage, error)
+	UpdateScheduledMessage(ctx context.Context, scheduledId int64, text string, sendAt time.Time) error
+	DeleteScheduledMessage(ctx context.Context, scheduledId int64) (bool, error)
+	SendScheduledMessage(ctx context.Context, scheduledId int64, now time.Time) (*Message, error)
+	SaveDraft(ctx context.Context, userId int64, conversationId int64, text string) (Draft, error)
+	GetDraft(ctx context.Context, userId int64, conversationId int64) (Draft, error)
+	DeleteDraft(ctx context.Context, userId int64, conversationId int64) error
+	UpdateLastSeen(ctx context.Context, userId int64, lastSeen time.Time) error
+	GetLastSeen(ctx context.Context, userId int64) (*time.Time, error)
+	GetUserSettings(ctx context.Context, userId int64) (UserSettings, error)
+	UpdateUserSettings(ctx context.Context, userId int64, settings UserSettings) error
+	UserAllows(ctx context.Context, ownerId int64, viewerId int64, visibility string) (bool, error)
+	GetUserProfile(ctx context.Context, userId int64) (Profile, error)
+	UpdateUserProfile(ctx context.Context, userId int64, update ProfileUpdate) (Profile, error)
+	DeleteUser(ctx context.Context, userId int64) error
+	CreateExportJob(ctx context.Context, userId int64) (ExportJob, bool, error)
+	GetExportJob(ctx context.Context, jobId int64) (ExportJob, error)
+	GetExportJobs(ctx context.Context, userId int64) ([]ExportJob, error)
+	UpdateExportJob(ctx context.Context, job ExportJob) error
+	GetExpiredExportJobs(ctx context.Context, now time.Time) ([]ExportJob, error)
+	FailUnfinishedExportJobs(ctx context.Context) (int, error)
+	ListUsers(ctx context.Context, limit int, offset int) ([]User, error)
+	GetStatistics(ctx context.Context) (Statistics, error)
+	CheckConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error)
+	RepairConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error)
+	Vacuum(ctx context.Context) error
+	Backup(ctx context.Context, destination string) error
 }
 
 type appdbimpl struct {
-	c *sql.DB
+	c *conn
+
+	// q runs the statements of the methods: it is c, or the transaction of the unit of work they are part of (see
+	// withTx), which is also kept in tx.
+	q  execer
+	tx *tx
 }
 
 // New returns a new instance of AppDatabase based on the SQLite connection `db`.
 // `db` is required - an error will be returned if `db` is `nil`.
 func New(db *sql.DB) (AppDatabase, error) {
-	if db == nil {
+	return open(db, nil, sqliteDialect{})
+}
+
+// NewSQLite returns a new instance of AppDatabase based on the SQLite pools returned by OpenSQLite: `writer` runs the
+// statements and the transactions, `reader`, which can be nil, the queries outside transactions.
+// `writer` is required - an error will be returned if `writer` is `nil`.
+func NewSQLite(writer *sql.DB, reader *sql.DB) (AppDatabase, error) {
+	return open(writer, reader, sqliteDialect{})
+}
+
+// NewPostgres returns a new instance of AppDatabase based on the PostgreSQL connection `db`, opened with the
+// github.com/lib/pq driver. It behaves like the SQLite one, except for Backup, which is left to pg_dump.
+// `db` is required - an error will be returned if `db` is `nil`.
+func NewPostgres(db *sql.DB) (AppDatabase, error) {
+	return open(db, nil, newPostgresDialect())
+}
+
+// open creates or upgrades the schema of the database and returns the AppDatabase using it, with the read only pool
+// readDB if not nil.
+func open(sqlDB *sql.DB, readDB *sql.DB, d dialect) (AppDatabase, error) {
+	if sqlDB == nil {
 		return nil, errors.New("database is required when building a AppDatabase")
 	}
+	db := &conn{db: sqlDB, dialect: d}
+	ctx := context.Background()
 
 	// Check if table exists. If not, the database is empty, and we need to create the structure
-	var tableName string
-	err := db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='example_table';`).Scan(&tableName)
-	if errors.Is(err, sql.ErrNoRows) {
+	exists, err := d.tableExists(ctx, db, "example_table")
+	if err != nil {
+		return nil, fmt.Errorf("error checking %s database structure: %w", d.name(), err)
+	}
+	if !exists {
 		sqlStmt := `CREATE TABLE example_table (id INTEGER NOT NULL PRIMARY KEY, name TEXT);`
-		_, err = db.Exec(sqlStmt)
+		_, err = db.ExecContext(ctx, sqlStmt)
 		if err != nil {
 			return nil, fmt.Errorf("error creating database structure: %w", err)
 		}
 	}
 
 	// Create the users table if it doesn't already exist.
-	if _, err := db.Exec(`
+	if _, err := db.ExecContext(ctx, `
 	CREATE TABLE IF NOT EXISTS users (
 		id INTEGER PRIMARY KEY,
 		name TEXT NOT NULL UNIQUE,
@@ -105,7 +182,7 @@ func New(db *sql.DB) (AppDatabase, error) {
 	}
 
 	// Create the conversations table if it doesn't already exist.
-	if _, err := db.Exec(`
+	if _, err := db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS conversations (
 			conversation_id INTEGER PRIMARY KEY,
 			name TEXT NOT NULL,
@@ -118,7 +195,7 @@ func New(db *sql.DB) (AppDatabase, error) {
 	}
 
 	// Create the conversation members table if it doesn't already exist.
-	if _, err := db.Exec(`
+	if _, err := db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS conversation_members (
 			conversation_id INTEGER NOT NULL,
 			user_id INTEGER NOT NULL,
@@ -131,7 +208,7 @@ func New(db *sql.DB) (AppDatabase, error) {
 	}
 
 	// Create the messages table if it doesn't already exist.
-	if _, err := db.Exec(`
+	if _, err := db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS messages (
 			message_id INTEGER PRIMARY KEY,
 			timestamp DATETIME NOT NULL,
@@ -150,7 +227,7 @@ func New(db *sql.DB) (AppDatabase, error) {
 	}
 
 	// Create the comments table if it doesn't already exist.
-	if _, err := db.Exec(`
+	if _, err := db.ExecContext(ctx, `
 		CREATE TABLE IF NOT EXISTS comments (
 			comment_id INTEGER PRIMARY KEY,
 			message_id INTEGER NOT NULL,
@@ -162,11 +239,349 @@ func New(db *sql.DB) (AppDatabase, error) {
 		return nil, fmt.Errorf("error creating comments table: %w", err)
 	}
 
+	// Create the pinned messages table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS pinned_messages (
+			conversation_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			pinned_by INTEGER NOT NULL,
+			pinned_at DATETIME NOT NULL,
+			PRIMARY KEY (conversation_id, message_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages (message_id) ON DELETE CASCADE,
+			FOREIGN KEY (pinned_by) REFERENCES users (id)
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating pinned_messages table: %w", err)
+	}
+
+	// Create the starred messages table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS starred_messages (
+			user_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			starred_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, message_id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (message_id) REFERENCES messages (message_id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating starred_messages table: %w", err)
+	}
+
+	// Create the message mentions table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS message_mentions (
+			message_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			text_offset INTEGER NOT NULL,
+			text_length INTEGER NOT NULL,
+			PRIMARY KEY (message_id, text_offset),
+			FOREIGN KEY (message_id) REFERENCES messages (message_id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating message_mentions table: %w", err)
+	}
+
+	// Create the drafts table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS drafts (
+			user_id INTEGER NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			updated_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, conversation_id),
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE,
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating drafts table: %w", err)
+	}
+
+	// Create the scheduled messages table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS scheduled_messages (
+			scheduled_id INTEGER PRIMARY KEY,
+			conversation_id INTEGER NOT NULL,
+			sender_id INTEGER NOT NULL,
+			text TEXT NOT NULL DEFAULT '',
+			photo BLOB,
+			type TEXT CHECK(type IN ('text', 'photo')) NOT NULL,
+			send_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL,
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+			FOREIGN KEY (sender_id) REFERENCES users (id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating scheduled_messages table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS scheduled_messages_send_at
+		ON scheduled_messages (send_at)`); err != nil {
+		return nil, fmt.Errorf("error creating scheduled messages index: %w", err)
+	}
+
+	// Create the personal data export jobs table if it doesn't already exist.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS export_jobs (
+			job_id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			completed_at DATETIME,
+			expires_at DATETIME,
+			file_path TEXT NOT NULL DEFAULT '',
+			error TEXT NOT NULL DEFAULT '',
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating export_jobs table: %w", err)
+	}
+
+	// Conversations imported from other messengers, and the messages already imported into each of them, so that
+	// importing the same export again only adds what is missing.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS chat_imports (
+			source TEXT NOT NULL,
+			external_id TEXT NOT NULL,
+			conversation_id INTEGER NOT NULL,
+			imported_at DATETIME NOT NULL,
+			PRIMARY KEY (source, external_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating chat_imports table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS imported_messages (
+			conversation_id INTEGER NOT NULL,
+			external_id TEXT NOT NULL,
+			message_id INTEGER NOT NULL,
+			PRIMARY KEY (conversation_id, external_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating imported_messages table: %w", err)
+	}
+	// The user each participant of an imported chat was mapped to, so that importing it again maps them to the same
+	// users.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS imported_participants (
+			conversation_id INTEGER NOT NULL,
+			external_id TEXT NOT NULL,
+			user_id INTEGER NOT NULL,
+			PRIMARY KEY (conversation_id, external_id),
+			FOREIGN KEY (conversation_id) REFERENCES conversations (conversation_id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating imported_participants table: %w", err)
+	}
+
+	// Forward attribution columns, added after the first release of the schema.
+	for _, col := range []struct{ name, definition string }{
+		{"forwarded_from_sender_id", "INTEGER REFERENCES users (id)"},
+		{"forwarded_from_conversation_id", "INTEGER REFERENCES conversations (conversation_id)"},
+		{"forwarded_from_timestamp", "DATETIME"},
+	} {
+		if err := addColumnIfMissing(ctx, db, "messages", col.name, col.definition); err != nil {
+			return nil, err
+		}
+	}
+
+	// Disappearing messages: per-conversation timer and per-message expiration.
+	if err := addColumnIfMissing(ctx, db, "conversations", "message_ttl", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+	if err := addColumnIfMissing(ctx, db, "messages", "expires_at", "DATETIME"); err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS messages_expires_at
+		ON messages (expires_at) WHERE expires_at IS NOT NULL`); err != nil {
+		return nil, fmt.Errorf("error creating messages expiration index: %w", err)
+	}
+
+	// Per-member flag set when a conversation is explicitly marked as unread.
+	if err := addColumnIfMissing(ctx, db, "conversation_members", "marked_unread", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return nil, err
+	}
+
+	// Per-member conversation settings: mute, archive and pin.
+	for _, col := range []struct{ name, definition string }{
+		{"muted_until", "DATETIME"},
+		{"archived", "INTEGER NOT NULL DEFAULT 0"},
+		{"pin_order", "INTEGER"},
+	} {
+		if err := addColumnIfMissing(ctx, db, "conversation_members", col.name, col.definition); err != nil {
+			return nil, err
+		}
+	}
+
+	// Last time a user was seen online, written when the user goes offline.
+	if err := addColumnIfMissing(ctx, db, "users", "last_seen", "DATETIME"); err != nil {
+		return nil, err
+	}
+
+	// Profile shown to the other users; the name column remains the unique handle used to log in.
+	for _, col := range []struct{ name, definition string }{
+		{"display_name", "TEXT NOT NULL DEFAULT ''"},
+		{"bio", "TEXT NOT NULL DEFAULT ''"},
+		{"status", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		if err := addColumnIfMissing(ctx, db, "users", col.name, col.definition); err != nil {
+			return nil, err
+		}
+	}
+
+	// Placeholder author of the messages and comments of deleted accounts. Its username is longer than the ones
+	// accepted by the API, so nobody can log in with it.
+	if _, err := db.ExecContext(ctx, `
+		INSERT INTO users (id, name, display_name)
+		VALUES (?, ?, ?)
+		ON CONFLICT DO NOTHING`, DeletedUserId, deletedUserName, deletedUserDisplayName); err != nil {
+		return nil, fmt.Errorf("error creating deleted user placeholder: %w", err)
+	}
+
+	// Create the user settings table if it doesn't already exist; users without a row use the defaults.
+	if _, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS user_settings (
+			user_id INTEGER PRIMARY KEY,
+			last_seen TEXT NOT NULL DEFAULT 'everyone',
+			FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+		)`); err != nil {
+		return nil, fmt.Errorf("error creating user_settings table: %w", err)
+	}
+	for _, column := range []string{"photo", "read_receipts", "group_add", "direct_chat", "forwards"} {
+		if err := addColumnIfMissing(ctx, db, "user_settings", column, "TEXT NOT NULL DEFAULT 'everyone'"); err != nil {
+			return nil, err
+		}
+	}
+
+	// Unread counters scan the messages of a conversation newer than the last access of the member.
+	if _, err := db.ExecContext(ctx, `
+		CREATE INDEX IF NOT EXISTS messages_conversation_timestamp
+		ON messages (conversation_id, timestamp)`); err != nil {
+		return nil, fmt.Errorf("error creating messages index: %w", err)
+	}
+
+	// One reaction per user per emoji: drop duplicates left by older versions before enforcing it.
+	if _, err := db.ExecContext(ctx, `
+		DELETE FROM comments
+		WHERE comment_id NOT IN (
+			SELECT MIN(comment_id) FROM comments GROUP BY message_id, sender_id, content
+		)`); err != nil {
+		return nil, fmt.Errorf("error removing duplicate reactions: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS comments_unique_reaction
+		ON comments (message_id, sender_id, content)`); err != nil {
+		return nil, fmt.Errorf("error creating comments unique index: %w", err)
+	}
+
+	// At most one active export job per user: older versions could start a second one, the older ones are failed.
+	if _, err := db.ExecContext(ctx, `
+		UPDATE export_jobs SET status = 'failed', error = 'superseded by a newer export'
+		WHERE status IN ('pending', 'running') AND job_id NOT IN (
+			SELECT MAX(job_id) FROM export_jobs WHERE status IN ('pending', 'running') GROUP BY user_id
+		)`); err != nil {
+		return nil, fmt.Errorf("error failing duplicate export jobs: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `
+		CREATE UNIQUE INDEX IF NOT EXISTS export_jobs_active
+		ON export_jobs (user_id) WHERE status IN ('pending', 'running')`); err != nil {
+		return nil, fmt.Errorf("error creating export jobs unique index: %w", err)
+	}
+
+	// Lookups by the columns that are not the first of a primary key or of another index: the conversations of a
+	// user, the messages by their replies, the conversations by their last message, the pins and stars of a deleted
+	// message, the scheduled messages of a member and the export jobs of a user. The comments of a message use
+	// comments_unique_reaction. The indexes on the author columns let DeleteUser, and the foreign key checks of the
+	// deletion of the account, find the rows of the user without reading the whole tables.
+	for _, index := range []struct{ name, definition string }{
+		{"conversation_members_user", "conversation_members (user_id)"},
+		{"messages_reply_to", "messages (reply_to_message_id) WHERE reply_to_message_id IS NOT NULL"},
+		{"conversations_last_message", "conversations (last_message_id)"},
+		{"pinned_messages_message", "pinned_messages (message_id)"},
+		{"starred_messages_message", "starred_messages (message_id)"},
+		{"scheduled_messages_sender", "scheduled_messages (conversation_id, sender_id, send_at)"},
+		{"export_jobs_user", "export_jobs (user_id)"},
+		{"messages_sender", "messages (sender_id)"},
+		{"messages_forwarded_from_sender", "messages (forwarded_from_sender_id) WHERE forwarded_from_sender_id IS NOT NULL"},
+		{"comments_sender", "comments (sender_id)"},
+		{"pinned_messages_pinned_by", "pinned_messages (pinned_by)"},
+		{"message_mentions_user", "message_mentions (user_id)"},
+		{"scheduled_messages_by_sender", "scheduled_messages (sender_id)"},
+		{"imported_participants_user", "imported_participants (user_id)"},
+	} {
+		if _, err := db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS `+index.name+` ON `+index.definition); err != nil {
+			return nil, fmt.Errorf("error creating index %s: %w", index.name, err)
+		}
+	}
+
+	// The foreign keys that the engine could not declare with the tables
+	if err := d.addForeignKeys(ctx, db, foreignKeys); err != nil {
+		return nil, fmt.Errorf("error creating foreign keys: %w", err)
+	}
+
+	// The schema is done: from now on the queries can go to the read pool
+	db.read = readDB
 	return &appdbimpl{
 		c: db,
+		q: db,
 	}, nil
 }
 
-func (db *appdbimpl) Ping() error {
-	return db.c.Ping()
+// foreignKey is a foreign key of the schema: column of table refers to refColumn of refTable. With cascade, the rows
+// are deleted together with the row they refer to.
+type foreignKey struct {
+	table, column       string
+	refTable, refColumn string
+	cascade             bool
+}
+
+// foreignKeys are the foreign keys declared by the CREATE TABLE and ADD COLUMN statements of open. SQLite creates
+// them with the tables, PostgreSQL adds them once all the tables exist (see postgresDialect): the list must be kept
+// in sync with the statements.
+var foreignKeys = []foreignKey{
+	{"conversations", "last_message_id", "messages", "message_id", false},
+	{"conversation_members", "conversation_id", "conversations", "conversation_id", true},
+	{"conversation_members", "user_id", "users", "id", true},
+	{"messages", "conversation_id", "conversations", "conversation_id", false},
+	{"messages", "sender_id", "users", "id", false},
+	{"messages", "reply_to_message_id", "messages", "message_id", false},
+	{"messages", "forwarded_from_sender_id", "users", "id", false},
+	{"messages", "forwarded_from_conversation_id", "conversations", "conversation_id", false},
+	{"comments", "message_id", "messages", "message_id", false},
+	{"comments", "sender_id", "users", "id", false},
+	{"pinned_messages", "conversation_id", "conversations", "conversation_id", true},
+	{"pinned_messages", "message_id", "messages", "message_id", true},
+	{"pinned_messages", "pinned_by", "users", "id", false},
+	{"starred_messages", "user_id", "users", "id", true},
+	{"starred_messages", "message_id", "messages", "message_id", true},
+	{"message_mentions", "message_id", "messages", "message_id", true},
+	{"message_mentions", "user_id", "users", "id", true},
+	{"drafts", "user_id", "users", "id", true},
+	{"drafts", "conversation_id", "conversations", "conversation_id", true},
+	{"scheduled_messages", "conversation_id", "conversations", "conversation_id", true},
+	{"scheduled_messages", "sender_id", "users", "id", true},
+	{"export_jobs", "user_id", "users", "id", true},
+	{"chat_imports", "conversation_id", "conversations", "conversation_id", true},
+	{"imported_messages", "conversation_id", "conversations", "conversation_id", true},
+	{"imported_participants", "conversation_id", "conversations", "conversation_id", true},
+	{"imported_participants", "user_id", "users", "id", true},
+	{"user_settings", "user_id", "users", "id", true},
+}
+
+// addColumnIfMissing adds a column to an existing table, so that databases created with an older schema are upgraded
+// when the service starts.
+func addColumnIfMissing(ctx context.Context, db *conn, table string, column string, definition string) error {
+	exists, err := db.dialect.columnExists(ctx, db, table, column)
+	if err != nil {
+		return fmt.Errorf("error checking column %s.%s: %w", table, column, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, `ALTER TABLE `+table+` ADD COLUMN `+column+` `+definition); err != nil {
+		return fmt.Errorf("error adding column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (db *appdbimpl) Ping(ctx context.Context) error {
+	return db.c.PingContext(ctx)
 }
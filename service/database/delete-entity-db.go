@@ -1,36 +1,50 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 )
 
 // DeleteCommentById deletes a comment by its ID.
-func (db *appdbimpl) DeleteCommentById(commentId int64) error {
+func (db *appdbimpl) DeleteCommentById(ctx context.Context, commentId int64) error {
 	query := `
 		DELETE FROM comments
 		WHERE comment_id = ?
 	`
-	_, err := db.c.Exec(query, commentId)
+	_, err := db.q.ExecContext(ctx, query, commentId)
 	if err != nil {
 		return fmt.Errorf("error deleting comment: %w", err)
 	}
 	return nil
 }
 
-// RemoveUserFromGroup removes a user from a group
-func (db *appdbimpl) RemoveUserFromGroup(conversationId int64, userId int64) error {
-	_, err := db.c.Exec("DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationId, userId)
-	return err
+// RemoveUserFromGroup removes a user from a group, together with the messages of the group they starred
+func (db *appdbimpl) RemoveUserFromGroup(ctx context.Context, conversationId int64, userId int64) error {
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM conversation_members WHERE conversation_id = ? AND user_id = ?", conversationId, userId); err != nil {
+			return fmt.Errorf("error removing user from group: %w", err)
+		}
+		if _, err := tx.q.ExecContext(ctx, `
+			DELETE FROM starred_messages
+			WHERE user_id = ? AND message_id IN (SELECT message_id FROM messages WHERE conversation_id = ?)`,
+			userId, conversationId); err != nil {
+			return fmt.Errorf("error removing starred messages: %w", err)
+		}
+		if _, err := tx.q.ExecContext(ctx, "DELETE FROM drafts WHERE conversation_id = ? AND user_id = ?", conversationId, userId); err != nil {
+			return fmt.Errorf("error removing draft: %w", err)
+		}
+		return nil
+	})
 }
 
 // Function to delete the comments associated with a message
-func (db *appdbimpl) deleteCommentsByMessageId(messageId int64) error {
+func (db *appdbimpl) deleteCommentsByMessageId(ctx context.Context, messageId int64) error {
 	query := `
 		DELETE FROM comments
 		WHERE message_id = ?`
-	_, err := db.c.Exec(query, messageId)
+	_, err := db.q.ExecContext(ctx, query, messageId)
 	if err != nil {
 		return fmt.Errorf("error deleting comments: %w", err)
 	}
@@ -38,67 +52,80 @@ func (db *appdbimpl) deleteCommentsByMessageId(messageId int64) error {
 }
 
 // Function to delete the message
-func (db *appdbimpl) deleteMessage(messageId int64) error {
+func (db *appdbimpl) deleteMessage(ctx context.Context, messageId int64) error {
+	// Remove the message from the pinned and starred messages, and its mentions, first
+	if _, err := db.q.ExecContext(ctx, `DELETE FROM pinned_messages WHERE message_id = ?`, messageId); err != nil {
+		return fmt.Errorf("error unpinning message: %w", err)
+	}
+	if _, err := db.q.ExecContext(ctx, `DELETE FROM starred_messages WHERE message_id = ?`, messageId); err != nil {
+		return fmt.Errorf("error unstarring message: %w", err)
+	}
+	if _, err := db.q.ExecContext(ctx, `DELETE FROM message_mentions WHERE message_id = ?`, messageId); err != nil {
+		return fmt.Errorf("error deleting mentions: %w", err)
+	}
+	// The replies to the message lose the reference to it
+	if _, err := db.q.ExecContext(ctx, `
+		UPDATE messages
+		SET reply_to_message_id = NULL
+		WHERE reply_to_message_id = ?`, messageId); err != nil {
+		return fmt.Errorf("error detaching replies: %w", err)
+	}
+
 	query := `
 		DELETE FROM messages
 		WHERE message_id = ?`
-	_, err := db.c.Exec(query, messageId)
+	_, err := db.q.ExecContext(ctx, query, messageId)
 	if err != nil {
 		return fmt.Errorf("error deleting message: %w", err)
 	}
 	return nil
 }
 
-// DeleteMessageById deletes a message by its ID
-func (db *appdbimpl) DeleteMessageById(messageId int64) error {
-	// Delete comments associated with the message first
-	if err := db.deleteCommentsByMessageId(messageId); err != nil {
-		return fmt.Errorf("error deleting comments: %w", err)
-	}
-
-	// Check if the deleted message is the last message in any conversation
-	var conversationId int64
-	err := db.c.QueryRow(`
-		SELECT conversation_id
-		FROM conversations
-		WHERE last_message_id = ?`, messageId).Scan(&conversationId)
+// DeleteMessageById deletes a message by its ID, with its comments, and moves the last message of its conversation
+// back to the previous one, in a single transaction.
+func (db *appdbimpl) DeleteMessageById(ctx context.Context, messageId int64) error {
+	return db.withTx(ctx, func(tx *appdbimpl) error {
+		// Delete comments associated with the message first
+		if err := tx.deleteCommentsByMessageId(ctx, messageId); err != nil {
+			return fmt.Errorf("error deleting comments: %w", err)
+		}
 
-	// If no conversation is found with this last_message_id, just delete the message
-	if errors.Is(err, sql.ErrNoRows) {
-		// Message is not the last message in any conversation
-		return db.deleteMessage(messageId)
-	} else if err != nil {
-		// Error querying for the conversation
-		return fmt.Errorf("error checking if message is last message: %w", err)
-	}
+		// Check if the deleted message is the last message in any conversation
+		var conversationId int64
+		err := tx.q.QueryRowContext(ctx, `
+			SELECT conversation_id
+			FROM conversations
+			WHERE last_message_id = ?`, messageId).Scan(&conversationId)
 
-	// The message is the last message, so we need to update the conversation's last_message_id
-	// Delete the message first
-	if err := db.deleteMessage(messageId); err != nil {
-		return err
-	}
+		// If no conversation is found with this last_message_id, just delete the message
+		if errors.Is(err, sql.ErrNoRows) {
+			// Message is not the last message in any conversation
+			return tx.deleteMessage(ctx, messageId)
+		} else if err != nil {
+			// Error querying for the conversation
+			return fmt.Errorf("error checking if message is last message: %w", err)
+		}
 
-	// Find the new last message by timestamp (most recent)
-	var newLastMessageId int64
-	err = db.c.QueryRow(`
-		SELECT message_id
-		FROM messages
-		WHERE conversation_id = ?
-		ORDER BY timestamp DESC
-		LIMIT 1`, conversationId).Scan(&newLastMessageId)
+		// The message is the last message, so we need to update the conversation's last_message_id first: the
+		// conversation must not reference a deleted message
+		// Find the new last message by timestamp (most recent)
+		var newLastMessageId int64
+		err = tx.q.QueryRowContext(ctx, `
+			SELECT message_id
+			FROM messages
+			WHERE conversation_id = ? AND message_id <> ?
+			ORDER BY timestamp DESC, message_id DESC
+			LIMIT 1`, conversationId, messageId).Scan(&newLastMessageId)
 
-	if err != nil {
-		// If no other messages exist set last_message_id to NULL
-		if errors.Is(err, sql.ErrNoRows) {
-			return db.UpdateLastMessageId(conversationId, 0) // Set to NULL or 0
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("error finding new last message: %w", err)
 		}
-		return fmt.Errorf("error finding new last message: %w", err)
-	}
 
-	// Update the conversation with the new last_message_id
-	if err := db.UpdateLastMessageId(conversationId, newLastMessageId); err != nil {
-		return fmt.Errorf("error updating last_message_id after deletion: %w", err)
-	}
+		// Update the conversation with the new last_message_id; if no other messages exist it is set to NULL
+		if err := tx.UpdateLastMessageId(ctx, conversationId, newLastMessageId); err != nil {
+			return fmt.Errorf("error updating last_message_id after deletion: %w", err)
+		}
 
-	return nil
+		return tx.deleteMessage(ctx, messageId)
+	})
 }
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+)
+
+// dialect hides the differences between the SQL engines supported by AppDatabase. Queries are written once, with the
+// SQLite syntax and `?` placeholders, and the dialect translates them before they reach the driver.
+type dialect interface {
+	// name is the name of the engine, used in error messages.
+	name() string
+	// translate rewrites a query for the engine.
+	translate(query string) string
+	// convert rewrites the arguments of a query for the engine.
+	convert(args []interface{}) []interface{}
+	// insert runs an INSERT statement and returns the value of the column idColumn of the new row.
+	insert(ctx context.Context, e execer, idColumn string, query string, args []interface{}) (int64, error)
+	// tableExists tells whether a table exists.
+	tableExists(ctx context.Context, c *conn, table string) (bool, error)
+	// columnExists tells whether a table has a column.
+	columnExists(ctx context.Context, c *conn, table string, column string) (bool, error)
+	// size returns the space used by the database and the part of it that is free, in bytes.
+	size(ctx context.Context, c *conn) (int64, int64, error)
+	// addForeignKeys creates the foreign keys of keys that the tables lack.
+	addForeignKeys(ctx context.Context, c *conn, keys []foreignKey) error
+}
+
+// execer is the subset of methods shared by *conn and *tx, used by the code that can run both inside and outside a
+// transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	Insert(ctx context.Context, idColumn string, query string, args ...interface{}) (int64, error)
+}
+
+// conn is a connection pool that translates the queries with its dialect. The queries run on the read only pool
+// read, when there is one, and everything else on db.
+type conn struct {
+	db      *sql.DB
+	read    *sql.DB
+	dialect dialect
+}
+
+// reader returns the pool running the queries.
+func (c *conn) reader() *sql.DB {
+	if c.read != nil {
+		return c.read
+	}
+	return c.db
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return c.db.ExecContext(ctx, c.dialect.translate(query), c.dialect.convert(args)...)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return c.reader().QueryContext(ctx, c.dialect.translate(query), c.dialect.convert(args)...)
+}
+
+func (c *conn) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return c.reader().QueryRowContext(ctx, c.dialect.translate(query), c.dialect.convert(args)...)
+}
+
+// Insert runs an INSERT statement and returns the value of the column idColumn of the new row.
+func (c *conn) Insert(ctx context.Context, idColumn string, query string, args ...interface{}) (int64, error) {
+	return c.dialect.insert(ctx, c, idColumn, query, args)
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tx, error) {
+	t, err := c.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &tx{tx: t, dialect: c.dialect}, nil
+}
+
+func (c *conn) PingContext(ctx context.Context) error {
+	if err := c.db.PingContext(ctx); err != nil {
+		return err
+	}
+	return c.reader().PingContext(ctx)
+}
+
+// tx is a transaction that translates the queries with its dialect.
+type tx struct {
+	tx      *sql.Tx
+	dialect dialect
+}
+
+func (t *tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return t.tx.ExecContext(ctx, t.dialect.translate(query), t.dialect.convert(args)...)
+}
+
+func (t *tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return t.tx.QueryContext(ctx, t.dialect.translate(query), t.dialect.convert(args)...)
+}
+
+func (t *tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return t.tx.QueryRowContext(ctx, t.dialect.translate(query), t.dialect.convert(args)...)
+}
+
+// Insert runs an INSERT statement and returns the value of the column idColumn of the new row.
+func (t *tx) Insert(ctx context.Context, idColumn string, query string, args ...interface{}) (int64, error) {
+	return t.dialect.insert(ctx, t, idColumn, query, args)
+}
+
+func (t *tx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// nullsOrder returns the NULLS clause that sorts the NULL values like SQLite does by default, as the smallest values,
+// for an ORDER BY in the direction `sortOrder`. PostgreSQL sorts them as the largest ones.
+func nullsOrder(sortOrder string) string {
+	if strings.EqualFold(sortOrder, "desc") {
+		return " NULLS LAST"
+	}
+	return " NULLS FIRST"
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Funzioni per i messaggi effimeri.
+// Ogni conversazione può avere un timer (message_ttl): i messaggi inviati mentre è attivo ricevono una scadenza
+// (expires_at), vengono nascosti da tutte le letture una volta scaduti e poi cancellati da DeleteExpiredMessages.
+
+// messageExpiration returns the expiration of a message sent at `timestamp` in a conversation, according to the
+// timer of the conversation. The result is NULL when the messages of the conversation do not disappear. It is in UTC,
+// like the times it is compared with, so that the comparison works on the text too.
+func messageExpiration(ctx context.Context, e execer, conversationId int64, timestamp time.Time) (sql.NullTime, error) {
+	var ttl int64
+	err := e.QueryRowContext(ctx, `SELECT message_ttl FROM conversations WHERE conversation_id = ?`, conversationId).Scan(&ttl)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("error retrieving message timer: %w", err)
+	}
+	if ttl <= 0 {
+		return sql.NullTime{}, nil
+	}
+	return sql.NullTime{Time: timestamp.Add(time.Duration(ttl) * time.Second).UTC(), Valid: true}, nil
+}
+
+// nullTimePtr converts a nullable time to a pointer, nil when the time is NULL.
+func nullTimePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+// SetMessageTTL sets the lifetime of the messages sent in a conversation from now on. A zero `ttl` disables
+// disappearing messages; messages already sent keep their expiration.
+func (db *appdbimpl) SetMessageTTL(ctx context.Context, conversationId int64, ttl time.Duration) error {
+	_, err := db.q.ExecContext(ctx, `
+		UPDATE conversations
+		SET message_ttl = ?
+		WHERE conversation_id = ?`, int64(ttl/time.Second), conversationId)
+	if err != nil {
+		return fmt.Errorf("error updating message timer: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredMessages physically deletes the messages expired at `now`, together with their comments, media, pins
+// and stars, and returns how many messages were deleted.
+func (db *appdbimpl) DeleteExpiredMessages(ctx context.Context, now time.Time) (int, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT message_id
+		FROM messages
+		WHERE expires_at IS NOT NULL AND expires_at <= ?
+		ORDER BY timestamp ASC, message_id ASC`, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving expired messages: %w", err)
+	}
+	var messageIds []int64
+	for rows.Next() {
+		var messageId int64
+		if err := rows.Scan(&messageId); err != nil {
+			_ = rows.Close()
+			return 0, fmt.Errorf("error scanning expired message: %w", err)
+		}
+		messageIds = append(messageIds, messageId)
+	}
+	if err := rows.Err(); err != nil {
+		_ = rows.Close()
+		return 0, fmt.Errorf("rows iteration error: %w", err)
+	}
+	_ = rows.Close()
+
+	// Oldest first: when the last message of a conversation is deleted, the older expired messages are already gone,
+	// so the conversation falls back to a message that stays and not to one about to be deleted as well
+	for i, messageId := range messageIds {
+		if err := db.DeleteMessageById(ctx, messageId); err != nil {
+			return i, fmt.Errorf("error deleting expired message %d: %w", messageId, err)
+		}
+	}
+	return len(messageIds), nil
+}
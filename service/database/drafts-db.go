@@ -0,0 +1,52 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// Funzioni per le bozze dei messaggi, una per utente e conversazione.
+// Le bozze vengono cancellate dagli handler quando l'utente invia un messaggio nella conversazione.
+
+// SaveDraft creates or replaces the draft of a user in a conversation.
+func (db *appdbimpl) SaveDraft(ctx context.Context, userId int64, conversationId int64, text string) (Draft, error) {
+	draft := Draft{Text: text, UpdatedAt: globaltime.Now()}
+	_, err := db.q.ExecContext(ctx, `
+		INSERT INTO drafts (user_id, conversation_id, text, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, conversation_id) DO UPDATE SET text = excluded.text, updated_at = excluded.updated_at`,
+		userId, conversationId, draft.Text, draft.UpdatedAt)
+	if err != nil {
+		return Draft{}, fmt.Errorf("error saving draft: %w", err)
+	}
+	return draft, nil
+}
+
+// GetDraft retrieves the draft of a user in a conversation. It returns an error wrapping sql.ErrNoRows if there is
+// no draft.
+func (db *appdbimpl) GetDraft(ctx context.Context, userId int64, conversationId int64) (Draft, error) {
+	var draft Draft
+	err := db.q.QueryRowContext(ctx, `
+		SELECT text, updated_at
+		FROM drafts
+		WHERE user_id = ? AND conversation_id = ?`, userId, conversationId).Scan(&draft.Text, &draft.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Draft{}, fmt.Errorf("draft not found: %w", err)
+		}
+		return Draft{}, fmt.Errorf("error retrieving draft: %w", err)
+	}
+	return draft, nil
+}
+
+// DeleteDraft removes the draft of a user in a conversation; it is not an error if there is none.
+func (db *appdbimpl) DeleteDraft(ctx context.Context, userId int64, conversationId int64) error {
+	if _, err := db.q.ExecContext(ctx, `DELETE FROM drafts WHERE user_id = ? AND conversation_id = ?`, userId, conversationId); err != nil {
+		return fmt.Errorf("error deleting draft: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package database_test
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// statementHook sees the statements sent to a database opened through hookedConnector, before they reach the driver,
+// and can fail them. The commits are seen as the statement "COMMIT". args is nil for the prepared statements.
+type statementHook interface {
+	statement(query string, args []driver.NamedValue) error
+}
+
+type hookedConnector struct {
+	hook   statementHook
+	driver driver.Driver
+	dsn    string
+}
+
+func (c hookedConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedConn{hook: c.hook, conn: conn}, nil
+}
+
+func (c hookedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// hookedConn passes every statement to its hook before the connection of the driver. The methods that the driver
+// connection lacks return driver.ErrSkip, so that database/sql falls back to the basic ones.
+type hookedConn struct {
+	hook statementHook
+	conn driver.Conn
+}
+
+func (c *hookedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *hookedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.hook.statement(query, nil); err != nil {
+		return nil, err
+	}
+	if prepare, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return prepare.PrepareContext(ctx, query)
+	}
+	return c.conn.Prepare(query)
+}
+
+func (c *hookedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	exec, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.hook.statement(query, args); err != nil {
+		return nil, err
+	}
+	return exec.ExecContext(ctx, query, args)
+}
+
+func (c *hookedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if err := c.hook.statement(query, args); err != nil {
+		return nil, err
+	}
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *hookedConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
+
+func (c *hookedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *hookedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if begin, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = begin.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.conn.Begin() //nolint:staticcheck
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hookedTx{hook: c.hook, tx: tx}, nil
+}
+
+func (c *hookedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *hookedConn) Close() error {
+	return c.conn.Close()
+}
+
+// hookedTx passes the commit to the hook as the statement "COMMIT": if the hook fails it, the transaction is rolled
+// back instead.
+type hookedTx struct {
+	hook statementHook
+	tx   driver.Tx
+}
+
+func (t hookedTx) Commit() error {
+	if err := t.hook.statement("COMMIT", nil); err != nil {
+		_ = t.tx.Rollback()
+		return err
+	}
+	return t.tx.Commit()
+}
+
+func (t hookedTx) Rollback() error {
+	return t.tx.Rollback()
+}
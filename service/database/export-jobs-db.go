@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// Funzioni per i job di esportazione dei dati personali.
+// L'archivio viene prodotto dal package api; qui si tiene traccia dello stato dei job e del file prodotto.
+
+// CreateExportJob creates a pending export job for a user, unless the user already has one pending or running: that
+// job is returned instead, with created false. The check and the insertion are one transaction, and the unique index
+// export_jobs_active keeps two concurrent requests from both inserting a job.
+func (db *appdbimpl) CreateExportJob(ctx context.Context, userId int64) (ExportJob, bool, error) {
+	var job ExportJob
+	var created bool
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		var err error
+		job, created, err = tx.createExportJob(ctx, userId)
+		return err
+	})
+	if err != nil && db.tx == nil {
+		// The other request committed its job first: that is the one to return
+		if active, found, activeErr := db.activeExportJob(ctx, userId); activeErr == nil && found {
+			return active, false, nil
+		}
+	}
+	if err != nil {
+		return ExportJob{}, false, err
+	}
+	return job, created, nil
+}
+
+// createExportJob inserts a pending export job for a user, unless an active one exists, within a transaction.
+func (db *appdbimpl) createExportJob(ctx context.Context, userId int64) (ExportJob, bool, error) {
+	active, found, err := db.activeExportJob(ctx, userId)
+	if err != nil || found {
+		return active, false, err
+	}
+	job := ExportJob{UserId: userId, Status: ExportPending, CreatedAt: globaltime.Now()}
+	job.JobId, err = db.q.Insert(ctx, "job_id", `
+		INSERT INTO export_jobs (user_id, status, created_at)
+		VALUES (?, ?, ?)`, job.UserId, job.Status, job.CreatedAt)
+	if err != nil {
+		return ExportJob{}, false, fmt.Errorf("error inserting export job: %w", err)
+	}
+	return job, true, nil
+}
+
+// activeExportJob retrieves the export job of a user that is pending or running, if any.
+func (db *appdbimpl) activeExportJob(ctx context.Context, userId int64) (ExportJob, bool, error) {
+	jobs, err := db.queryExportJobs(ctx, `WHERE user_id = ? AND status IN (?, ?)`, userId, ExportPending, ExportRunning)
+	if err != nil || len(jobs) == 0 {
+		return ExportJob{}, false, err
+	}
+	return jobs[0], true, nil
+}
+
+// GetExportJob retrieves an export job.
+func (db *appdbimpl) GetExportJob(ctx context.Context, jobId int64) (ExportJob, error) {
+	jobs, err := db.queryExportJobs(ctx, `WHERE job_id = ?`, jobId)
+	if err != nil {
+		return ExportJob{}, err
+	}
+	if len(jobs) == 0 {
+		return ExportJob{}, fmt.Errorf("export job not found: %w", sql.ErrNoRows)
+	}
+	return jobs[0], nil
+}
+
+// GetExportJobs retrieves the export jobs of a user, newest first.
+func (db *appdbimpl) GetExportJobs(ctx context.Context, userId int64) ([]ExportJob, error) {
+	return db.queryExportJobs(ctx, `WHERE user_id = ? ORDER BY job_id DESC`, userId)
+}
+
+// GetExpiredExportJobs retrieves the completed export jobs whose archive expired before `now`.
+func (db *appdbimpl) GetExpiredExportJobs(ctx context.Context, now time.Time) ([]ExportJob, error) {
+	return db.queryExportJobs(ctx, `WHERE status = ? AND expires_at <= ?`, ExportDone, now)
+}
+
+// queryExportJobs retrieves the export jobs matching a WHERE clause.
+func (db *appdbimpl) queryExportJobs(ctx context.Context, where string, args ...interface{}) ([]ExportJob, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT job_id, user_id, status, created_at, completed_at, expires_at, file_path, error
+		FROM export_jobs `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving export jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []ExportJob
+	for rows.Next() {
+		var job ExportJob
+		var completedAt, expiresAt sql.NullTime
+		if err := rows.Scan(&job.JobId, &job.UserId, &job.Status, &job.CreatedAt, &completedAt, &expiresAt,
+			&job.FilePath, &job.Error); err != nil {
+			return nil, fmt.Errorf("error scanning export job: %w", err)
+		}
+		job.CompletedAt = nullTimePtr(completedAt)
+		job.ExpiresAt = nullTimePtr(expiresAt)
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return jobs, nil
+}
+
+// UpdateExportJob saves the status, the times, the archive and the error of an export job.
+func (db *appdbimpl) UpdateExportJob(ctx context.Context, job ExportJob) error {
+	result, err := db.q.ExecContext(ctx, `
+		UPDATE export_jobs
+		SET status = ?, completed_at = ?, expires_at = ?, file_path = ?, error = ?
+		WHERE job_id = ?`, job.Status, job.CompletedAt, job.ExpiresAt, job.FilePath, job.Error, job.JobId)
+	if err != nil {
+		return fmt.Errorf("error updating export job: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	if rowsAffected == 0 {
+		return errors.New("export job not found")
+	}
+	return nil
+}
+
+// FailUnfinishedExportJobs marks as failed the jobs left pending or running by a previous run of the service, and
+// returns how many they were.
+func (db *appdbimpl) FailUnfinishedExportJobs(ctx context.Context) (int, error) {
+	result, err := db.q.ExecContext(ctx, `
+		UPDATE export_jobs
+		SET status = ?, error = 'interrupted by a restart of the service'
+		WHERE status IN (?, ?)`, ExportFailed, ExportPending, ExportRunning)
+	if err != nil {
+		return 0, fmt.Errorf("error failing unfinished export jobs: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return int(rowsAffected), nil
+}
@@ -0,0 +1,64 @@
+package database_test
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// errInjected is the error of the statements made to fail by a faultInjector.
+var errInjected = errors.New("injected fault")
+
+// faultInjector makes the statements sent to a database fail on request, to simulate a crash between the steps of a write.
+// It sits between database/sql and the driver of the engine: open the database with
+//
+//	sql.OpenDB(faults.Connector(driver, dsn))
+//
+// where driver is the one of the engine, for example the Driver() of a *sql.DB opened as usual (see openThrough).
+// Until a fault is armed with Fail, the statements reach the driver unchanged.
+type faultInjector struct {
+	mu      sync.Mutex
+	pattern string
+	skip    int
+	armed   bool
+	fired   bool
+}
+
+// Fail arms a fault: the statement containing pattern that comes after `skip` other ones containing it fails with
+// errInjected. The pattern "COMMIT" matches the commit of the transactions, which are rolled back instead. The fault
+// is disarmed once it fired, or by Reset.
+func (f *faultInjector) Fail(pattern string, skip int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pattern, f.skip, f.armed, f.fired = pattern, skip, true, false
+}
+
+// Reset disarms the fault, and tells whether it fired.
+func (f *faultInjector) Reset() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fired := f.fired
+	f.pattern, f.skip, f.armed, f.fired = "", 0, false, false
+	return fired
+}
+
+// statement returns errInjected if the statement must fail.
+func (f *faultInjector) statement(statement string, _ []driver.NamedValue) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.armed || !strings.Contains(statement, f.pattern) {
+		return nil
+	}
+	if f.skip > 0 {
+		f.skip--
+		return nil
+	}
+	f.armed, f.fired = false, true
+	return errInjected
+}
+
+// Connector returns a connector that opens the connections with d and dsn, failing the statements chosen by f.
+func (f *faultInjector) Connector(d driver.Driver, dsn string) driver.Connector {
+	return hookedConnector{hook: f, driver: d, dsn: dsn}
+}
@@ -1,15 +1,20 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
 )
 
 // Funzioni per la gestione delle conversazioni.
 // GetConversationsByUser recupera tutte le conversazioni di un utente.
 // Si collega agli handler getConversations e addConversation.
-func (db *appdbimpl) GetConversationsByUser(userId int64, sortOrder string) ([]Conversation, error) {
+// Le conversazioni fissate vengono prima delle altre; quelle archiviate sono escluse se includeArchived è false.
+func (db *appdbimpl) GetConversationsByUser(ctx context.Context, userId int64, sortOrder string, includeArchived bool) ([]Conversation, error) {
 	query := `
 		SELECT 
 			c.conversation_id, 
@@ -18,33 +23,84 @@ func (db *appdbimpl) GetConversationsByUser(userId int64, sortOrder string) ([]C
 			COALESCE(m.message_id, 0) AS message_id,
     		COALESCE(m.timestamp, NULL) AS timestamp,
     		COALESCE(m.text, '') AS content,
-			c.type
+			c.type,
+			(
+				SELECT COUNT(*)
+				FROM message_mentions mm
+				INNER JOIN messages mx ON mx.message_id = mm.message_id
+				WHERE mm.user_id = cm.user_id
+					AND mx.conversation_id = c.conversation_id
+					AND mx.sender_id != cm.user_id
+					AND (cm.last_access IS NULL OR mx.timestamp > cm.last_access)
+					AND (mx.expires_at IS NULL OR mx.expires_at > ?)
+			) AS unread_mentions,
+			(
+				SELECT COUNT(*)
+				FROM messages mu
+				WHERE mu.conversation_id = c.conversation_id
+					AND mu.sender_id != cm.user_id
+					AND (cm.last_access IS NULL OR mu.timestamp > cm.last_access)
+					AND (mu.expires_at IS NULL OR mu.expires_at > ?)
+			) AS unread_count,
+			(
+				SELECT mu.message_id
+				FROM messages mu
+				WHERE mu.conversation_id = c.conversation_id
+					AND mu.sender_id != cm.user_id
+					AND (cm.last_access IS NULL OR mu.timestamp > cm.last_access)
+					AND (mu.expires_at IS NULL OR mu.expires_at > ?)
+				ORDER BY mu.timestamp ASC
+				LIMIT 1
+			) AS first_unread_message_id,
+			cm.marked_unread,
+			cm.muted_until,
+			cm.archived,
+			cm.pin_order,
+			c.message_ttl,
+			d.text,
+			d.updated_at
 		FROM 
 			conversations c
 		INNER JOIN 
 			conversation_members cm 
 		ON 
 			c.conversation_id = cm.conversation_id
+		LEFT JOIN
+			drafts d
+		ON
+			d.user_id = cm.user_id
+			AND d.conversation_id = c.conversation_id
 		LEFT JOIN 
 			messages m 
 		ON 
 			c.last_message_id = m.message_id
 			AND c.conversation_id = m.conversation_id
+			AND (m.expires_at IS NULL OR m.expires_at > ?)
 		WHERE 
 			cm.user_id = ?
+			AND (? = 1 OR cm.archived = 0)
 		ORDER BY 
-			m.timestamp ` + sortOrder
-	rows, err := db.c.Query(query, userId)
+			cm.pin_order IS NULL,
+			cm.pin_order ASC,
+			m.timestamp ` + sortOrder + nullsOrder(sortOrder)
+	now := globaltime.Now().UTC()
+	rows, err := db.q.QueryContext(ctx, query, now, now, now, now, userId, includeArchived)
 	if err != nil {
 		return nil, fmt.Errorf("errore recupero conversazioni: %w", err)
 	}
 	defer rows.Close()
+	photos := db.newPhotoPrivacy(ctx, userId)
 	var conversations []Conversation
 	for rows.Next() {
 		var conversation Conversation
 		var lastMessage LastMessage
 		var timestampStr *string
 		var photo []byte
+		var firstUnreadMessageId sql.NullInt64
+		var mutedUntil sql.NullTime
+		var pinOrder sql.NullInt64
+		var draftText sql.NullString
+		var draftUpdatedAt sql.NullTime
 		err := rows.Scan(
 			&conversation.ConversationId,
 			&conversation.Name,
@@ -53,6 +109,16 @@ func (db *appdbimpl) GetConversationsByUser(userId int64, sortOrder string) ([]C
 			&timestampStr,
 			&lastMessage.Preview,
 			&conversation.Type,
+			&conversation.UnreadMentions,
+			&conversation.UnreadCount,
+			&firstUnreadMessageId,
+			&conversation.MarkedUnread,
+			&mutedUntil,
+			&conversation.Archived,
+			&pinOrder,
+			&conversation.MessageTTL,
+			&draftText,
+			&draftUpdatedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("errore scan conversazione: %w", err)
@@ -73,27 +139,44 @@ func (db *appdbimpl) GetConversationsByUser(userId int64, sortOrder string) ([]C
 		} else {
 			conversation.LastMessage = &lastMessage
 		}
-		// Personalizzazione per conversazioni dirette: mostra nome/foto dell'altro utente
-		if conversation.Type == "direct" {
-			otherUser, err := db.GetOtherUserInConversation(conversation.ConversationId, userId)
-			if err != nil {
-				return nil, fmt.Errorf("errore recupero altro utente nella conversazione diretta: %w", err)
-			}
-			conversation.Name = otherUser.Name
-			if otherUser.Photo != nil && len(otherUser.Photo) > 0 {
-				conversation.Photo = otherUser.Photo
-			}
+		if firstUnreadMessageId.Valid {
+			conversation.FirstUnreadMessageId = &firstUnreadMessageId.Int64
+		}
+		conversation.MemberSettings = newMemberSettings(mutedUntil, conversation.Archived, pinOrder)
+		if draftText.Valid {
+			conversation.Draft = &Draft{Text: draftText.String, UpdatedAt: draftUpdatedAt.Time}
 		}
 		conversations = append(conversations, conversation)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("errore iterazione conversazioni: %w", err)
 	}
+
+	// Personalizzazione per conversazioni dirette: mostra nome/foto dell'altro utente. Le query vengono fatte dopo
+	// aver letto tutte le righe, quando la connessione della query principale è già libera: con un pool di una sola
+	// connessione resterebbero in attesa di sé stesse
+	for i := range conversations {
+		conversation := &conversations[i]
+		if conversation.Type != "direct" {
+			continue
+		}
+		otherUser, err := db.GetOtherUserInConversation(ctx, conversation.ConversationId, userId)
+		if err != nil {
+			return nil, fmt.Errorf("errore recupero altro utente nella conversazione diretta: %w", err)
+		}
+		if err := photos.apply(otherUser); err != nil {
+			return nil, fmt.Errorf("errore privacy foto: %w", err)
+		}
+		conversation.Name = otherUser.ShownName()
+		if otherUser.Photo != nil && len(otherUser.Photo) > 0 {
+			conversation.Photo = otherUser.Photo
+		}
+	}
 	return conversations, nil
 }
 
 // GetConversationById retrieves the conversation details by its ID
-func (db *appdbimpl) GetConversationById(conversationId int64) (Conversation, error) {
+func (db *appdbimpl) GetConversationById(ctx context.Context, conversationId int64) (Conversation, error) {
 	// Query to retrieve the conversation details
 	query := `
 		SELECT 
@@ -103,13 +186,15 @@ func (db *appdbimpl) GetConversationById(conversationId int64) (Conversation, er
 			COALESCE(m.message_id, 0) AS message_id, 
 			COALESCE(m.timestamp, NULL) AS timestamp, 
 			COALESCE(m.text, '') AS content,
-			c.type
+			c.type,
+			c.message_ttl
 		FROM 
 			conversations c
 		LEFT JOIN 
 			messages m 
 		ON 
 			c.last_message_id = m.message_id
+			AND (m.expires_at IS NULL OR m.expires_at > ?)
 		WHERE 
 			c.conversation_id = ?`
 
@@ -119,7 +204,7 @@ func (db *appdbimpl) GetConversationById(conversationId int64) (Conversation, er
 	var photo sql.NullByte
 
 	// Execute the query
-	row := db.c.QueryRow(query, conversationId)
+	row := db.q.QueryRowContext(ctx, query, globaltime.Now().UTC(), conversationId)
 
 	// Scan the result into the Conversation structure
 	err := row.Scan(
@@ -130,6 +215,7 @@ func (db *appdbimpl) GetConversationById(conversationId int64) (Conversation, er
 		&timestampStr,
 		&lastMessage.Preview,
 		&conversation.Type,
+		&conversation.MessageTTL,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -162,22 +248,23 @@ func (db *appdbimpl) GetConversationById(conversationId int64) (Conversation, er
 }
 
 // GetOtherUserInConversation retrieves the other user in a direct conversation
-func (db *appdbimpl) GetOtherUserInConversation(conversationId, userId int64) (*User, error) {
+func (db *appdbimpl) GetOtherUserInConversation(ctx context.Context, conversationId, userId int64) (*User, error) {
 	var otherUser User
 	var photo []byte
 
 	// Query to find the other user in the conversation
 	query := `
-		SELECT u.id, u.name, u.photo
+		SELECT u.id, u.name, u.photo, u.display_name
 		FROM conversation_members cm
 		INNER JOIN users u ON cm.user_id = u.id
 		WHERE cm.conversation_id = ? AND cm.user_id != ?
 	`
 
-	err := db.c.QueryRow(query, conversationId, userId).Scan(
+	err := db.q.QueryRowContext(ctx, query, conversationId, userId).Scan(
 		&otherUser.UserId,
 		&otherUser.Name,
 		&photo,
+		&otherUser.DisplayName,
 	)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, fmt.Errorf("no other user found in conversation %d for user %d", conversationId, userId)
@@ -196,8 +283,8 @@ func (db *appdbimpl) GetOtherUserInConversation(conversationId, userId int64) (*
 	return &otherUser, nil
 }
 
-func (db *appdbimpl) GetGroupById(conversationId int64) (*Conversation, error) {
-	row := db.c.QueryRow(
+func (db *appdbimpl) GetGroupById(ctx context.Context, conversationId int64) (*Conversation, error) {
+	row := db.q.QueryRowContext(ctx,
 		`SELECT conversation_id, name, photo FROM conversations WHERE conversation_id = ?`,
 		conversationId,
 	)
@@ -212,7 +299,7 @@ func (db *appdbimpl) GetGroupById(conversationId int64) (*Conversation, error) {
 }
 
 // GetCommentById retrieves a comment by its ID.
-func (db *appdbimpl) GetCommentById(commentId int64) (Comment, error) {
+func (db *appdbimpl) GetCommentById(ctx context.Context, commentId int64) (Comment, error) {
 	var comment Comment
 	var senderId int64
 
@@ -221,13 +308,13 @@ func (db *appdbimpl) GetCommentById(commentId int64) (Comment, error) {
 		FROM comments
 		WHERE comment_id = ?
 	`
-	err := db.c.QueryRow(query, commentId).Scan(&comment.CommentId, &senderId, &comment.Content)
+	err := db.q.QueryRowContext(ctx, query, commentId).Scan(&comment.CommentId, &senderId, &comment.Content)
 	if err != nil {
 		return Comment{}, fmt.Errorf("error retrieving comment: %w", err)
 	}
 
 	// Fetch user details from the database
-	sender, err := db.GetUserById(senderId)
+	sender, err := db.GetUserById(ctx, senderId)
 	if err != nil {
 		return Comment{}, fmt.Errorf("error fetching sender details: %w", err)
 	}
@@ -235,9 +322,9 @@ func (db *appdbimpl) GetCommentById(commentId int64) (Comment, error) {
 	return comment, nil
 }
 
-func (db *appdbimpl) GetGroupMembers(groupId int64) ([]string, error) {
+func (db *appdbimpl) GetGroupMembers(ctx context.Context, groupId int64) ([]string, error) {
 	// Query to get group members
-	rows, err := db.c.Query(`
+	rows, err := db.q.QueryContext(ctx, `
 		SELECT u.name
 		FROM users u
 		INNER JOIN conversation_members cm ON u.id = cm.user_id
@@ -266,23 +353,23 @@ func (db *appdbimpl) GetGroupMembers(groupId int64) ([]string, error) {
 }
 
 // IsUserMemberOfGroup checks if a user is a member of a group
-func (db *appdbimpl) IsUserMemberOfGroup(userId int64, conversationId int64) (bool, error) {
+func (db *appdbimpl) IsUserMemberOfGroup(ctx context.Context, userId int64, conversationId int64) (bool, error) {
 	var exists bool
 	query := "SELECT EXISTS (SELECT 1 FROM conversation_members WHERE conversation_id = ? AND user_id = ?)"
-	err := db.c.QueryRow(query, conversationId, userId).Scan(&exists)
+	err := db.q.QueryRowContext(ctx, query, conversationId, userId).Scan(&exists)
 	return exists, err
 }
 
 // GetUserById retrieves a user from the database by their ID
-func (db *appdbimpl) GetUserById(userId int64) (User, error) {
+func (db *appdbimpl) GetUserById(ctx context.Context, userId int64) (User, error) {
 	var user User
 	var photo []byte
 
 	// Query to retrieve the user details
-	err := db.c.QueryRow(`
-		SELECT id, name, photo
+	err := db.q.QueryRowContext(ctx, `
+		SELECT id, name, photo, display_name
 		FROM users
-		WHERE id = ?`, userId).Scan(&user.UserId, &user.Name, &photo)
+		WHERE id = ?`, userId).Scan(&user.UserId, &user.Name, &photo, &user.DisplayName)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return User{}, fmt.Errorf("user not found: %w", err)
@@ -301,9 +388,9 @@ func (db *appdbimpl) GetUserById(userId int64) (User, error) {
 }
 
 // IsUserInConversation checks if a user is part of a conversation.
-func (db *appdbimpl) IsUserInConversation(userId int64, conversationId int64) (bool, error) {
+func (db *appdbimpl) IsUserInConversation(ctx context.Context, userId int64, conversationId int64) (bool, error) {
 	var exists bool
-	err := db.c.QueryRow(`
+	err := db.q.QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT 1 
 			FROM conversation_members 
@@ -316,20 +403,22 @@ func (db *appdbimpl) IsUserInConversation(userId int64, conversationId int64) (b
 }
 
 // SearchUsersByUsername searches for users whose username contains the search string
-func (db *appdbimpl) SearchUsersByUsername(username string) ([]User, error) {
+func (db *appdbimpl) SearchUsersByUsername(ctx context.Context, username string) ([]User, error) {
 	var query string
 	var args []interface{}
 
 	if username == "" {
 		// No username provided, return all users
-		query = `SELECT id, name, photo FROM users`
+		query = `SELECT id, name, photo, display_name FROM users WHERE id != ?`
+		args = append(args, DeletedUserId)
 	} else {
-		// Search for users whose name contains the search string
-		query = `SELECT id, name, photo FROM users WHERE name LIKE ? LIMIT 10`
-		args = append(args, "%"+username+"%")
+		// Search for users whose name contains the search string, ignoring the case like SQLite does with LIKE
+		query = `SELECT id, name, photo, display_name FROM users
+			WHERE (lower(name) LIKE lower(?) OR lower(display_name) LIKE lower(?)) AND id != ? LIMIT 10`
+		args = append(args, "%"+username+"%", "%"+username+"%", DeletedUserId)
 	}
 
-	rows, err := db.c.Query(query, args...)
+	rows, err := db.q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error searching users by username: %w", err)
 	}
@@ -340,7 +429,7 @@ func (db *appdbimpl) SearchUsersByUsername(username string) ([]User, error) {
 		var user User
 		var photo []byte
 
-		if err := rows.Scan(&user.UserId, &user.Name, &photo); err != nil {
+		if err := rows.Scan(&user.UserId, &user.Name, &photo, &user.DisplayName); err != nil {
 			return nil, fmt.Errorf("error scanning user row: %w", err)
 		}
 
@@ -362,12 +451,12 @@ func (db *appdbimpl) SearchUsersByUsername(username string) ([]User, error) {
 }
 
 // GetUserByName search a user by name
-func (db *appdbimpl) GetUserByName(name string) (*User, error) {
+func (db *appdbimpl) GetUserByName(ctx context.Context, name string) (*User, error) {
 	var user User
 	var photo []byte
 
 	// Query to retrieve the user details
-	err := db.c.QueryRow("SELECT id, name, photo FROM users WHERE name = ?", name).Scan(&user.UserId, &user.Name, &photo)
+	err := db.q.QueryRowContext(ctx, "SELECT id, name, photo FROM users WHERE name = ? AND id != ?", name, DeletedUserId).Scan(&user.UserId, &user.Name, &photo)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil // User not found
 	}
@@ -386,16 +475,21 @@ func (db *appdbimpl) GetUserByName(name string) (*User, error) {
 }
 
 // GetMessageById retrieves a message by its ID.
-func (db *appdbimpl) GetMessageById(messageId int64, conversationId int64) (Message, error) {
+func (db *appdbimpl) GetMessageById(ctx context.Context, messageId int64, conversationId int64) (Message, error) {
 	var msg Message
 	var senderId int64
 	var replyToMessageId sql.NullInt64
 	var photo []byte
-	err := db.c.QueryRow(`
-		SELECT message_id, timestamp, text, sender_id, status, type, reply_to_message_id, photo
+	var fwdSenderId, fwdConversationId sql.NullInt64
+	var fwdTimestamp, expiresAt sql.NullTime
+	err := db.q.QueryRowContext(ctx, `
+		SELECT message_id, timestamp, text, sender_id, status, type, reply_to_message_id, photo, conversation_id,
+			forwarded_from_sender_id, forwarded_from_conversation_id, forwarded_from_timestamp, expires_at
 		FROM messages
-		WHERE message_id = ? AND conversation_id = ?`, messageId, conversationId).Scan(
-		&msg.MessageId, &msg.Timestamp, &msg.Text, &senderId, &msg.Status, &msg.Type, &replyToMessageId, &photo)
+		WHERE message_id = ? AND conversation_id = ? AND (expires_at IS NULL OR expires_at > ?)`,
+		messageId, conversationId, globaltime.Now().UTC()).Scan(
+		&msg.MessageId, &msg.Timestamp, &msg.Text, &senderId, &msg.Status, &msg.Type, &replyToMessageId, &photo,
+		&msg.ConversationId, &fwdSenderId, &fwdConversationId, &fwdTimestamp, &expiresAt)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -405,7 +499,7 @@ func (db *appdbimpl) GetMessageById(messageId int64, conversationId int64) (Mess
 	}
 
 	// Retrieve sender details
-	sender, err := db.GetUserById(senderId)
+	sender, err := db.GetUserById(ctx, senderId)
 	if err != nil {
 		return Message{}, fmt.Errorf("error retrieving sender: %w", err)
 	}
@@ -420,16 +514,50 @@ func (db *appdbimpl) GetMessageById(messageId int64, conversationId int64) (Mess
 	if len(photo) > 0 {
 		msg.Photo = photo
 	}
+	msg.ExpiresAt = nullTimePtr(expiresAt)
+
+	// Attach the forward attribution, if any
+	msg.ForwardedFrom, err = db.forwardInfo(ctx, fwdSenderId, fwdConversationId, fwdTimestamp)
+	if err != nil {
+		return Message{}, err
+	}
+
+	// Retrieve the mentions in the text
+	msg.Mentions, err = db.getMentions(ctx, msg.MessageId)
+	if err != nil {
+		return Message{}, err
+	}
 
 	return msg, nil
 }
 
+// forwardInfo builds the forward attribution of a message from its nullable columns. It returns nil for messages
+// that are not forwards.
+func (db *appdbimpl) forwardInfo(ctx context.Context, senderId sql.NullInt64, conversationId sql.NullInt64, timestamp sql.NullTime) (*ForwardInfo, error) {
+	if !conversationId.Valid {
+		return nil, nil
+	}
+	info := ForwardInfo{
+		ConversationId: conversationId.Int64,
+		Timestamp:      timestamp.Time,
+	}
+	if senderId.Valid {
+		sender, err := db.GetUserById(ctx, senderId.Int64)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving original sender: %w", err)
+		}
+		info.Sender = &sender
+	}
+	return &info, nil
+}
+
 // GetCommentsByMessage retrieves the comments for a specific message.
-func (db *appdbimpl) GetCommentsByMessage(messageId int64) ([]Comment, error) {
-	rows, err := db.c.Query(`
+func (db *appdbimpl) GetCommentsByMessage(ctx context.Context, messageId int64) ([]Comment, error) {
+	rows, err := db.q.QueryContext(ctx, `
 		SELECT comment_id, sender_id, content
 		FROM comments
-		WHERE message_id = ?`, messageId)
+		WHERE message_id = ?
+		ORDER BY comment_id`, messageId)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving comments: %w", err)
 	}
@@ -438,33 +566,73 @@ func (db *appdbimpl) GetCommentsByMessage(messageId int64) ([]Comment, error) {
 	var comments []Comment
 	for rows.Next() {
 		var comment Comment
-		var senderId int64
-		if err := rows.Scan(&comment.CommentId, &senderId, &comment.Content); err != nil {
+		if err := rows.Scan(&comment.CommentId, &comment.Sender.UserId, &comment.Content); err != nil {
 			return nil, fmt.Errorf("error scanning comment: %w", err)
 		}
+		comments = append(comments, comment)
+	}
 
-		// Retrieve the sender's user data
-		sender, err := db.GetUserById(senderId)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	// Retrieve the senders' user data, once all the rows are read and the connection of the query is free again
+	for i := range comments {
+		sender, err := db.GetUserById(ctx, comments[i].Sender.UserId)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				return nil, fmt.Errorf("sender not found for comment: %w", err)
 			}
 			return nil, fmt.Errorf("error retrieving sender for comment: %w", err)
 		}
-		comment.Sender = sender
-		comments = append(comments, comment)
+		comments[i].Sender = sender
+	}
 
+	return comments, nil
+}
+
+// summarizeReactions groups the comments of a message by emoji, in order of first use, and marks the ones added by
+// the user viewing the message.
+func summarizeReactions(comments []Comment, viewerId int64) []Reaction {
+	var reactions []Reaction
+	index := make(map[string]int)
+	for _, comment := range comments {
+		i, ok := index[comment.Content]
+		if !ok {
+			i = len(reactions)
+			index[comment.Content] = i
+			reactions = append(reactions, Reaction{Emoji: comment.Content})
+		}
+		reactions[i].Count++
+		if comment.Sender.UserId == viewerId {
+			reactions[i].ReactedByMe = true
+		}
 	}
+	return reactions
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows iteration error: %w", err)
+// GetMessagesByConversation retrieves the messages for a specific conversation, sorted by timestamp.
+func (db *appdbimpl) GetMessagesByConversation(ctx context.Context, userId int64, conversationId int64, sortOrder string) ([]Message, error) {
+	var messages []Message
+	err := db.eachMessage(ctx, userId, conversationId, sortOrder, time.Time{}, time.Time{}, func(msg Message) error {
+		messages = append(messages, msg)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return messages, nil
+}
 
-	return comments, nil
+// IterateMessages calls fn for each message of a conversation, oldest first, without loading all of them in memory.
+// Only the messages sent from `from` (included) to `to` (excluded) are visited; a zero time means no limit.
+// The iteration stops at the first error returned by fn.
+func (db *appdbimpl) IterateMessages(ctx context.Context, userId int64, conversationId int64, from time.Time, to time.Time, fn func(Message) error) error {
+	return db.eachMessage(ctx, userId, conversationId, "asc", from, to, fn)
 }
 
-// GetMessagesByConversation retrieves the messages for a specific conversation, sorted by timestamp.
-func (db *appdbimpl) GetMessagesByConversation(userId int64, conversationId int64, sortOrder string) ([]Message, error) {
+// eachMessage retrieves the messages of a conversation visible to `userId`, in timestamp order, and calls fn for each.
+func (db *appdbimpl) eachMessage(ctx context.Context, userId int64, conversationId int64, sortOrder string, from time.Time, to time.Time, fn func(Message) error) error {
 	// Prepare the query to retrieve messages from the database, ordered by timestamp.
 	var orderBy string
 	if sortOrder == "asc" {
@@ -473,71 +641,153 @@ func (db *appdbimpl) GetMessagesByConversation(userId int64, conversationId int6
 		orderBy = "DESC"
 	}
 
-	rows, err := db.c.Query(`
-		SELECT m.message_id, m.timestamp, m.text, m.sender_id, m.status, m.type, m.reply_to_message_id, m.photo
+	// The status of each message depends on how far the members read the conversation
+	readThreshold, err := db.readThreshold(ctx, userId, conversationId)
+	if err != nil {
+		return fmt.Errorf("error updating message statuses: %w", err)
+	}
+
+	query := `
+		SELECT m.message_id, m.timestamp, m.text, m.sender_id, m.status, m.type, m.reply_to_message_id, m.photo,
+			m.forwarded_from_sender_id, m.forwarded_from_conversation_id, m.forwarded_from_timestamp, m.expires_at
 		FROM messages m
 		JOIN conversations c ON c.conversation_id = m.conversation_id
 		JOIN conversation_members cm ON cm.conversation_id = c.conversation_id
-		WHERE cm.user_id = ? AND c.conversation_id = ?
-		ORDER BY m.timestamp `+orderBy, userId, conversationId)
-	if err != nil {
-		return nil, fmt.Errorf("error retrieving messages: %w", err)
-	}
-	defer rows.Close()
+		WHERE cm.user_id = ? AND c.conversation_id = ? AND (m.expires_at IS NULL OR m.expires_at > ?)`
+	args := []interface{}{userId, conversationId, globaltime.Now().UTC()}
+	// The timestamps of the messages are saved in the local time zone: the limits are compared in the same zone, so
+	// that the comparison works on the text too
+	if !from.IsZero() {
+		query += ` AND m.timestamp >= ?`
+		args = append(args, from.Local())
+	}
+	if !to.IsZero() {
+		query += ` AND m.timestamp < ?`
+		args = append(args, to.Local())
+	}
+
+	// The messages are read in batches, each one starting after the last message of the previous one (the id breaks
+	// the ties between messages sent at the same time), so that neither the messages nor the cursor of the query are
+	// kept while fn runs
+	after := ">"
+	if orderBy == "DESC" {
+		after = "<"
+	}
+	photos := db.newPhotoPrivacy(ctx, userId)
+	forwards := db.newForwardPrivacy(ctx, userId)
+	var last *messageRow
+	for {
+		batchQuery, batchArgs := query, args[:len(args):len(args)]
+		if last != nil {
+			batchQuery += ` AND (m.timestamp ` + after + ` ? OR (m.timestamp = ? AND m.message_id ` + after + ` ?))`
+			batchArgs = append(batchArgs, last.msg.Timestamp, last.msg.Timestamp, last.msg.MessageId)
+		}
+		found, err := db.messageRows(ctx, batchQuery+` ORDER BY m.timestamp `+orderBy+`, m.message_id `+orderBy+
+			` LIMIT ?`, append(batchArgs, messageBatch)...)
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return nil
+		}
+		last = &found[len(found)-1]
 
-	// Update the last access timestamp for the user in the conversation
-	if err := db.UpdateLastAccess(userId, conversationId); err != nil {
-		return nil, fmt.Errorf("failed to update last access: %w", err)
-	}
+		for _, row := range found {
+			msg := row.msg
+			msg.ConversationId = conversationId
+			msg.ExpiresAt = nullTimePtr(row.expiresAt)
 
-	var messages []Message
-	for rows.Next() {
-		var msg Message
-		var senderId int64
-		var replyToMessageId sql.NullInt64
-		var photo sql.RawBytes
-		if err := rows.Scan(&msg.MessageId, &msg.Timestamp, &msg.Text, &senderId, &msg.Status, &msg.Type, &replyToMessageId, &photo); err != nil {
-			return nil, fmt.Errorf("error scanning message: %w", err)
-		}
+			// Retrieve the sender's user data
+			sender, err := db.GetUserById(ctx, row.senderId)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("sender not found for message %d: %w", msg.MessageId, err)
+				}
+				return fmt.Errorf("error retrieving sender for message %d: %w", msg.MessageId, err)
+			}
+			if err := photos.apply(&sender); err != nil {
+				return fmt.Errorf("error applying photo privacy: %w", err)
+			}
+			msg.Sender = sender
 
-		// Retrieve the sender's user data
-		sender, err := db.GetUserById(senderId)
-		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				return nil, fmt.Errorf("sender not found for message %d: %w", msg.MessageId, err)
+			// Retrieve the comments for the message (if any)
+			msg.Comments, err = db.GetCommentsByMessage(ctx, msg.MessageId)
+			if err != nil {
+				return fmt.Errorf("error retrieving comments for message %d: %w", msg.MessageId, err)
 			}
-			return nil, fmt.Errorf("error retrieving sender for message %d: %w", msg.MessageId, err)
-		}
-		msg.Sender = sender
+			msg.Reactions = summarizeReactions(msg.Comments, userId)
 
-		// Retrieve the comments for the message (if any)
-		msg.Comments, err = db.GetCommentsByMessage(msg.MessageId)
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving comments for message %d: %w", msg.MessageId, err)
-		}
+			// Handle nullable ReplyToMessageId
+			if row.replyToMessageId.Valid {
+				replyToMessageId := row.replyToMessageId.Int64
+				msg.ReplyToMessageId = &replyToMessageId
+			}
 
-		// Handle nullable ReplyToMessageId
-		if replyToMessageId.Valid {
-			msg.ReplyToMessageId = &replyToMessageId.Int64
-		}
+			// Attach the forward attribution, if any
+			msg.ForwardedFrom, err = db.forwardInfo(ctx, row.fwdSenderId, row.fwdConversationId, row.fwdTimestamp)
+			if err != nil {
+				return fmt.Errorf("error retrieving forward attribution for message %d: %w", msg.MessageId, err)
+			}
+			if err := forwards.apply(msg.ForwardedFrom); err != nil {
+				return fmt.Errorf("error applying forward privacy: %w", err)
+			}
+			if msg.ForwardedFrom != nil && msg.ForwardedFrom.Sender != nil {
+				if err := photos.apply(msg.ForwardedFrom.Sender); err != nil {
+					return fmt.Errorf("error applying photo privacy: %w", err)
+				}
+			}
+
+			// Retrieve the mentions in the text
+			msg.Mentions, err = db.getMentions(ctx, msg.MessageId)
+			if err != nil {
+				return fmt.Errorf("error retrieving mentions for message %d: %w", msg.MessageId, err)
+			}
 
-		// Store the photo as a byte slice if it exists
-		if photo != nil {
-			msg.Photo = photo
+			msg.Status = messageStatus(msg, readThreshold)
+			if err := fn(msg); err != nil {
+				return err
+			}
+		}
+		if len(found) < messageBatch {
+			return nil
 		}
+	}
+}
 
-		messages = append(messages, msg)
+// messageBatch is the number of messages that eachMessage reads with each query.
+const messageBatch = 100
+
+// messageRows reads the rows of a query on the messages. The rows are read before retrieving the senders, comments
+// and mentions of the messages, so that the connection of the query is free again for those: with a pool of a single
+// connection they would wait for themselves.
+func (db *appdbimpl) messageRows(ctx context.Context, query string, args ...interface{}) ([]messageRow, error) {
+	rows, err := db.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving messages: %w", err)
 	}
+	defer rows.Close()
 
+	var found []messageRow
+	for rows.Next() {
+		var row messageRow
+		if err := rows.Scan(&row.msg.MessageId, &row.msg.Timestamp, &row.msg.Text, &row.senderId, &row.msg.Status,
+			&row.msg.Type, &row.replyToMessageId, &row.msg.Photo, &row.fwdSenderId, &row.fwdConversationId,
+			&row.fwdTimestamp, &row.expiresAt); err != nil {
+			return nil, fmt.Errorf("error scanning message: %w", err)
+		}
+		found = append(found, row)
+	}
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("rows iteration error: %w", err)
 	}
+	return found, nil
+}
 
-	// Update message status
-	messages, err = db.UpdateMessagesStatus(conversationId, messages)
-	if err != nil {
-		return nil, fmt.Errorf("error updating message statuses: %w", err)
-	}
-
-	return messages, nil
+// messageRow is a row read by eachMessage, before retrieving the rest of the message.
+type messageRow struct {
+	msg                            Message
+	senderId                       int64
+	replyToMessageId               sql.NullInt64
+	fwdSenderId, fwdConversationId sql.NullInt64
+	fwdTimestamp, expiresAt        sql.NullTime
 }
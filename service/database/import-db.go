@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// ImportConversation imports a chat exported from another messenger in a single transaction. The first import of a
+// chat creates a new conversation; later imports of the same chat add only the members and the messages that are not
+// there yet, so an import can be repeated safely. Messages keep their original timestamp and never expire.
+func (db *appdbimpl) ImportConversation(ctx context.Context, chat ImportedChat) (ImportResult, error) {
+	if chat.Type != "direct" && chat.Type != "group" {
+		return ImportResult{}, fmt.Errorf("invalid conversation type %q", chat.Type)
+	}
+	var result ImportResult
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		err := tx.q.QueryRowContext(ctx, `SELECT conversation_id FROM chat_imports WHERE source = ? AND external_id = ?`,
+			chat.Source, chat.ExternalId).Scan(&result.ConversationId)
+		if errors.Is(err, sql.ErrNoRows) {
+			result.ConversationId, err = tx.q.Insert(ctx, "conversation_id",
+				`INSERT INTO conversations (name, type) VALUES (?, ?)`, chat.Name, chat.Type)
+			if err != nil {
+				return fmt.Errorf("error inserting conversation: %w", err)
+			}
+			if _, err := tx.q.ExecContext(ctx, `INSERT INTO chat_imports (source, external_id, conversation_id, imported_at)
+				VALUES (?, ?, ?, ?)`, chat.Source, chat.ExternalId, result.ConversationId, globaltime.Now()); err != nil {
+				return fmt.Errorf("error recording chat import: %w", err)
+			}
+			result.Created = true
+		} else if err != nil {
+			return fmt.Errorf("error checking previous imports: %w", err)
+		}
+
+		// The imported history counts as already read by the members
+		var lastTimestamp time.Time
+		for _, message := range chat.Messages {
+			if message.Timestamp.After(lastTimestamp) {
+				lastTimestamp = message.Timestamp.Local()
+			}
+		}
+		for _, userId := range chat.MemberIds {
+			if _, err := tx.q.ExecContext(ctx, `INSERT INTO conversation_members (conversation_id, user_id, last_access)
+				VALUES (?, ?, ?) ON CONFLICT DO NOTHING`, result.ConversationId, userId, lastTimestamp); err != nil {
+				return fmt.Errorf("error adding conversation member: %w", err)
+			}
+		}
+		for key, userId := range chat.ParticipantIds {
+			if _, err := tx.q.ExecContext(ctx, `INSERT INTO imported_participants (conversation_id, external_id, user_id)
+				VALUES (?, ?, ?) ON CONFLICT (conversation_id, external_id) DO UPDATE SET user_id = excluded.user_id`,
+				result.ConversationId, key, userId); err != nil {
+				return fmt.Errorf("error recording imported participant: %w", err)
+			}
+		}
+
+		for _, message := range chat.Messages {
+			var exists bool
+			if err := tx.q.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM imported_messages WHERE conversation_id = ? AND external_id = ?)`,
+				result.ConversationId, message.ExternalId).Scan(&exists); err != nil {
+				return fmt.Errorf("error checking imported message: %w", err)
+			}
+			if exists {
+				result.Skipped++
+				continue
+			}
+
+			messageType := "standard"
+			var replyTo sql.NullInt64
+			if message.ReplyTo != "" {
+				err := tx.q.QueryRowContext(ctx, `
+					SELECT i.message_id FROM imported_messages i
+					JOIN messages m ON m.message_id = i.message_id
+					WHERE i.conversation_id = ? AND i.external_id = ?`,
+					result.ConversationId, message.ReplyTo).Scan(&replyTo)
+				if err != nil && !errors.Is(err, sql.ErrNoRows) {
+					return fmt.Errorf("error resolving replied message: %w", err)
+				}
+				if replyTo.Valid {
+					messageType = "reply"
+				}
+			}
+			var photo interface{}
+			if len(message.Photo) > 0 {
+				photo = message.Photo
+			}
+			messageId, err := tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, photo, conversation_id, sender_id, status, type, reply_to_message_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+				message.Timestamp.Local(), message.Text, photo, result.ConversationId, message.SenderId, "received", messageType, replyTo)
+			if err != nil {
+				return fmt.Errorf("error inserting message: %w", err)
+			}
+			if _, err := tx.q.ExecContext(ctx, `INSERT INTO imported_messages (conversation_id, external_id, message_id) VALUES (?, ?, ?)`,
+				result.ConversationId, message.ExternalId, messageId); err != nil {
+				return fmt.Errorf("error recording imported message: %w", err)
+			}
+			result.Imported++
+		}
+
+		// Older messages may have been added, so the last message is looked up again
+		if _, err := tx.q.ExecContext(ctx, `
+			UPDATE conversations SET last_message_id = (
+				SELECT message_id FROM messages WHERE conversation_id = ?1 ORDER BY timestamp DESC, message_id DESC LIMIT 1
+			) WHERE conversation_id = ?1`, result.ConversationId); err != nil {
+			return fmt.Errorf("error updating last_message_id: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// GetImportedParticipants returns the users that the participants of a chat were mapped to when it was imported, by
+// their key in the export, or an empty map if the chat was never imported.
+func (db *appdbimpl) GetImportedParticipants(ctx context.Context, source string, externalId string) (map[string]int64, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT p.external_id, p.user_id
+		FROM chat_imports c
+		JOIN imported_participants p ON p.conversation_id = c.conversation_id
+		WHERE c.source = ? AND c.external_id = ?`, source, externalId)
+	if err != nil {
+		return nil, fmt.Errorf("error querying imported participants: %w", err)
+	}
+	defer rows.Close()
+	participants := make(map[string]int64)
+	for rows.Next() {
+		var key string
+		var userId int64
+		if err := rows.Scan(&key, &userId); err != nil {
+			return nil, fmt.Errorf("error scanning imported participant: %w", err)
+		}
+		participants[key] = userId
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating imported participants: %w", err)
+	}
+	return participants, nil
+}
@@ -0,0 +1,557 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// Observer receives the duration and the error of every call to a method of an AppDatabase returned by Instrument.
+type Observer func(method string, elapsed time.Duration, err error)
+
+// Instrument returns an AppDatabase that calls the methods of db and reports each call to observe, for example to
+// export the latency of the database as metrics.
+func Instrument(db AppDatabase, observe Observer) AppDatabase {
+	return &instrumented{db: db, observe: observe}
+}
+
+type instrumented struct {
+	db      AppDatabase
+	observe Observer
+}
+
+// done reports the call to method started at start.
+func (i *instrumented) done(method string, start time.Time, err error) {
+	i.observe(method, time.Since(start), err)
+}
+
+func (i *instrumented) GetName(ctx context.Context) (string, error) {
+	start := time.Now()
+	result, err := i.db.GetName(ctx)
+	i.done("GetName", start, err)
+	return result, err
+}
+
+func (i *instrumented) SetName(ctx context.Context, name string) error {
+	start := time.Now()
+	err := i.db.SetName(ctx, name)
+	i.done("SetName", start, err)
+	return err
+}
+
+func (i *instrumented) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := i.db.Ping(ctx)
+	i.done("Ping", start, err)
+	return err
+}
+
+func (i *instrumented) CreateUser(ctx context.Context, name string) (User, error) {
+	start := time.Now()
+	result, err := i.db.CreateUser(ctx, name)
+	i.done("CreateUser", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetUserByName(ctx context.Context, name string) (*User, error) {
+	start := time.Now()
+	result, err := i.db.GetUserByName(ctx, name)
+	i.done("GetUserByName", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateUsername(ctx context.Context, userId int64, newUsername string) error {
+	start := time.Now()
+	err := i.db.UpdateUsername(ctx, userId, newUsername)
+	i.done("UpdateUsername", start, err)
+	return err
+}
+
+func (i *instrumented) GetUserById(ctx context.Context, userId int64) (User, error) {
+	start := time.Now()
+	result, err := i.db.GetUserById(ctx, userId)
+	i.done("GetUserById", start, err)
+	return result, err
+}
+
+func (i *instrumented) IsUserInConversation(ctx context.Context, userId int64, conversationId int64) (bool, error) {
+	start := time.Now()
+	result, err := i.db.IsUserInConversation(ctx, userId, conversationId)
+	i.done("IsUserInConversation", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetConversationsByUser(ctx context.Context, userId int64, sortOrder string, includeArchived bool) ([]Conversation, error) {
+	start := time.Now()
+	result, err := i.db.GetConversationsByUser(ctx, userId, sortOrder, includeArchived)
+	i.done("GetConversationsByUser", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetMessagesByConversation(ctx context.Context, userId int64, conversationId int64, sortOrder string) ([]Message, error) {
+	start := time.Now()
+	result, err := i.db.GetMessagesByConversation(ctx, userId, conversationId, sortOrder)
+	i.done("GetMessagesByConversation", start, err)
+	return result, err
+}
+
+func (i *instrumented) IterateMessages(ctx context.Context, userId int64, conversationId int64, from time.Time, to time.Time, fn func(Message) error) error {
+	start := time.Now()
+	err := i.db.IterateMessages(ctx, userId, conversationId, from, to, fn)
+	i.done("IterateMessages", start, err)
+	return err
+}
+
+func (i *instrumented) GetConversationSummary(ctx context.Context, conversationId int64, userId int64) (Conversation, error) {
+	start := time.Now()
+	result, err := i.db.GetConversationSummary(ctx, conversationId, userId)
+	i.done("GetConversationSummary", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetCommentsByMessage(ctx context.Context, messageId int64) ([]Comment, error) {
+	start := time.Now()
+	result, err := i.db.GetCommentsByMessage(ctx, messageId)
+	i.done("GetCommentsByMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) AddMessage(ctx context.Context, conversationId int64, senderId int64, content string, status string, messageType string, photo []byte) (Message, error) {
+	start := time.Now()
+	result, err := i.db.AddMessage(ctx, conversationId, senderId, content, status, messageType, photo)
+	i.done("AddMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) ForwardMessage(ctx context.Context, userId int64, originalMessage Message, targetConversationIds []int64) ([]Message, error) {
+	start := time.Now()
+	result, err := i.db.ForwardMessage(ctx, userId, originalMessage, targetConversationIds)
+	i.done("ForwardMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetMessageById(ctx context.Context, messageId int64, conversationId int64) (Message, error) {
+	start := time.Now()
+	result, err := i.db.GetMessageById(ctx, messageId, conversationId)
+	i.done("GetMessageById", start, err)
+	return result, err
+}
+
+func (i *instrumented) AddCommentToMessage(ctx context.Context, messageId int64, senderId int64, content string) (Comment, error) {
+	start := time.Now()
+	result, err := i.db.AddCommentToMessage(ctx, messageId, senderId, content)
+	i.done("AddCommentToMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetCommentById(ctx context.Context, commentId int64) (Comment, error) {
+	start := time.Now()
+	result, err := i.db.GetCommentById(ctx, commentId)
+	i.done("GetCommentById", start, err)
+	return result, err
+}
+
+func (i *instrumented) DeleteCommentById(ctx context.Context, commentId int64) error {
+	start := time.Now()
+	err := i.db.DeleteCommentById(ctx, commentId)
+	i.done("DeleteCommentById", start, err)
+	return err
+}
+
+func (i *instrumented) DeleteMessageById(ctx context.Context, messageId int64) error {
+	start := time.Now()
+	err := i.db.DeleteMessageById(ctx, messageId)
+	i.done("DeleteMessageById", start, err)
+	return err
+}
+
+func (i *instrumented) GetGroupById(ctx context.Context, conversationId int64) (*Conversation, error) {
+	start := time.Now()
+	result, err := i.db.GetGroupById(ctx, conversationId)
+	i.done("GetGroupById", start, err)
+	return result, err
+}
+
+func (i *instrumented) AddUserToGroup(ctx context.Context, conversationId int64, userId int64) error {
+	start := time.Now()
+	err := i.db.AddUserToGroup(ctx, conversationId, userId)
+	i.done("AddUserToGroup", start, err)
+	return err
+}
+
+func (i *instrumented) IsUserMemberOfGroup(ctx context.Context, userId int64, conversationId int64) (bool, error) {
+	start := time.Now()
+	result, err := i.db.IsUserMemberOfGroup(ctx, userId, conversationId)
+	i.done("IsUserMemberOfGroup", start, err)
+	return result, err
+}
+
+func (i *instrumented) RemoveUserFromGroup(ctx context.Context, conversationId int64, userId int64) error {
+	start := time.Now()
+	err := i.db.RemoveUserFromGroup(ctx, conversationId, userId)
+	i.done("RemoveUserFromGroup", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateGroupName(ctx context.Context, conversationId int64, newName string) error {
+	start := time.Now()
+	err := i.db.UpdateGroupName(ctx, conversationId, newName)
+	i.done("UpdateGroupName", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateUserPhoto(ctx context.Context, userId int64, photoData []byte) error {
+	start := time.Now()
+	err := i.db.UpdateUserPhoto(ctx, userId, photoData)
+	i.done("UpdateUserPhoto", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateGroupPhoto(ctx context.Context, conversationId int64, photoData []byte) error {
+	start := time.Now()
+	err := i.db.UpdateGroupPhoto(ctx, conversationId, photoData)
+	i.done("UpdateGroupPhoto", start, err)
+	return err
+}
+
+func (i *instrumented) GetConversationById(ctx context.Context, conversationId int64) (Conversation, error) {
+	start := time.Now()
+	result, err := i.db.GetConversationById(ctx, conversationId)
+	i.done("GetConversationById", start, err)
+	return result, err
+}
+
+func (i *instrumented) CreateConversation(ctx context.Context, user1Id, user2Id int64, conversationType string) (Conversation, error) {
+	start := time.Now()
+	result, err := i.db.CreateConversation(ctx, user1Id, user2Id, conversationType)
+	i.done("CreateConversation", start, err)
+	return result, err
+}
+
+func (i *instrumented) ImportConversation(ctx context.Context, chat ImportedChat) (ImportResult, error) {
+	start := time.Now()
+	result, err := i.db.ImportConversation(ctx, chat)
+	i.done("ImportConversation", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetImportedParticipants(ctx context.Context, source string, externalId string) (map[string]int64, error) {
+	start := time.Now()
+	result, err := i.db.GetImportedParticipants(ctx, source, externalId)
+	i.done("GetImportedParticipants", start, err)
+	return result, err
+}
+
+func (i *instrumented) SearchUsersByUsername(ctx context.Context, username string) ([]User, error) {
+	start := time.Now()
+	result, err := i.db.SearchUsersByUsername(ctx, username)
+	i.done("SearchUsersByUsername", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetGroupMembers(ctx context.Context, groupId int64) ([]string, error) {
+	start := time.Now()
+	result, err := i.db.GetGroupMembers(ctx, groupId)
+	i.done("GetGroupMembers", start, err)
+	return result, err
+}
+
+func (i *instrumented) ReplyMessage(ctx context.Context, conversationId int64, senderId int64, replyMessageId int64, text string, status string, messageType string, photo []byte) (Message, error) {
+	start := time.Now()
+	result, err := i.db.ReplyMessage(ctx, conversationId, senderId, replyMessageId, text, status, messageType, photo)
+	i.done("ReplyMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) PinMessage(ctx context.Context, conversationId int64, messageId int64, userId int64, limit int) (PinnedMessage, error) {
+	start := time.Now()
+	result, err := i.db.PinMessage(ctx, conversationId, messageId, userId, limit)
+	i.done("PinMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) UnpinMessage(ctx context.Context, conversationId int64, messageId int64) error {
+	start := time.Now()
+	err := i.db.UnpinMessage(ctx, conversationId, messageId)
+	i.done("UnpinMessage", start, err)
+	return err
+}
+
+func (i *instrumented) GetPinnedMessages(ctx context.Context, conversationId int64) ([]PinnedMessage, error) {
+	start := time.Now()
+	result, err := i.db.GetPinnedMessages(ctx, conversationId)
+	i.done("GetPinnedMessages", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetMessageConversationId(ctx context.Context, messageId int64) (int64, error) {
+	start := time.Now()
+	result, err := i.db.GetMessageConversationId(ctx, messageId)
+	i.done("GetMessageConversationId", start, err)
+	return result, err
+}
+
+func (i *instrumented) StarMessage(ctx context.Context, userId int64, messageId int64) error {
+	start := time.Now()
+	err := i.db.StarMessage(ctx, userId, messageId)
+	i.done("StarMessage", start, err)
+	return err
+}
+
+func (i *instrumented) UnstarMessage(ctx context.Context, userId int64, messageId int64) error {
+	start := time.Now()
+	err := i.db.UnstarMessage(ctx, userId, messageId)
+	i.done("UnstarMessage", start, err)
+	return err
+}
+
+func (i *instrumented) GetStarredMessages(ctx context.Context, userId int64, limit int, offset int) ([]StarredMessage, error) {
+	start := time.Now()
+	result, err := i.db.GetStarredMessages(ctx, userId, limit, offset)
+	i.done("GetStarredMessages", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateLastAccess(ctx context.Context, userId int64, conversationId int64) error {
+	start := time.Now()
+	err := i.db.UpdateLastAccess(ctx, userId, conversationId)
+	i.done("UpdateLastAccess", start, err)
+	return err
+}
+
+func (i *instrumented) MarkConversationUnread(ctx context.Context, userId int64, conversationId int64) error {
+	start := time.Now()
+	err := i.db.MarkConversationUnread(ctx, userId, conversationId)
+	i.done("MarkConversationUnread", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateConversationSettings(ctx context.Context, userId int64, conversationId int64, update MemberSettingsUpdate) (MemberSettings, error) {
+	start := time.Now()
+	result, err := i.db.UpdateConversationSettings(ctx, userId, conversationId, update)
+	i.done("UpdateConversationSettings", start, err)
+	return result, err
+}
+
+func (i *instrumented) SetMessageTTL(ctx context.Context, conversationId int64, ttl time.Duration) error {
+	start := time.Now()
+	err := i.db.SetMessageTTL(ctx, conversationId, ttl)
+	i.done("SetMessageTTL", start, err)
+	return err
+}
+
+func (i *instrumented) DeleteExpiredMessages(ctx context.Context, now time.Time) (int, error) {
+	start := time.Now()
+	result, err := i.db.DeleteExpiredMessages(ctx, now)
+	i.done("DeleteExpiredMessages", start, err)
+	return result, err
+}
+
+func (i *instrumented) CreateScheduledMessage(ctx context.Context, scheduled ScheduledMessage) (ScheduledMessage, error) {
+	start := time.Now()
+	result, err := i.db.CreateScheduledMessage(ctx, scheduled)
+	i.done("CreateScheduledMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetScheduledMessageById(ctx context.Context, scheduledId int64) (ScheduledMessage, error) {
+	start := time.Now()
+	result, err := i.db.GetScheduledMessageById(ctx, scheduledId)
+	i.done("GetScheduledMessageById", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetScheduledMessages(ctx context.Context, userId int64, conversationId int64) ([]ScheduledMessage, error) {
+	start := time.Now()
+	result, err := i.db.GetScheduledMessages(ctx, userId, conversationId)
+	i.done("GetScheduledMessages", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetDueScheduledMessages(ctx context.Context, now time.Time) ([]ScheduledMessage, error) {
+	start := time.Now()
+	result, err := i.db.GetDueScheduledMessages(ctx, now)
+	i.done("GetDueScheduledMessages", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateScheduledMessage(ctx context.Context, scheduledId int64, text string, sendAt time.Time) error {
+	start := time.Now()
+	err := i.db.UpdateScheduledMessage(ctx, scheduledId, text, sendAt)
+	i.done("UpdateScheduledMessage", start, err)
+	return err
+}
+
+func (i *instrumented) DeleteScheduledMessage(ctx context.Context, scheduledId int64) (bool, error) {
+	start := time.Now()
+	result, err := i.db.DeleteScheduledMessage(ctx, scheduledId)
+	i.done("DeleteScheduledMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) SendScheduledMessage(ctx context.Context, scheduledId int64, now time.Time) (*Message, error) {
+	start := time.Now()
+	result, err := i.db.SendScheduledMessage(ctx, scheduledId, now)
+	i.done("SendScheduledMessage", start, err)
+	return result, err
+}
+
+func (i *instrumented) SaveDraft(ctx context.Context, userId int64, conversationId int64, text string) (Draft, error) {
+	start := time.Now()
+	result, err := i.db.SaveDraft(ctx, userId, conversationId, text)
+	i.done("SaveDraft", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetDraft(ctx context.Context, userId int64, conversationId int64) (Draft, error) {
+	start := time.Now()
+	result, err := i.db.GetDraft(ctx, userId, conversationId)
+	i.done("GetDraft", start, err)
+	return result, err
+}
+
+func (i *instrumented) DeleteDraft(ctx context.Context, userId int64, conversationId int64) error {
+	start := time.Now()
+	err := i.db.DeleteDraft(ctx, userId, conversationId)
+	i.done("DeleteDraft", start, err)
+	return err
+}
+
+func (i *instrumented) UpdateLastSeen(ctx context.Context, userId int64, lastSeen time.Time) error {
+	start := time.Now()
+	err := i.db.UpdateLastSeen(ctx, userId, lastSeen)
+	i.done("UpdateLastSeen", start, err)
+	return err
+}
+
+func (i *instrumented) GetLastSeen(ctx context.Context, userId int64) (*time.Time, error) {
+	start := time.Now()
+	result, err := i.db.GetLastSeen(ctx, userId)
+	i.done("GetLastSeen", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetUserSettings(ctx context.Context, userId int64) (UserSettings, error) {
+	start := time.Now()
+	result, err := i.db.GetUserSettings(ctx, userId)
+	i.done("GetUserSettings", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateUserSettings(ctx context.Context, userId int64, settings UserSettings) error {
+	start := time.Now()
+	err := i.db.UpdateUserSettings(ctx, userId, settings)
+	i.done("UpdateUserSettings", start, err)
+	return err
+}
+
+func (i *instrumented) UserAllows(ctx context.Context, ownerId int64, viewerId int64, visibility string) (bool, error) {
+	start := time.Now()
+	result, err := i.db.UserAllows(ctx, ownerId, viewerId, visibility)
+	i.done("UserAllows", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetUserProfile(ctx context.Context, userId int64) (Profile, error) {
+	start := time.Now()
+	result, err := i.db.GetUserProfile(ctx, userId)
+	i.done("GetUserProfile", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateUserProfile(ctx context.Context, userId int64, update ProfileUpdate) (Profile, error) {
+	start := time.Now()
+	result, err := i.db.UpdateUserProfile(ctx, userId, update)
+	i.done("UpdateUserProfile", start, err)
+	return result, err
+}
+
+func (i *instrumented) DeleteUser(ctx context.Context, userId int64) error {
+	start := time.Now()
+	err := i.db.DeleteUser(ctx, userId)
+	i.done("DeleteUser", start, err)
+	return err
+}
+
+func (i *instrumented) CreateExportJob(ctx context.Context, userId int64) (ExportJob, bool, error) {
+	start := time.Now()
+	result, created, err := i.db.CreateExportJob(ctx, userId)
+	i.done("CreateExportJob", start, err)
+	return result, created, err
+}
+
+func (i *instrumented) GetExportJob(ctx context.Context, jobId int64) (ExportJob, error) {
+	start := time.Now()
+	result, err := i.db.GetExportJob(ctx, jobId)
+	i.done("GetExportJob", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetExportJobs(ctx context.Context, userId int64) ([]ExportJob, error) {
+	start := time.Now()
+	result, err := i.db.GetExportJobs(ctx, userId)
+	i.done("GetExportJobs", start, err)
+	return result, err
+}
+
+func (i *instrumented) UpdateExportJob(ctx context.Context, job ExportJob) error {
+	start := time.Now()
+	err := i.db.UpdateExportJob(ctx, job)
+	i.done("UpdateExportJob", start, err)
+	return err
+}
+
+func (i *instrumented) GetExpiredExportJobs(ctx context.Context, now time.Time) ([]ExportJob, error) {
+	start := time.Now()
+	result, err := i.db.GetExpiredExportJobs(ctx, now)
+	i.done("GetExpiredExportJobs", start, err)
+	return result, err
+}
+
+func (i *instrumented) FailUnfinishedExportJobs(ctx context.Context) (int, error) {
+	start := time.Now()
+	result, err := i.db.FailUnfinishedExportJobs(ctx)
+	i.done("FailUnfinishedExportJobs", start, err)
+	return result, err
+}
+
+func (i *instrumented) ListUsers(ctx context.Context, limit int, offset int) ([]User, error) {
+	start := time.Now()
+	result, err := i.db.ListUsers(ctx, limit, offset)
+	i.done("ListUsers", start, err)
+	return result, err
+}
+
+func (i *instrumented) GetStatistics(ctx context.Context) (Statistics, error) {
+	start := time.Now()
+	result, err := i.db.GetStatistics(ctx)
+	i.done("GetStatistics", start, err)
+	return result, err
+}
+
+func (i *instrumented) CheckConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error) {
+	start := time.Now()
+	result, err := i.db.CheckConversations(ctx, conversationId)
+	i.done("CheckConversations", start, err)
+	return result, err
+}
+
+func (i *instrumented) RepairConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error) {
+	start := time.Now()
+	result, err := i.db.RepairConversations(ctx, conversationId)
+	i.done("RepairConversations", start, err)
+	return result, err
+}
+
+func (i *instrumented) Vacuum(ctx context.Context) error {
+	start := time.Now()
+	err := i.db.Vacuum(ctx)
+	i.done("Vacuum", start, err)
+	return err
+}
+
+func (i *instrumented) Backup(ctx context.Context, destination string) error {
+	start := time.Now()
+	err := i.db.Backup(ctx, destination)
+	i.done("Backup", start, err)
+	return err
+}
@@ -0,0 +1,120 @@
+package database_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// checkLookups calls the methods reading a single row, or the rows of a single owner, that the other checks don't
+// use, so that every query of the database runs at least once.
+func checkLookups(ctx context.Context, db database.AppDatabase) error {
+	members, group, err := direct(ctx, db, "lkpAlice", "lkpBob")
+	if err != nil {
+		return err
+	}
+	alice, bob := members[0], members[1]
+	group, err = db.CreateConversation(ctx, alice.UserId, bob.UserId, "group")
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateGroupPhoto(ctx, group.ConversationId, []byte{4, 5, 6}); err != nil {
+		return err
+	}
+	summary, err := db.GetConversationSummary(ctx, group.ConversationId, alice.UserId)
+	if err != nil {
+		return err
+	}
+	if summary.Type != "group" || !bytes.Equal(summary.Photo, []byte{4, 5, 6}) {
+		return fmt.Errorf("group summary: got %+v", summary)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	message, err := db.AddMessage(ctx, group.ConversationId, alice.UserId, "look me up", "sent", "text", nil)
+	if err != nil {
+		return err
+	}
+	conversationId, err := db.GetMessageConversationId(ctx, message.MessageId)
+	if err != nil {
+		return err
+	}
+	if conversationId != group.ConversationId {
+		return fmt.Errorf("conversation of message: got %d, want %d", conversationId, group.ConversationId)
+	}
+	var iterated int
+	if err := db.IterateMessages(ctx, alice.UserId, group.ConversationId, start, time.Now().Add(time.Minute),
+		func(database.Message) error {
+			iterated++
+			return nil
+		}); err != nil {
+		return err
+	}
+	if iterated != 1 {
+		return fmt.Errorf("iterated messages: got %d, want 1", iterated)
+	}
+	comment, err := db.AddCommentToMessage(ctx, message.MessageId, bob.UserId, "👀")
+	if err != nil {
+		return err
+	}
+	found, err := db.GetCommentById(ctx, comment.CommentId)
+	if err != nil {
+		return err
+	}
+	if found.Content != "👀" || found.Sender.UserId != bob.UserId {
+		return fmt.Errorf("comment: got %+v", found)
+	}
+
+	if _, err := db.CreateScheduledMessage(ctx, database.ScheduledMessage{ConversationId: group.ConversationId,
+		SenderId: alice.UserId, Text: "later", Type: "text", SendAt: time.Now().Add(time.Hour)}); err != nil {
+		return err
+	}
+	scheduled, err := db.GetScheduledMessages(ctx, alice.UserId, group.ConversationId)
+	if err != nil {
+		return err
+	}
+	if len(scheduled) != 1 || scheduled[0].Text != "later" {
+		return fmt.Errorf("scheduled messages: got %+v", scheduled)
+	}
+
+	profile, err := db.GetUserProfile(ctx, bob.UserId)
+	if err != nil {
+		return err
+	}
+	if profile.Name != "lkpBob" {
+		return fmt.Errorf("profile: got %+v", profile)
+	}
+	allowed, err := db.UserAllows(ctx, alice.UserId, bob.UserId, database.VisibilityGroups)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("members of the same group not allowed by %q", database.VisibilityGroups)
+	}
+
+	job, _, err := db.CreateExportJob(ctx, alice.UserId)
+	if err != nil {
+		return err
+	}
+	got, err := db.GetExportJob(ctx, job.JobId)
+	if err != nil {
+		return err
+	}
+	if got.UserId != alice.UserId || got.Status != job.Status {
+		return fmt.Errorf("export job: got %+v, want %+v", got, job)
+	}
+
+	if err := db.SetName(ctx, "conformance"); err != nil {
+		return err
+	}
+	name, err := db.GetName(ctx)
+	if err != nil {
+		return err
+	}
+	if name != "conformance" {
+		return fmt.Errorf("name: got %q", name)
+	}
+	return nil
+}
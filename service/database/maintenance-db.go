@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// Kinds of ConversationProblem.
+const (
+	// ProblemLastMessage: last_message_id is not the most recent message of the conversation.
+	ProblemLastMessage = "last_message"
+	// ProblemMissingMember: a member of the conversation is not a user anymore.
+	ProblemMissingMember = "missing_member"
+	// ProblemMissingSender: messages of the conversation were sent by a user that does not exist anymore.
+	ProblemMissingSender = "missing_sender"
+	// ProblemDirectMembers: a direct conversation does not have exactly two members. The one between two deleted
+	// accounts is left with the placeholder alone, and is fine.
+	ProblemDirectMembers = "direct_members"
+	// ProblemNoMembers: nobody is a member of the conversation.
+	ProblemNoMembers = "no_members"
+)
+
+// ListUsers returns the users ordered by id, skipping the first `offset` and returning at most `limit` of them.
+func (db *appdbimpl) ListUsers(ctx context.Context, limit int, offset int) ([]User, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT id, name, COALESCE(display_name, '') FROM users
+		WHERE id != ?
+		ORDER BY id
+		LIMIT ? OFFSET ?`, DeletedUserId, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error querying users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.UserId, &user.Name, &user.DisplayName); err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over users: %w", err)
+	}
+	return users, nil
+}
+
+// GetStatistics counts the main entities of the database and measures its size.
+func (db *appdbimpl) GetStatistics(ctx context.Context) (Statistics, error) {
+	var stats Statistics
+	err := db.q.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE id != ?),
+			(SELECT COUNT(*) FROM conversations WHERE type = 'direct'),
+			(SELECT COUNT(*) FROM conversations WHERE type = 'group'),
+			(SELECT COUNT(*) FROM messages),
+			(SELECT COUNT(*) FROM messages WHERE photo IS NOT NULL),
+			(SELECT COUNT(*) FROM comments),
+			(SELECT COUNT(*) FROM scheduled_messages),
+			(SELECT COUNT(*) FROM chat_imports)`, DeletedUserId).Scan(
+		&stats.Users, &stats.DirectConversations, &stats.Groups, &stats.Messages, &stats.Photos, &stats.Comments,
+		&stats.ScheduledMessages, &stats.ImportedChats)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("error counting entities: %w", err)
+	}
+
+	stats.DatabaseSize, stats.FreeSpace, err = db.c.dialect.size(ctx, db.c)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("error reading database size: %w", err)
+	}
+	return stats, nil
+}
+
+// CheckConversations looks for inconsistencies in a conversation, or in all of them if conversationId is 0.
+func (db *appdbimpl) CheckConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error) {
+	checks := []struct {
+		kind       string
+		repairable bool
+		query      string
+		detail     func(values [2]sql.NullInt64) string
+	}{
+		{ProblemLastMessage, true, `
+			SELECT conversation_id, last_message_id, expected FROM (
+				SELECT c.conversation_id, c.last_message_id, (
+					SELECT m.message_id FROM messages m WHERE m.conversation_id = c.conversation_id
+					ORDER BY m.timestamp DESC, m.message_id DESC LIMIT 1
+				) AS expected
+				FROM conversations c WHERE ?1 = 0 OR c.conversation_id = ?1
+			) AS checked WHERE COALESCE(last_message_id, 0) != COALESCE(expected, 0) OR last_message_id = 0`,
+			func(v [2]sql.NullInt64) string {
+				return fmt.Sprintf("last message is %d instead of %d", v[0].Int64, v[1].Int64)
+			}},
+		{ProblemMissingMember, true, `
+			SELECT cm.conversation_id, cm.user_id, NULL FROM conversation_members cm
+			WHERE (?1 = 0 OR cm.conversation_id = ?1) AND cm.user_id NOT IN (SELECT id FROM users)`,
+			func(v [2]sql.NullInt64) string { return fmt.Sprintf("member %d is not a user", v[0].Int64) }},
+		{ProblemMissingSender, true, `
+			SELECT conversation_id, COUNT(*), NULL FROM messages
+			WHERE (?1 = 0 OR conversation_id = ?1) AND sender_id NOT IN (SELECT id FROM users)
+			GROUP BY conversation_id`,
+			func(v [2]sql.NullInt64) string {
+				return fmt.Sprintf("%d messages sent by users that do not exist", v[0].Int64)
+			}},
+		{ProblemDirectMembers, false, `
+			SELECT c.conversation_id, COUNT(cm.user_id), NULL FROM conversations c
+			LEFT JOIN conversation_members cm ON cm.conversation_id = c.conversation_id
+			WHERE (?1 = 0 OR c.conversation_id = ?1) AND c.type = 'direct'
+			GROUP BY c.conversation_id
+			HAVING COUNT(cm.user_id) != 2
+				AND NOT (COUNT(cm.user_id) = 1 AND MAX(cm.user_id) = ` + strconv.FormatInt(DeletedUserId, 10) + `)`,
+			func(v [2]sql.NullInt64) string { return fmt.Sprintf("direct conversation with %d members", v[0].Int64) }},
+		{ProblemNoMembers, false, `
+			SELECT c.conversation_id, NULL, NULL FROM conversations c
+			WHERE (?1 = 0 OR c.conversation_id = ?1) AND c.type = 'group'
+				AND NOT EXISTS (SELECT 1 FROM conversation_members cm WHERE cm.conversation_id = c.conversation_id)`,
+			func(v [2]sql.NullInt64) string { return "group without members" }},
+	}
+
+	var problems []ConversationProblem
+	for _, check := range checks {
+		rows, err := db.q.QueryContext(ctx, check.query, conversationId)
+		if err != nil {
+			return nil, fmt.Errorf("error checking %s: %w", check.kind, err)
+		}
+		for rows.Next() {
+			problem := ConversationProblem{Kind: check.kind, Repairable: check.repairable}
+			var values [2]sql.NullInt64
+			if err := rows.Scan(&problem.ConversationId, &values[0], &values[1]); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("error scanning %s: %w", check.kind, err)
+			}
+			problem.Detail = check.detail(values)
+			problems = append(problems, problem)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error iterating over %s: %w", check.kind, err)
+		}
+	}
+	return problems, nil
+}
+
+// RepairConversations fixes the repairable problems found by CheckConversations in a conversation, or in all of them
+// if conversationId is 0, and returns the problems it fixed. Memberships of missing users are removed, messages of
+// missing users are moved to the "Deleted user" placeholder and last_message_id is recomputed.
+func (db *appdbimpl) RepairConversations(ctx context.Context, conversationId int64) ([]ConversationProblem, error) {
+	problems, err := db.CheckConversations(ctx, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	var repaired []ConversationProblem
+	err = db.withTx(ctx, func(tx *appdbimpl) error {
+		for _, problem := range problems {
+			var query string
+			args := []interface{}{problem.ConversationId}
+			switch problem.Kind {
+			case ProblemMissingMember:
+				query = `DELETE FROM conversation_members WHERE conversation_id = ?1 AND user_id NOT IN (SELECT id FROM users)`
+			case ProblemMissingSender:
+				query = `UPDATE messages SET sender_id = ?2 WHERE conversation_id = ?1 AND sender_id NOT IN (SELECT id FROM users)`
+				args = append(args, DeletedUserId)
+			default:
+				continue
+			}
+			if _, err := tx.q.ExecContext(ctx, query, args...); err != nil {
+				return fmt.Errorf("error repairing %s of conversation %d: %w", problem.Kind, problem.ConversationId, err)
+			}
+			repaired = append(repaired, problem)
+		}
+		// The last message is fixed last, when the messages are consistent
+		for _, problem := range problems {
+			if problem.Kind != ProblemLastMessage {
+				continue
+			}
+			if _, err := tx.q.ExecContext(ctx, `
+				UPDATE conversations SET last_message_id = (
+					SELECT message_id FROM messages WHERE conversation_id = ?1 ORDER BY timestamp DESC, message_id DESC LIMIT 1
+				) WHERE conversation_id = ?1`, problem.ConversationId); err != nil {
+				return fmt.Errorf("error repairing last message of conversation %d: %w", problem.ConversationId, err)
+			}
+			repaired = append(repaired, problem)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// Vacuum rebuilds the database file, giving back to the file system the space left by deleted data.
+func (db *appdbimpl) Vacuum(ctx context.Context) error {
+	if _, err := db.q.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("error vacuuming database: %w", err)
+	}
+	return nil
+}
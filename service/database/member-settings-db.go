@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// Funzioni per le impostazioni personali delle conversazioni: silenziare, archiviare e fissare in alto.
+// Si collegano all'handler setConversationSettings e a GetConversationsByUser.
+
+// mutedForever is stored in muted_until when a conversation is muted without an expiration.
+var mutedForever = time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+// newMemberSettings builds the MemberSettings from the nullable columns of conversation_members.
+func newMemberSettings(mutedUntil sql.NullTime, archived bool, pinOrder sql.NullInt64) MemberSettings {
+	settings := MemberSettings{Archived: archived}
+	if mutedUntil.Valid && mutedUntil.Time.After(globaltime.Now()) {
+		settings.Muted = true
+		settings.MutedUntil = &mutedUntil.Time
+	}
+	if pinOrder.Valid {
+		order := int(pinOrder.Int64)
+		settings.Pinned = true
+		settings.PinOrder = &order
+	}
+	return settings
+}
+
+// UpdateConversationSettings changes the settings of a conversation for one of its members and returns the settings
+// after the change. A newly pinned conversation is placed after the ones already pinned by the user.
+func (db *appdbimpl) UpdateConversationSettings(ctx context.Context, userId int64, conversationId int64, update MemberSettingsUpdate) (MemberSettings, error) {
+	var mutedUntil sql.NullTime
+	var archived bool
+	var pinOrder sql.NullInt64
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		err := tx.q.QueryRowContext(ctx, `
+			SELECT muted_until, archived, pin_order
+			FROM conversation_members
+			WHERE user_id = ? AND conversation_id = ?`, userId, conversationId).Scan(&mutedUntil, &archived, &pinOrder)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("user %d is not a member of conversation %d: %w", userId, conversationId, err)
+			}
+			return fmt.Errorf("error retrieving conversation settings: %w", err)
+		}
+
+		var sets []string
+		var args []interface{}
+		if update.Muted != nil {
+			mutedUntil = sql.NullTime{}
+			if *update.Muted {
+				mutedUntil = sql.NullTime{Time: mutedForever, Valid: true}
+				if update.MutedUntil != nil {
+					// Stored in UTC, like the times it is compared with, so that the comparison works on the text too
+					mutedUntil.Time = update.MutedUntil.UTC()
+				}
+			}
+			sets = append(sets, "muted_until = ?")
+			args = append(args, mutedUntil)
+		}
+		if update.Archived != nil {
+			archived = *update.Archived
+			sets = append(sets, "archived = ?")
+			args = append(args, archived)
+		}
+		if update.Pinned != nil && *update.Pinned != pinOrder.Valid {
+			pinOrder = sql.NullInt64{}
+			if *update.Pinned {
+				err = tx.q.QueryRowContext(ctx, `
+					SELECT COALESCE(MAX(pin_order), 0) + 1
+					FROM conversation_members
+					WHERE user_id = ?`, userId).Scan(&pinOrder.Int64)
+				if err != nil {
+					return fmt.Errorf("error computing pin order: %w", err)
+				}
+				pinOrder.Valid = true
+			}
+			sets = append(sets, "pin_order = ?")
+			args = append(args, pinOrder)
+		}
+
+		if len(sets) > 0 {
+			args = append(args, userId, conversationId)
+			if _, err := tx.q.ExecContext(ctx, `
+				UPDATE conversation_members
+				SET `+strings.Join(sets, ", ")+`
+				WHERE user_id = ? AND conversation_id = ?`, args...); err != nil {
+				return fmt.Errorf("error updating conversation settings: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return MemberSettings{}, err
+	}
+	return newMemberSettings(mutedUntil, archived, pinOrder), nil
+}
+
+// unarchiveOnNewMessage brings an archived conversation back in the list of the members that did not mute it. It
+// is called whenever a new message is added to the conversation.
+func unarchiveOnNewMessage(ctx context.Context, e execer, conversationId int64, now time.Time) error {
+	_, err := e.ExecContext(ctx, `
+		UPDATE conversation_members
+		SET archived = 0
+		WHERE conversation_id = ? AND archived = 1 AND (muted_until IS NULL OR muted_until <= ?)`,
+		conversationId, now.UTC())
+	if err != nil {
+		return fmt.Errorf("error unarchiving conversation: %w", err)
+	}
+	return nil
+}
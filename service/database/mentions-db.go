@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"unicode"
+)
+
+// Funzioni per le menzioni @username nei messaggi.
+// Le menzioni vengono riconosciute da AddMessage e ReplyMessage confrontando il testo con i membri della conversazione.
+
+// findMentions finds the @username references to `members` inside `text`. A mention must start at the beginning of
+// the text or after a non-word character and must not be followed by a word character; when several usernames match
+// at the same position, the longest one wins (so "@anna maria" is preferred to "@anna").
+func findMentions(text string, members []User) []Mention {
+	runes := []rune(text)
+	var mentions []Mention
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && isWordRune(runes[i-1])) {
+			continue
+		}
+		var best *User
+		bestLength := 0
+		for m := range members {
+			name := []rune(members[m].Name)
+			end := i + 1 + len(name)
+			if len(name) <= bestLength || end > len(runes) || string(runes[i+1:end]) != members[m].Name {
+				continue
+			}
+			if end < len(runes) && isWordRune(runes[end]) {
+				continue
+			}
+			best = &members[m]
+			bestLength = len(name)
+		}
+		if best == nil {
+			continue
+		}
+		mentions = append(mentions, Mention{
+			UserId:   best.UserId,
+			Username: best.Name,
+			Offset:   i,
+			Length:   1 + bestLength,
+		})
+		i += bestLength
+	}
+	return mentions
+}
+
+// isWordRune reports whether r can be part of a word, and therefore cannot delimit a mention.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// getConversationMembers retrieves id and name of the members of a conversation.
+func (db *appdbimpl) getConversationMembers(ctx context.Context, conversationId int64) ([]User, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT u.id, u.name
+		FROM users u
+		INNER JOIN conversation_members cm ON u.id = cm.user_id
+		WHERE cm.conversation_id = ?`, conversationId)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving conversation members: %w", err)
+	}
+	defer rows.Close()
+
+	var members []User
+	for rows.Next() {
+		var member User
+		if err := rows.Scan(&member.UserId, &member.Name); err != nil {
+			return nil, fmt.Errorf("error scanning conversation member: %w", err)
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return members, nil
+}
+
+// saveMentions resolves the mentions in the text of a new message against the members of its conversation and
+// stores them.
+func (db *appdbimpl) saveMentions(ctx context.Context, messageId int64, conversationId int64, text string) ([]Mention, error) {
+	if text == "" {
+		return nil, nil
+	}
+	members, err := db.getConversationMembers(ctx, conversationId)
+	if err != nil {
+		return nil, err
+	}
+	mentions := findMentions(text, members)
+	for _, mention := range mentions {
+		if _, err := db.q.ExecContext(ctx, `
+			INSERT INTO message_mentions (message_id, user_id, text_offset, text_length)
+			VALUES (?, ?, ?, ?)`, messageId, mention.UserId, mention.Offset, mention.Length); err != nil {
+			return nil, fmt.Errorf("error saving mention: %w", err)
+		}
+	}
+	return mentions, nil
+}
+
+// getMentions retrieves the mentions of a message, in order of appearance.
+func (db *appdbimpl) getMentions(ctx context.Context, messageId int64) ([]Mention, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT mm.user_id, u.name, mm.text_offset, mm.text_length
+		FROM message_mentions mm
+		INNER JOIN users u ON u.id = mm.user_id
+		WHERE mm.message_id = ?
+		ORDER BY mm.text_offset`, messageId)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving mentions: %w", err)
+	}
+	defer rows.Close()
+
+	var mentions []Mention
+	for rows.Next() {
+		var mention Mention
+		if err := rows.Scan(&mention.UserId, &mention.Username, &mention.Offset, &mention.Length); err != nil {
+			return nil, fmt.Errorf("error scanning mention: %w", err)
+		}
+		mentions = append(mentions, mention)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return mentions, nil
+}
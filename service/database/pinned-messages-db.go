@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// Funzioni per i messaggi fissati in una conversazione.
+// Si collegano agli handler pinMessage, unpinMessage e getPinnedMessages.
+
+// ErrPinLimitReached is returned by PinMessage when the conversation already has the maximum number of pinned messages.
+var ErrPinLimitReached = errors.New("pinned messages limit reached")
+
+// PinMessage pins a message in a conversation and announces it with a system message. A `limit` greater than zero
+// caps the number of messages pinned in the same conversation. Pinning an already pinned message returns the existing
+// pin without announcing it again.
+func (db *appdbimpl) PinMessage(ctx context.Context, conversationId int64, messageId int64, userId int64, limit int) (PinnedMessage, error) {
+	user, err := db.GetUserById(ctx, userId)
+	if err != nil {
+		return PinnedMessage{}, fmt.Errorf("error retrieving user: %w", err)
+	}
+
+	err = db.withTx(ctx, func(tx *appdbimpl) error {
+		var alreadyPinned bool
+		err := tx.q.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM pinned_messages WHERE conversation_id = ? AND message_id = ?)`,
+			conversationId, messageId).Scan(&alreadyPinned)
+		if err != nil {
+			return fmt.Errorf("error checking pinned message: %w", err)
+		}
+
+		if !alreadyPinned {
+			if limit > 0 {
+				var pinned int
+				err = tx.q.QueryRowContext(ctx, `SELECT COUNT(*) FROM pinned_messages WHERE conversation_id = ?`, conversationId).Scan(&pinned)
+				if err != nil {
+					return fmt.Errorf("error counting pinned messages: %w", err)
+				}
+				if pinned >= limit {
+					return ErrPinLimitReached
+				}
+			}
+
+			timestamp := globaltime.Now()
+			if _, err := tx.q.ExecContext(ctx, `
+				INSERT INTO pinned_messages (conversation_id, message_id, pinned_by, pinned_at)
+				VALUES (?, ?, ?, ?)`, conversationId, messageId, userId, timestamp); err != nil {
+				return fmt.Errorf("error pinning message: %w", err)
+			}
+
+			// Announce the pin in the conversation with a system message referring to the pinned message
+			systemMessageId, err := tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, reply_to_message_id)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				timestamp, user.Name+" ha fissato un messaggio", conversationId, userId, "received", "system", messageId)
+			if err != nil {
+				return fmt.Errorf("error inserting system message: %w", err)
+			}
+			if _, err := tx.q.ExecContext(ctx, `
+				UPDATE conversations
+				SET last_message_id = ?
+				WHERE conversation_id = ?`, systemMessageId, conversationId); err != nil {
+				return fmt.Errorf("error updating last_message_id: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return PinnedMessage{}, err
+	}
+
+	return db.getPinnedMessage(ctx, conversationId, messageId)
+}
+
+// UnpinMessage removes a message from the pinned messages of a conversation.
+func (db *appdbimpl) UnpinMessage(ctx context.Context, conversationId int64, messageId int64) error {
+	_, err := db.q.ExecContext(ctx, `
+		DELETE FROM pinned_messages
+		WHERE conversation_id = ? AND message_id = ?`, conversationId, messageId)
+	if err != nil {
+		return fmt.Errorf("error unpinning message: %w", err)
+	}
+	return nil
+}
+
+// GetPinnedMessages retrieves the pinned messages of a conversation, most recently pinned first.
+func (db *appdbimpl) GetPinnedMessages(ctx context.Context, conversationId int64) ([]PinnedMessage, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT p.message_id
+		FROM pinned_messages p
+		INNER JOIN messages m ON m.message_id = p.message_id
+		WHERE p.conversation_id = ? AND (m.expires_at IS NULL OR m.expires_at > ?)
+		ORDER BY p.pinned_at DESC`, conversationId, globaltime.Now().UTC())
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving pinned messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messageIds []int64
+	for rows.Next() {
+		var messageId int64
+		if err := rows.Scan(&messageId); err != nil {
+			return nil, fmt.Errorf("error scanning pinned message: %w", err)
+		}
+		messageIds = append(messageIds, messageId)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	pinnedMessages := make([]PinnedMessage, 0, len(messageIds))
+	for _, messageId := range messageIds {
+		pinned, err := db.getPinnedMessage(ctx, conversationId, messageId)
+		if err != nil {
+			return nil, err
+		}
+		pinnedMessages = append(pinnedMessages, pinned)
+	}
+	return pinnedMessages, nil
+}
+
+// getPinnedMessage retrieves a single pin together with the pinned message and the user who pinned it.
+func (db *appdbimpl) getPinnedMessage(ctx context.Context, conversationId int64, messageId int64) (PinnedMessage, error) {
+	var pinned PinnedMessage
+	var pinnedBy int64
+	err := db.q.QueryRowContext(ctx, `
+		SELECT pinned_by, pinned_at
+		FROM pinned_messages
+		WHERE conversation_id = ? AND message_id = ?`, conversationId, messageId).Scan(&pinnedBy, &pinned.PinnedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return PinnedMessage{}, fmt.Errorf("pinned message not found: %w", err)
+		}
+		return PinnedMessage{}, fmt.Errorf("error retrieving pinned message: %w", err)
+	}
+
+	pinned.Message, err = db.GetMessageById(ctx, messageId, conversationId)
+	if err != nil {
+		return PinnedMessage{}, fmt.Errorf("error retrieving pinned message %d: %w", messageId, err)
+	}
+	pinned.PinnedBy, err = db.GetUserById(ctx, pinnedBy)
+	if err != nil {
+		return PinnedMessage{}, fmt.Errorf("error retrieving user who pinned message %d: %w", messageId, err)
+	}
+	return pinned, nil
+}
@@ -0,0 +1,171 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// postgresDialect is the dialect of PostgreSQL. Besides the placeholders (`$1` instead of `?`), it rewrites the
+// statements that create the schema with the PostgreSQL types:
+//
+//   - INTEGER PRIMARY KEY becomes an identity column, which generates the ids like SQLite does
+//   - INTEGER becomes BIGINT, DATETIME becomes TIMESTAMPTZ and BLOB becomes BYTEA
+//   - the foreign keys are left out, and added by addForeignKeys once all the tables exist: conversations, for
+//     example, refer to the messages table, created after them. They are the same as the SQLite ones, with the same
+//     ON DELETE CASCADE, and checked at the end of every statement like SQLite does
+//
+// Booleans are passed as 0 and 1, since the schema stores them in INTEGER columns like SQLite does.
+type postgresDialect struct {
+	// translated caches the translated queries, which are a fixed set
+	translated *sync.Map
+}
+
+func newPostgresDialect() postgresDialect {
+	return postgresDialect{translated: &sync.Map{}}
+}
+
+var (
+	postgresSchemaStatement = regexp.MustCompile(`^\s*(CREATE\s+TABLE|ALTER\s+TABLE\s+\w+\s+ADD\s+COLUMN)\b`)
+	postgresPrimaryKey      = regexp.MustCompile(`\bINTEGER\s+(NOT NULL\s+)?PRIMARY KEY\b`)
+	postgresForeignKey      = regexp.MustCompile(`,?\s*FOREIGN KEY\s*\(\w+\)\s*REFERENCES\s+\w+\s*\(\w+\)(\s+ON DELETE CASCADE)?`)
+	postgresReferences      = regexp.MustCompile(`\s+REFERENCES\s+\w+\s*\(\w+\)(\s+ON DELETE CASCADE)?`)
+	postgresTypes           = strings.NewReplacer("INTEGER", "BIGINT", "DATETIME", "TIMESTAMPTZ", "BLOB", "BYTEA")
+)
+
+func (postgresDialect) name() string {
+	return "PostgreSQL"
+}
+
+func (d postgresDialect) translate(query string) string {
+	if translated, ok := d.translated.Load(query); ok {
+		return translated.(string)
+	}
+	translated := query
+	if postgresSchemaStatement.MatchString(translated) {
+		translated = postgresForeignKey.ReplaceAllString(translated, "")
+		translated = postgresReferences.ReplaceAllString(translated, "")
+		translated = postgresPrimaryKey.ReplaceAllString(translated, "BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY")
+		translated = postgresTypes.Replace(translated)
+	}
+	translated = rebind(translated)
+	d.translated.Store(query, translated)
+	return translated
+}
+
+// rebind replaces the `?` and `?N` placeholders with `$N`, leaving the string literals alone.
+func rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inString := false
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		if ch == '\'' {
+			// A quote escaped by doubling it closes and reopens the literal, which leaves inString unchanged
+			inString = !inString
+		}
+		if ch != '?' || inString {
+			b.WriteByte(ch)
+			continue
+		}
+		j := i + 1
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		b.WriteByte('$')
+		if j > i+1 {
+			b.WriteString(query[i+1 : j])
+			i = j - 1
+		} else {
+			n++
+			b.WriteString(strconv.Itoa(n))
+		}
+	}
+	return b.String()
+}
+
+func (postgresDialect) convert(args []interface{}) []interface{} {
+	var converted []interface{}
+	for i, arg := range args {
+		if value, ok := arg.(bool); ok {
+			if converted == nil {
+				converted = append([]interface{}(nil), args...)
+			}
+			converted[i] = boolToInt(value)
+		}
+	}
+	if converted == nil {
+		return args
+	}
+	return converted
+}
+
+func boolToInt(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (postgresDialect) insert(ctx context.Context, e execer, idColumn string, query string, args []interface{}) (int64, error) {
+	var id int64
+	err := e.QueryRowContext(ctx, query+` RETURNING `+idColumn, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) tableExists(ctx context.Context, c *conn, table string) (bool, error) {
+	var exists bool
+	err := c.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?
+		)`, table).Scan(&exists)
+	return exists, err
+}
+
+func (postgresDialect) columnExists(ctx context.Context, c *conn, table string, column string) (bool, error) {
+	var exists bool
+	err := c.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?
+		)`, table, column).Scan(&exists)
+	return exists, err
+}
+
+// size returns the size of the whole database; the free space is reclaimed by autovacuum and is not reported.
+func (postgresDialect) size(ctx context.Context, c *conn) (int64, int64, error) {
+	var size int64
+	err := c.QueryRowContext(ctx, `SELECT pg_database_size(current_database())`).Scan(&size)
+	return size, 0, err
+}
+
+// addForeignKeys adds the foreign keys that the tables lack, named as PostgreSQL names the ones declared with a
+// table. They are NOT VALID: like SQLite when its foreign keys are turned on, PostgreSQL checks the rows written from
+// now on, but not the ones of the databases created before the foreign keys were.
+func (postgresDialect) addForeignKeys(ctx context.Context, c *conn, keys []foreignKey) error {
+	for _, key := range keys {
+		name := key.table + "_" + key.column + "_fkey"
+		var exists bool
+		if err := c.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = ? AND conrelid = to_regclass(?))`,
+			name, key.table).Scan(&exists); err != nil {
+			return fmt.Errorf("error checking foreign key %s: %w", name, err)
+		}
+		if exists {
+			continue
+		}
+		statement := `ALTER TABLE ` + key.table + ` ADD CONSTRAINT ` + name +
+			` FOREIGN KEY (` + key.column + `) REFERENCES ` + key.refTable + ` (` + key.refColumn + `)`
+		if key.cascade {
+			statement += ` ON DELETE CASCADE`
+		}
+		if _, err := c.ExecContext(ctx, statement+` NOT VALID`); err != nil {
+			return fmt.Errorf("error adding foreign key %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Funzioni per il profilo degli utenti: nome visualizzato, bio e stato.
+// Il nome utente (colonna name) resta l'identificativo univoco usato per il login e si cambia con UpdateUsername.
+
+// GetUserProfile retrieves the profile of a user.
+func (db *appdbimpl) GetUserProfile(ctx context.Context, userId int64) (Profile, error) {
+	var profile Profile
+	var photo []byte
+	err := db.q.QueryRowContext(ctx, `
+		SELECT id, name, photo, display_name, bio, status
+		FROM users
+		WHERE id = ?`, userId).Scan(&profile.UserId, &profile.Name, &photo, &profile.DisplayName, &profile.Bio,
+		&profile.Status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Profile{}, fmt.Errorf("user not found: %w", err)
+		}
+		return Profile{}, fmt.Errorf("error retrieving profile: %w", err)
+	}
+	if len(photo) > 0 {
+		profile.Photo = photo
+	}
+	return profile, nil
+}
+
+// UpdateUserProfile changes the given fields of the profile of a user and returns the profile after the change.
+func (db *appdbimpl) UpdateUserProfile(ctx context.Context, userId int64, update ProfileUpdate) (Profile, error) {
+	var sets []string
+	var args []interface{}
+	if update.DisplayName != nil {
+		sets = append(sets, "display_name = ?")
+		args = append(args, *update.DisplayName)
+	}
+	if update.Bio != nil {
+		sets = append(sets, "bio = ?")
+		args = append(args, *update.Bio)
+	}
+	if update.Status != nil {
+		sets = append(sets, "status = ?")
+		args = append(args, *update.Status)
+	}
+	if len(sets) > 0 {
+		args = append(args, userId)
+		if _, err := db.q.ExecContext(ctx, `UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = ?`, args...); err != nil {
+			return Profile{}, fmt.Errorf("error updating profile: %w", err)
+		}
+	}
+	return db.GetUserProfile(ctx, userId)
+}
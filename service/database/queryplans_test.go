@@ -0,0 +1,204 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// statementRecorder records the statements that the methods of an AppDatabase send to a SQLite database, to check
+// their query plans. Like faultInjector, it sits between database/sql and the driver. Only the statements sent
+// between start and stop are recorded, so that the creation of the schema is left out.
+type statementRecorder struct {
+	mu        sync.Mutex
+	recording bool
+	seen      map[string]bool
+	list      []recordedStatement
+}
+
+// recordedStatement is a statement recorded by statementRecorder, with the arguments of its first execution and the
+// method of AppDatabase that sent it.
+type recordedStatement struct {
+	method string
+	query  string
+	args   []interface{}
+}
+
+// Connector returns a connector that opens the connections with d and dsn, recording their statements in s.
+func (s *statementRecorder) Connector(d driver.Driver, dsn string) driver.Connector {
+	return hookedConnector{hook: s, driver: d, dsn: dsn}
+}
+
+func (s *statementRecorder) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recording = true
+}
+
+// stop stops recording the statements, and returns the ones recorded, once per method and query.
+func (s *statementRecorder) stop() []recordedStatement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recording = false
+	return s.list
+}
+
+func (s *statementRecorder) statement(query string, args []driver.NamedValue) error {
+	if query == "COMMIT" {
+		return nil
+	}
+	method := callerMethod()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.recording || s.seen[method+"\x00"+query] {
+		return nil
+	}
+	if s.seen == nil {
+		s.seen = make(map[string]bool)
+	}
+	s.seen[method+"\x00"+query] = true
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+		if arg.Name != "" {
+			values[i] = sql.Named(arg.Name, arg.Value)
+		}
+	}
+	s.list = append(s.list, recordedStatement{method: method, query: query, args: values})
+	return nil
+}
+
+// callerMethod returns the name of the outermost method of AppDatabase in the call stack, the one called by the
+// code using the database, or "" if there is none.
+func callerMethod() string {
+	pcs := make([]uintptr, 64)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	method := ""
+	for {
+		frame, more := frames.Next()
+		if i := strings.Index(frame.Function, "/service/database.(*appdbimpl)."); i >= 0 {
+			name := frame.Function[i+len("/service/database.(*appdbimpl)."):]
+			// Closures, such as the ones run by withTx, are named after the method with a suffix
+			name = strings.SplitN(name, ".", 2)[0]
+			if name != "" && strings.ToUpper(name[:1]) == name[:1] {
+				method = name
+			}
+		}
+		if !more {
+			return method
+		}
+	}
+}
+
+// coldMethods are the methods of AppDatabase whose statements may read whole tables, and why: they go through all
+// the rows on purpose, run in the background or from the command line tools, or search text in a way no index helps.
+var coldMethods = map[string]string{
+	"GetStatistics":            "counts all the rows, for wasatext-admin",
+	"CheckConversations":       "checks all the conversations, for wasatext-admin",
+	"RepairConversations":      "repairs all the conversations, for wasatext-admin",
+	"ListUsers":                "lists all the users, for wasatext-admin",
+	"Vacuum":                   "rebuilds the database, for wasatext-admin",
+	"FailUnfinishedExportJobs": "runs once at startup",
+	"GetExpiredExportJobs":     "runs in the background, on the few export jobs kept",
+	"SearchUsersByUsername":    "searches a substring of the names, which no index can help with",
+}
+
+// TestQueryPlans runs the conformance checks on a new SQLite database, which seeds it and calls the methods of
+// AppDatabase, recording their statements, and then checks the query plan of each statement: it fails if a statement
+// of a method not listed in coldMethods reads a whole table or a whole index, which usually means that an index is
+// missing.
+func TestQueryPlans(t *testing.T) {
+	ctx := context.Background()
+	statements := &statementRecorder{}
+	db, writer := openSQLite(t, statements.Connector)
+
+	statements.start()
+	for _, check := range conformanceChecks {
+		if err := check.run(ctx, db); err != nil {
+			t.Fatalf("conformance check %q failed, the statements would be incomplete: %v", check.name, err)
+		}
+	}
+	recorded := statements.stop()
+
+	methods := make(map[string]bool)
+	for _, statement := range recorded {
+		methods[statement.method] = true
+		if _, cold := coldMethods[statement.method]; cold || statement.method == "" {
+			continue
+		}
+		plan, err := queryPlan(ctx, writer, statement)
+		if err != nil {
+			t.Fatalf("explaining the query of %s %q: %v", statement.method, statement.query, err)
+		}
+		if scans := fullScans(plan); len(scans) > 0 {
+			t.Errorf("%s: %s\n  query: %s\n  plan:  %s", statement.method, strings.Join(scans, "; "),
+				strings.Join(strings.Fields(statement.query), " "), strings.Join(plan, "; "))
+		}
+	}
+	t.Logf("%d statements of %d methods checked", len(recorded), len(methods))
+
+	var missing []string
+	appDatabase := reflect.TypeOf((*database.AppDatabase)(nil)).Elem()
+	for i := 0; i < appDatabase.NumMethod(); i++ {
+		if name := appDatabase.Method(i).Name; !methods[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		t.Logf("not called by the checks, or without statements: %s", strings.Join(missing, ", "))
+	}
+}
+
+// queryPlan returns the steps of the query plan of a statement, such as "SEARCH messages USING INDEX ...".
+func queryPlan(ctx context.Context, db *sql.DB, statement recordedStatement) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `EXPLAIN QUERY PLAN `+statement.query, statement.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			return nil, err
+		}
+		plan = append(plan, detail)
+	}
+	return plan, rows.Err()
+}
+
+// fullScans returns the steps of a query plan that read a whole table, or a whole index of it. The scans of the
+// subqueries computed by the plan itself, of constant rows, of VALUES lists and of virtual tables such as
+// pragma_table_info are fine.
+func fullScans(plan []string) []string {
+	subqueries := make(map[string]bool)
+	for _, step := range plan {
+		for _, prefix := range []string{"CO-ROUTINE ", "MATERIALIZE "} {
+			if strings.HasPrefix(step, prefix) {
+				subqueries[strings.TrimPrefix(step, prefix)] = true
+			}
+		}
+	}
+	var scans []string
+	for _, step := range plan {
+		fields := strings.Fields(step)
+		if len(fields) < 2 || fields[0] != "SCAN" || strings.Contains(step, "VIRTUAL TABLE") || strings.Contains(step, "VALUES CLAUSE") {
+			continue
+		}
+		if fields[1] == "CONSTANT" || subqueries[fields[1]] {
+			continue
+		}
+		scans = append(scans, step)
+	}
+	return scans
+}
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Funzioni per i messaggi programmati.
+// I messaggi restano in scheduled_messages fino all'orario di invio, quando lo scheduler del package api li
+// consegna con SendScheduledMessage. Gli orari sono salvati in UTC, così il confronto con send_at non dipende dal fuso orario.
+
+// CreateScheduledMessage stores a message to be delivered at `scheduled.SendAt`.
+func (db *appdbimpl) CreateScheduledMessage(ctx context.Context, scheduled ScheduledMessage) (ScheduledMessage, error) {
+	scheduled.SendAt = scheduled.SendAt.UTC()
+	scheduled.CreatedAt = time.Now().UTC()
+	var err error
+	scheduled.ScheduledId, err = db.q.Insert(ctx, "scheduled_id", `
+		INSERT INTO scheduled_messages (conversation_id, sender_id, text, photo, type, send_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		scheduled.ConversationId, scheduled.SenderId, scheduled.Text, scheduled.Photo, scheduled.Type,
+		scheduled.SendAt, scheduled.CreatedAt)
+	if err != nil {
+		return ScheduledMessage{}, fmt.Errorf("error inserting scheduled message: %w", err)
+	}
+	return scheduled, nil
+}
+
+// GetScheduledMessageById retrieves a pending scheduled message.
+func (db *appdbimpl) GetScheduledMessageById(ctx context.Context, scheduledId int64) (ScheduledMessage, error) {
+	var scheduled ScheduledMessage
+	var photo []byte
+	err := db.q.QueryRowContext(ctx, `
+		SELECT scheduled_id, conversation_id, sender_id, text, photo, type, send_at, created_at
+		FROM scheduled_messages
+		WHERE scheduled_id = ?`, scheduledId).Scan(
+		&scheduled.ScheduledId, &scheduled.ConversationId, &scheduled.SenderId, &scheduled.Text, &photo,
+		&scheduled.Type, &scheduled.SendAt, &scheduled.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ScheduledMessage{}, fmt.Errorf("scheduled message not found: %w", err)
+		}
+		return ScheduledMessage{}, fmt.Errorf("error retrieving scheduled message: %w", err)
+	}
+	if len(photo) > 0 {
+		scheduled.Photo = photo
+	}
+	return scheduled, nil
+}
+
+// GetScheduledMessages retrieves the pending messages scheduled by a user in a conversation, in delivery order.
+func (db *appdbimpl) GetScheduledMessages(ctx context.Context, userId int64, conversationId int64) ([]ScheduledMessage, error) {
+	return db.queryScheduledMessages(ctx, `
+		SELECT scheduled_id, conversation_id, sender_id, text, photo, type, send_at, created_at
+		FROM scheduled_messages
+		WHERE sender_id = ? AND conversation_id = ?
+		ORDER BY send_at ASC`, userId, conversationId)
+}
+
+// GetDueScheduledMessages retrieves the scheduled messages whose delivery time is not after `now`, oldest first.
+func (db *appdbimpl) GetDueScheduledMessages(ctx context.Context, now time.Time) ([]ScheduledMessage, error) {
+	return db.queryScheduledMessages(ctx, `
+		SELECT scheduled_id, conversation_id, sender_id, text, photo, type, send_at, created_at
+		FROM scheduled_messages
+		WHERE send_at <= ?
+		ORDER BY send_at ASC, scheduled_id ASC`, now.UTC())
+}
+
+// queryScheduledMessages runs a query returning the columns of scheduled_messages.
+func (db *appdbimpl) queryScheduledMessages(ctx context.Context, query string, args ...interface{}) ([]ScheduledMessage, error) {
+	rows, err := db.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving scheduled messages: %w", err)
+	}
+	defer rows.Close()
+
+	var scheduledMessages []ScheduledMessage
+	for rows.Next() {
+		var scheduled ScheduledMessage
+		var photo []byte
+		if err := rows.Scan(&scheduled.ScheduledId, &scheduled.ConversationId, &scheduled.SenderId, &scheduled.Text,
+			&photo, &scheduled.Type, &scheduled.SendAt, &scheduled.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning scheduled message: %w", err)
+		}
+		if len(photo) > 0 {
+			scheduled.Photo = photo
+		}
+		scheduledMessages = append(scheduledMessages, scheduled)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+	return scheduledMessages, nil
+}
+
+// UpdateScheduledMessage changes the text and the delivery time of a pending scheduled message.
+func (db *appdbimpl) UpdateScheduledMessage(ctx context.Context, scheduledId int64, text string, sendAt time.Time) error {
+	_, err := db.q.ExecContext(ctx, `
+		UPDATE scheduled_messages
+		SET text = ?, send_at = ?
+		WHERE scheduled_id = ?`, text, sendAt.UTC(), scheduledId)
+	if err != nil {
+		return fmt.Errorf("error updating scheduled message: %w", err)
+	}
+	return nil
+}
+
+// DeleteScheduledMessage removes a pending scheduled message and reports whether it was still pending.
+func (db *appdbimpl) DeleteScheduledMessage(ctx context.Context, scheduledId int64) (bool, error) {
+	result, err := db.q.ExecContext(ctx, `DELETE FROM scheduled_messages WHERE scheduled_id = ?`, scheduledId)
+	if err != nil {
+		return false, fmt.Errorf("error deleting scheduled message: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get affected rows: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// SendScheduledMessage delivers the scheduled message `scheduledId` if it is due at `now`, in a single transaction: the
+// message is removed from the queue and, if the sender is still a member of the conversation, added to it with
+// AddMessage. It returns the message sent, or nil if it was cancelled or postponed in the meantime, or dropped because
+// the sender left the conversation. On error nothing changes, and the message is delivered by a later call.
+func (db *appdbimpl) SendScheduledMessage(ctx context.Context, scheduledId int64, now time.Time) (*Message, error) {
+	var sent *Message
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		scheduled, err := tx.GetScheduledMessageById(ctx, scheduledId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if scheduled.SendAt.After(now) {
+			return nil
+		}
+		if _, err := tx.DeleteScheduledMessage(ctx, scheduledId); err != nil {
+			return err
+		}
+		isMember, err := tx.IsUserInConversation(ctx, scheduled.SenderId, scheduled.ConversationId)
+		if err != nil || !isMember {
+			return err
+		}
+		message, err := tx.AddMessage(ctx, scheduled.ConversationId, scheduled.SenderId, scheduled.Text, "received",
+			scheduled.Type, scheduled.Photo)
+		if err != nil {
+			return err
+		}
+		sent = &message
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sent, nil
+}
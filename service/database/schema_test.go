@@ -0,0 +1,52 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// TestForeignKeys checks that foreignKeys, which PostgreSQL adds to its tables, lists the foreign keys that SQLite
+// creates with the tables, so that both engines enforce the same ones.
+func TestForeignKeys(t *testing.T) {
+	sqlDB, err := sql.Open("sqlite3", DefaultSQLiteConfig.DSN(filepath.Join(t.TempDir(), "test.db"), false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	if _, err := New(sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := sqlDB.Query(`
+		SELECT m.name, f."from", f."table", f."to", f.on_delete = 'CASCADE'
+		FROM sqlite_master m, pragma_foreign_key_list(m.name) f
+		WHERE m.type = 'table'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	sqlite := make(map[foreignKey]bool)
+	for rows.Next() {
+		var key foreignKey
+		if err := rows.Scan(&key.table, &key.column, &key.refTable, &key.refColumn, &key.cascade); err != nil {
+			t.Fatal(err)
+		}
+		sqlite[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	listed := make(map[foreignKey]bool)
+	for _, key := range foreignKeys {
+		listed[key] = true
+		if !sqlite[key] {
+			t.Errorf("%+v is not a foreign key of the SQLite schema", key)
+		}
+	}
+	for key := range sqlite {
+		if !listed[key] {
+			t.Errorf("%+v is missing from foreignKeys", key)
+		}
+	}
+}
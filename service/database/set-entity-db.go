@@ -1,28 +1,38 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
 )
 
 // Funzione per aggiungere un commento a un messaggio.
 // Si collega agli handler commentMessage e uncommentMessage.
-func (db *appdbimpl) AddCommentToMessage(messageId int64, senderId int64, content string) (Comment, error) {
-	sender, err := db.GetUserById(senderId)
+// Ogni utente può reagire una sola volta con la stessa emoji: se la reazione esiste già viene restituita quella.
+func (db *appdbimpl) AddCommentToMessage(ctx context.Context, messageId int64, senderId int64, content string) (Comment, error) {
+	sender, err := db.GetUserById(ctx, senderId)
 	if err != nil {
 		return Comment{}, fmt.Errorf("errore recupero sender: %w", err)
 	}
 
-	result, err := db.c.Exec(
-		"INSERT INTO comments (message_id, sender_id, content) VALUES (?, ?, ?)",
+	_, err = db.q.ExecContext(ctx, `
+		INSERT INTO comments (message_id, sender_id, content) VALUES (?, ?, ?)
+		ON CONFLICT (message_id, sender_id, content) DO NOTHING`,
 		messageId, senderId, content,
 	)
 	if err != nil {
 		return Comment{}, fmt.Errorf("errore inserimento commento: %w", err)
 	}
 
-	commentId, err := result.LastInsertId()
+	var commentId int64
+	err = db.q.QueryRowContext(ctx, `
+		SELECT comment_id FROM comments
+		WHERE message_id = ? AND sender_id = ? AND content = ?`,
+		messageId, senderId, content,
+	).Scan(&commentId)
 	if err != nil {
 		return Comment{}, fmt.Errorf("errore recupero id commento: %w", err)
 	}
@@ -34,8 +44,8 @@ func (db *appdbimpl) AddCommentToMessage(messageId int64, senderId int64, conten
 	}, nil
 }
 
-func (db *appdbimpl) AddUserToGroup(conversationId int64, userId int64) error {
-	_, err := db.c.Exec(
+func (db *appdbimpl) AddUserToGroup(ctx context.Context, conversationId int64, userId int64) error {
+	_, err := db.q.ExecContext(ctx,
 		`INSERT INTO conversation_members (conversation_id, user_id) VALUES (?, ?)`,
 		conversationId, userId,
 	)
@@ -46,23 +56,12 @@ func (db *appdbimpl) AddUserToGroup(conversationId int64, userId int64) error {
 }
 
 // CreateConversation creates a new conversation between two users
-func (db *appdbimpl) CreateConversation(user1Id, user2Id int64, conversationType string) (Conversation, error) {
-	// Start a transaction to ensure atomicity
-	tx, err := db.c.Begin()
-	if err != nil {
-		return Conversation{}, fmt.Errorf("error starting transaction: %w", err)
-	}
-	defer func() { // Ensure rollback in case of failure
-		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
-			fmt.Errorf("error rolling back transaction: %w", err)
-		}
-	}()
-
+func (db *appdbimpl) CreateConversation(ctx context.Context, user1Id, user2Id int64, conversationType string) (Conversation, error) {
 	// Determine the name of the conversation based on type
 	var conversationName string
 	if conversationType == "direct" {
 		// Get the target user's name for direct conversation
-		targetUser, err := db.GetUserById(user2Id)
+		targetUser, err := db.GetUserById(ctx, user2Id)
 		if err != nil {
 			return Conversation{}, fmt.Errorf("error fetching target user: %w", err)
 		}
@@ -71,37 +70,34 @@ func (db *appdbimpl) CreateConversation(user1Id, user2Id int64, conversationType
 		conversationName = "New Conversation"
 	}
 
-	// Create the new conversation
-	result, err := tx.Exec(`
-		INSERT INTO conversations (name, last_message_id, type)
-		VALUES (?, ?, ?)
-	`, conversationName, nil, conversationType)
-	if err != nil {
-		return Conversation{}, fmt.Errorf("error inserting conversation: %w", err)
-	}
-
-	// Get the conversation ID
-	conversationId, err := result.LastInsertId()
-	if err != nil {
-		return Conversation{}, fmt.Errorf("error retrieving conversation ID: %w", err)
-	}
+	// The conversation and its members are created in a single transaction
+	var conversationId int64
+	err := db.withTx(ctx, func(tx *appdbimpl) error {
+		var err error
+		conversationId, err = tx.q.Insert(ctx, "conversation_id", `
+			INSERT INTO conversations (name, last_message_id, type)
+			VALUES (?, ?, ?)
+		`, conversationName, nil, conversationType)
+		if err != nil {
+			return fmt.Errorf("error inserting conversation: %w", err)
+		}
 
-	// Add the two users to the conversation
-	_, err = tx.Exec(`
-		INSERT INTO conversation_members (conversation_id, user_id)
-		VALUES (?, ?), (?, ?)
-	`, conversationId, user1Id, conversationId, user2Id)
+		// Add the two users to the conversation
+		_, err = tx.q.ExecContext(ctx, `
+			INSERT INTO conversation_members (conversation_id, user_id)
+			VALUES (?, ?), (?, ?)
+		`, conversationId, user1Id, conversationId, user2Id)
+		if err != nil {
+			return fmt.Errorf("error adding users to conversation: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		return Conversation{}, fmt.Errorf("error adding users to conversation: %w", err)
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		return Conversation{}, fmt.Errorf("error committing transaction: %w", err)
+		return Conversation{}, err
 	}
 
 	// Fetch the conversation
-	conversation, err := db.GetConversationById(conversationId)
+	conversation, err := db.GetConversationById(ctx, conversationId)
 	if err != nil {
 		return Conversation{}, fmt.Errorf("error fetching conversation details: %w", err)
 	}
@@ -112,8 +108,8 @@ func (db *appdbimpl) CreateConversation(user1Id, user2Id int64, conversationType
 // Si collegano agli handler addToGroup, leaveGroup, setGroupName, getGroupMembers.
 // Esempio: AddUserToGroup viene chiamata da addToGroup in api/put-user-to-group.go.
 
-func (db *appdbimpl) CreateGroup(conversation Conversation) error {
-	_, err := db.c.Exec(
+func (db *appdbimpl) CreateGroup(ctx context.Context, conversation Conversation) error {
+	_, err := db.q.ExecContext(ctx,
 		`INSERT INTO conversations (name, photo_url) VALUES (?, ?)`,
 		conversation.Name, conversation.Photo,
 	)
@@ -124,63 +120,71 @@ func (db *appdbimpl) CreateGroup(conversation Conversation) error {
 }
 
 // CreateUser create a new user in the database
-func (db *appdbimpl) CreateUser(name string) (User, error) {
-	result, err := db.c.Exec("INSERT INTO users (name) VALUES (?)", name)
+func (db *appdbimpl) CreateUser(ctx context.Context, name string) (User, error) {
+	userId, err := db.q.Insert(ctx, "id", "INSERT INTO users (name) VALUES (?)", name)
 	if err != nil {
 		return User{}, fmt.Errorf("error inserting user: %w", err)
 	}
 
-	userId, err := result.LastInsertId()
-	if err != nil {
-		return User{}, fmt.Errorf("error retrieving last insert id: %w", err)
-	}
-
 	return User{
 		UserId: userId,
 	}, nil
 }
 
-// ReplyMessage adds a reply to an existing message with either text or a photo.
-func (db *appdbimpl) ReplyMessage(conversationId int64, senderId int64, replyMessageId int64, text string, status string, messageType string, photo []byte) (Message, error) {
-	sender, err := db.GetUserById(senderId)
+// ReplyMessage adds a reply to an existing message with either text or a photo. The message, the last message of the
+// conversation and the mentions are saved in a single transaction.
+func (db *appdbimpl) ReplyMessage(ctx context.Context, conversationId int64, senderId int64, replyMessageId int64, text string, status string, messageType string, photo []byte) (Message, error) {
+	sender, err := db.GetUserById(ctx, senderId)
 	if err != nil {
 		return Message{}, fmt.Errorf("error fetching sender details: %w", err)
 	}
 
-	timestamp := time.Now()
-	var result sql.Result
-
-	if messageType == "photo" {
-		// Handle photo reply message
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, reply_to_message_id, photo) 
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, timestamp, "", conversationId, senderId, status, "reply", replyMessageId, photo)
-	} else {
-		// Handle text reply message
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, reply_to_message_id) 
-			VALUES (?, ?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "reply", replyMessageId)
-	}
+	timestamp := globaltime.Now()
+	var messageId int64
+	var expiresAt sql.NullTime
+	var msg Message
+	err = db.withTx(ctx, func(tx *appdbimpl) error {
+		var err error
+		expiresAt, err = messageExpiration(ctx, tx.q, conversationId, timestamp)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return Message{}, fmt.Errorf("error inserting reply message: %w", err)
-	}
+		if messageType == "photo" {
+			// Handle photo reply message
+			messageId, err = tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, reply_to_message_id, photo, expires_at) 
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, timestamp, "", conversationId, senderId, status, "reply", replyMessageId, photo, expiresAt)
+		} else {
+			// Handle text reply message
+			messageId, err = tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, reply_to_message_id, expires_at) 
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "reply", replyMessageId, expiresAt)
+		}
+		if err != nil {
+			return fmt.Errorf("error inserting reply message: %w", err)
+		}
 
-	messageId, err := result.LastInsertId()
-	if err != nil {
-		return Message{}, fmt.Errorf("error retrieving last insert id: %w", err)
-	}
+		// Update the conversation's last_message_id
+		if err := tx.UpdateLastMessageId(ctx, conversationId, messageId); err != nil {
+			return fmt.Errorf("error updating last_message_id: %w", err)
+		}
 
-	// Update the conversation's last_message_id
-	if err := db.UpdateLastMessageId(conversationId, messageId); err != nil {
-		return Message{}, fmt.Errorf("error updating last_message_id: %w", err)
-	}
+		// Bring the conversation back from the archive of the members that did not mute it
+		if err := unarchiveOnNewMessage(ctx, tx.q, conversationId, timestamp); err != nil {
+			return err
+		}
 
-	var msg Message
-	if messageType == "photo" {
-		msg.Photo = photo
-	} else {
+		if messageType == "photo" {
+			msg.Photo = photo
+			return nil
+		}
 		msg.Text = text
+		msg.Mentions, err = tx.saveMentions(ctx, messageId, conversationId, text)
+		return err
+	})
+	if err != nil {
+		return Message{}, err
 	}
 
 	return Message{
@@ -192,114 +196,158 @@ func (db *appdbimpl) ReplyMessage(conversationId int64, senderId int64, replyMes
 		Type:             "reply",
 		ReplyToMessageId: &replyMessageId,
 		Photo:            msg.Photo,
+		ConversationId:   conversationId,
+		Mentions:         msg.Mentions,
+		ExpiresAt:        nullTimePtr(expiresAt),
 	}, nil
 }
 
-// ForwardMessage forward a message into a conversation.
-func (db *appdbimpl) ForwardMessage(userId int64, originalMessage Message, targetConversationId int64) (Message, error) {
+// ForwardMessage forwards a message into one or more conversations. All the copies are inserted in a single
+// transaction, so either every target receives the message or none does. Each copy records the original sender,
+// conversation and timestamp of the message (or of the first message, when forwarding a forward).
+func (db *appdbimpl) ForwardMessage(ctx context.Context, userId int64, originalMessage Message, targetConversationIds []int64) ([]Message, error) {
 	// Retrieve sender details
-	sender, err := db.GetUserById(userId)
-	if err != nil {
-		return Message{}, fmt.Errorf("error retrieving sender: %w", err)
-	}
-	timestamp := time.Now()
-	// Prepare the query to insert the forwarded message
-	var result sql.Result
-	if originalMessage.Photo != nil {
-		// If the original message has a photo, include it in the insert
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, photo)
-			VALUES (?, ?, ?, ?, ?, ?, ?)`,
-			timestamp, originalMessage.Text, targetConversationId, userId, "received", "forward", originalMessage.Photo)
-	} else {
-		// If no photo, proceed with only the text content
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type)
-			VALUES (?, ?, ?, ?, ?, ?)`,
-			timestamp, originalMessage.Text, targetConversationId, userId, "received", "forward")
-	}
+	sender, err := db.GetUserById(ctx, userId)
 	if err != nil {
-		return Message{}, fmt.Errorf("error forwarding message: %w", err)
-	}
-
-	newMessageId, err := result.LastInsertId()
-	if err != nil {
-		return Message{}, fmt.Errorf("error retrieving last insert id: %w", err)
-	}
-
-	// Update the last_message_id of the target conversation
-	if err := db.UpdateLastMessageId(targetConversationId, newMessageId); err != nil {
-		return Message{}, fmt.Errorf("error updating last_message_id for target conversation: %w", err)
+		return nil, fmt.Errorf("error retrieving sender: %w", err)
 	}
 
-	// Create the new forwarded message
-	forwardedMessage := Message{
-		MessageId: newMessageId,
-		Timestamp: timestamp,
-		Text:      originalMessage.Text,
-		Sender:    sender,
-		Status:    "received",
-		Type:      "forward",
+	// Keep the attribution of the first message in the chain
+	origin := originalMessage.ForwardedFrom
+	if origin == nil {
+		origin = &ForwardInfo{
+			Sender:         &originalMessage.Sender,
+			ConversationId: originalMessage.ConversationId,
+			Timestamp:      originalMessage.Timestamp,
+		}
 	}
-
-	// If the original message contained a photo, include it in the forwarded message
-	if originalMessage.Photo != nil {
-		forwardedMessage.Photo = originalMessage.Photo
+	var originSenderId sql.NullInt64
+	if origin.Sender != nil {
+		originSenderId = sql.NullInt64{Int64: origin.Sender.UserId, Valid: true}
+	}
+	// The attribution is always saved; the copies returned to the user show the original author only if allowed
+	shown := *origin
+	if err := db.newForwardPrivacy(ctx, userId).apply(&shown); err != nil {
+		return nil, fmt.Errorf("error applying forward privacy: %w", err)
+	}
+
+	timestamp := globaltime.Now()
+	forwardedMessages := make([]Message, 0, len(targetConversationIds))
+	err = db.withTx(ctx, func(tx *appdbimpl) error {
+		for _, targetConversationId := range targetConversationIds {
+			expiresAt, err := messageExpiration(ctx, tx.q, targetConversationId, timestamp)
+			if err != nil {
+				return err
+			}
+			newMessageId, err := tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, photo,
+					forwarded_from_sender_id, forwarded_from_conversation_id, forwarded_from_timestamp, expires_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				timestamp, originalMessage.Text, targetConversationId, userId, "received", "forward", originalMessage.Photo,
+				originSenderId, origin.ConversationId, origin.Timestamp, expiresAt)
+			if err != nil {
+				return fmt.Errorf("error forwarding message to conversation %d: %w", targetConversationId, err)
+			}
+
+			// Update the last_message_id of the target conversation
+			if _, err := tx.q.ExecContext(ctx, `
+				UPDATE conversations
+				SET last_message_id = ?
+				WHERE conversation_id = ?`, newMessageId, targetConversationId); err != nil {
+				return fmt.Errorf("error updating last_message_id for target conversation: %w", err)
+			}
+			if err := unarchiveOnNewMessage(ctx, tx.q, targetConversationId, timestamp); err != nil {
+				return err
+			}
+
+			forwardedMessages = append(forwardedMessages, Message{
+				MessageId:      newMessageId,
+				Timestamp:      timestamp,
+				Text:           originalMessage.Text,
+				Photo:          originalMessage.Photo,
+				Sender:         sender,
+				Status:         "received",
+				Type:           "forward",
+				ConversationId: targetConversationId,
+				ForwardedFrom:  &shown,
+				ExpiresAt:      nullTimePtr(expiresAt),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return forwardedMessage, nil
+	return forwardedMessages, nil
 }
 
-// AddMessage adds a new message to the database.
-func (db *appdbimpl) AddMessage(conversationId int64, senderId int64, text string, status string, messageType string, photo []byte) (Message, error) {
-	sender, err := db.GetUserById(senderId)
+// AddMessage adds a new message to the database. The message, the last message of the conversation and the mentions
+// are saved in a single transaction.
+func (db *appdbimpl) AddMessage(ctx context.Context, conversationId int64, senderId int64, text string, status string, messageType string, photo []byte) (Message, error) {
+	sender, err := db.GetUserById(ctx, senderId)
 	if err != nil {
 		return Message{}, fmt.Errorf("error fetching sender details: %w", err)
 	}
 
-	timestamp := time.Now()
-	var result sql.Result
-	if messageType == "photo" {
-		// Handle photo message
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, photo) 
-			VALUES (?, ?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "standard", photo)
-	} else {
-		// Handle text message
-		result, err = db.c.Exec(`
-			INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type) 
-			VALUES (?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "standard")
-	}
+	timestamp := globaltime.Now()
+	var messageId int64
+	var expiresAt sql.NullTime
+	var msg Message
+	err = db.withTx(ctx, func(tx *appdbimpl) error {
+		var err error
+		expiresAt, err = messageExpiration(ctx, tx.q, conversationId, timestamp)
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return Message{}, fmt.Errorf("error inserting message: %w", err)
-	}
+		if messageType == "photo" {
+			// Handle photo message
+			messageId, err = tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, photo, expires_at) 
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "standard", photo, expiresAt)
+		} else {
+			// Handle text message
+			messageId, err = tx.q.Insert(ctx, "message_id", `
+				INSERT INTO messages (timestamp, text, conversation_id, sender_id, status, type, expires_at) 
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, timestamp, text, conversationId, senderId, status, "standard", expiresAt)
+		}
+		if err != nil {
+			return fmt.Errorf("error inserting message: %w", err)
+		}
 
-	messageId, err := result.LastInsertId()
-	if err != nil {
-		return Message{}, fmt.Errorf("error retrieving last insert id: %w", err)
-	}
+		// Update the conversation's last_message_id
+		if err := tx.UpdateLastMessageId(ctx, conversationId, messageId); err != nil {
+			return fmt.Errorf("error updating last_message_id: %w", err)
+		}
 
-	// Update the conversation's last_message_id
-	if err := db.UpdateLastMessageId(conversationId, messageId); err != nil {
-		return Message{}, fmt.Errorf("error updating last_message_id: %w", err)
-	}
+		// Bring the conversation back from the archive of the members that did not mute it
+		if err := unarchiveOnNewMessage(ctx, tx.q, conversationId, timestamp); err != nil {
+			return err
+		}
 
-	var msg Message
-	if messageType == "photo" {
-		msg.Photo = photo
-	} else {
-		msg.Text = text
+		if messageType == "photo" {
+			msg.Photo = photo
+			return nil
+		}
+		msg.Mentions, err = tx.saveMentions(ctx, messageId, conversationId, text)
+		return err
+	})
+	if err != nil {
+		return Message{}, err
 	}
 
 	return Message{
-		MessageId: messageId,
-		Timestamp: timestamp,
-		Text:      text,
-		Sender:    sender,
-		Status:    status,
-		Type:      "standard",
-		Photo:     msg.Photo,
+		MessageId:      messageId,
+		Timestamp:      timestamp,
+		Text:           text,
+		Sender:         sender,
+		Status:         status,
+		Type:           "standard",
+		Photo:          msg.Photo,
+		ConversationId: conversationId,
+		Mentions:       msg.Mentions,
+		ExpiresAt:      nullTimePtr(expiresAt),
 	}, nil
 }
 
@@ -319,6 +367,10 @@ func ParseTimestamp(datetimeStr string) (time.Time, error) {
 	// Timestamp format that includes the timezone
 	const layout = "2006-01-02 15:04:05.999999999-07:00"
 	parsedTime, err := time.Parse(layout, datetimeStr)
+	if err != nil {
+		// PostgreSQL returns real timestamps, which database/sql turns into RFC 3339 strings
+		parsedTime, err = time.Parse(time.RFC3339Nano, datetimeStr)
+	}
 	if err != nil {
 		// Return an error if parsing fails
 		return time.Time{}, fmt.Errorf("error parsing timestamp: %w", err)
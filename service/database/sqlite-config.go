@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig describes how the connections to a SQLite database are set up, see OpenSQLite.
+type SQLiteConfig struct {
+	// JournalMode is the journal mode of the database file, such as WAL, where the readers don't wait for the writers.
+	// An empty value leaves the one of the file unchanged.
+	JournalMode string
+	// Synchronous is how much SQLite waits for the data to reach the disk: OFF, NORMAL, FULL or EXTRA. An empty value
+	// leaves the SQLite default, FULL.
+	Synchronous string
+	// BusyTimeout is how long a statement waits for a lock held by another connection, or another process, before
+	// failing with "database is locked".
+	BusyTimeout time.Duration
+	// ForeignKeys enables the enforcement of the foreign keys of the schema, which SQLite leaves off by default.
+	ForeignKeys bool
+	// MaxOpenConns is the number of connections of the pool that writes (0 means no limit). SQLite has a single
+	// writer at a time, so with 1 the writers of the process queue in the pool instead of competing for the lock.
+	MaxOpenConns int
+	// ReadPool opens a second pool of read only connections, used by the queries outside transactions, so that they
+	// don't queue behind the writers.
+	ReadPool bool
+	// MaxReadConns is the number of connections of the read pool (0 means no limit).
+	MaxReadConns int
+}
+
+// DefaultSQLiteConfig are the settings used by the command line tools, the same as the defaults of webapi: WAL, with
+// NORMAL synchronous (safe in WAL mode: a power loss can only lose the last transactions), foreign keys, a single
+// writer and a read pool.
+var DefaultSQLiteConfig = SQLiteConfig{
+	JournalMode:  "WAL",
+	Synchronous:  "NORMAL",
+	BusyTimeout:  5 * time.Second,
+	ForeignKeys:  true,
+	MaxOpenConns: 1,
+	ReadPool:     true,
+}
+
+// DSN returns the data source name opening the file `filename` with the settings of c, for the github.com/mattn/go-sqlite3
+// driver. The read only connections skip the settings that only matter to the writers. Writers start their
+// transactions with BEGIN IMMEDIATE, taking the write lock at once: a transaction that reads first and then needs the
+// lock held by another process fails instead of waiting for it.
+func (c SQLiteConfig) DSN(filename string, readOnly bool) string {
+	params := url.Values{}
+	if c.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(c.BusyTimeout.Milliseconds(), 10))
+	}
+	if readOnly {
+		params.Set("mode", "ro")
+	} else {
+		if c.JournalMode != "" {
+			params.Set("_journal_mode", c.JournalMode)
+		}
+		if c.Synchronous != "" {
+			params.Set("_synchronous", c.Synchronous)
+		}
+		if c.ForeignKeys {
+			params.Set("_foreign_keys", "1")
+		}
+		params.Set("_txlock", "immediate")
+	}
+	return "file:" + filename + "?" + params.Encode()
+}
+
+// OpenSQLite opens the SQLite database in the file `filename` with the settings of cfg. It returns the pool that
+// writes and, if cfg.ReadPool is set, the read only one (otherwise nil): pass both to NewSQLite. The file is created
+// if it doesn't exist.
+func OpenSQLite(ctx context.Context, filename string, cfg SQLiteConfig) (*sql.DB, *sql.DB, error) {
+	writer, err := sql.Open("sqlite3", cfg.DSN(filename, false))
+	if err != nil {
+		return nil, nil, err
+	}
+	writer.SetMaxOpenConns(cfg.MaxOpenConns)
+	// The first connection creates the file and switches its journal mode, before the read only ones open it
+	if err := writer.PingContext(ctx); err != nil {
+		_ = writer.Close()
+		return nil, nil, err
+	}
+	if !cfg.ReadPool {
+		return writer, nil, nil
+	}
+	reader, err := sql.Open("sqlite3", cfg.DSN(filename, true))
+	if err != nil {
+		_ = writer.Close()
+		return nil, nil, err
+	}
+	reader.SetMaxOpenConns(cfg.MaxReadConns)
+	return writer, reader, nil
+}
+
+// SQLiteReport are the settings in effect on a SQLite database, as read back from SQLite.
+type SQLiteReport struct {
+	JournalMode  string
+	Synchronous  string
+	BusyTimeout  time.Duration
+	ForeignKeys  bool
+	MaxOpenConns int
+	ReadPool     bool
+	MaxReadConns int
+	// ForeignKeyViolations is the number of rows referencing a missing row, left by the versions that didn't enforce
+	// the foreign keys. The statements changing them fail until they are repaired.
+	ForeignKeyViolations int
+}
+
+// synchronousNames are the values of the synchronous setting, in the order of the numbers returned by PRAGMA.
+var synchronousNames = []string{"OFF", "NORMAL", "FULL", "EXTRA"}
+
+// ReportSQLite reads the settings in effect on the pools returned by OpenSQLite; reader can be nil.
+func ReportSQLite(ctx context.Context, writer *sql.DB, reader *sql.DB) (SQLiteReport, error) {
+	report := SQLiteReport{
+		MaxOpenConns: writer.Stats().MaxOpenConnections,
+		ReadPool:     reader != nil,
+	}
+	if reader != nil {
+		report.MaxReadConns = reader.Stats().MaxOpenConnections
+	}
+
+	// The settings are per connection: they are read on a single one of the pool
+	c, err := writer.Conn(ctx)
+	if err != nil {
+		return report, err
+	}
+	defer c.Close()
+	var synchronous int
+	var busyTimeout int64
+	if err := c.QueryRowContext(ctx, `PRAGMA journal_mode`).Scan(&report.JournalMode); err != nil {
+		return report, fmt.Errorf("error reading journal mode: %w", err)
+	}
+	report.JournalMode = strings.ToUpper(report.JournalMode)
+	if err := c.QueryRowContext(ctx, `PRAGMA synchronous`).Scan(&synchronous); err != nil {
+		return report, fmt.Errorf("error reading synchronous: %w", err)
+	}
+	report.Synchronous = strconv.Itoa(synchronous)
+	if synchronous >= 0 && synchronous < len(synchronousNames) {
+		report.Synchronous = synchronousNames[synchronous]
+	}
+	if err := c.QueryRowContext(ctx, `PRAGMA busy_timeout`).Scan(&busyTimeout); err != nil {
+		return report, fmt.Errorf("error reading busy timeout: %w", err)
+	}
+	report.BusyTimeout = time.Duration(busyTimeout) * time.Millisecond
+	if err := c.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&report.ForeignKeys); err != nil {
+		return report, fmt.Errorf("error reading foreign keys: %w", err)
+	}
+
+	rows, err := c.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return report, fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		report.ForeignKeyViolations++
+	}
+	if err := rows.Err(); err != nil {
+		return report, fmt.Errorf("error checking foreign keys: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,89 @@
+package database_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Mortifer97/WASAText/service/database"
+)
+
+// TestSmallPools runs the methods making nested queries on pools with a single connection: none of them may wait for
+// a connection held by its own open cursor.
+func TestSmallPools(t *testing.T) {
+	for _, cfg := range []struct {
+		name string
+		cfg  func(c *database.SQLiteConfig)
+	}{
+		{"no read pool", func(c *database.SQLiteConfig) { c.ReadPool = false }},
+		{"one read connection", func(c *database.SQLiteConfig) { c.MaxReadConns = 1 }},
+	} {
+		cfg := cfg
+		t.Run(cfg.name, func(t *testing.T) {
+			config := database.DefaultSQLiteConfig
+			cfg.cfg(&config)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			writer, reader, err := database.OpenSQLite(ctx, filepath.Join(t.TempDir(), "test.db"), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				if reader != nil {
+					_ = reader.Close()
+				}
+				_ = writer.Close()
+			}()
+			db, err := database.NewSQLite(writer, reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			alice, err := db.CreateUser(ctx, "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			errs := make(chan error, 4)
+			for i := 0; i < 4; i++ {
+				other, err := db.CreateUser(ctx, fmt.Sprintf("user%d", i))
+				if err != nil {
+					t.Fatal(err)
+				}
+				conversation, err := db.CreateConversation(ctx, alice.UserId, other.UserId, "direct")
+				if err != nil {
+					t.Fatal(err)
+				}
+				message, err := db.AddMessage(ctx, conversation.ConversationId, other.UserId, "hi @alice", "received", "text", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.AddCommentToMessage(ctx, message.MessageId, alice.UserId, "👍"); err != nil {
+					t.Fatal(err)
+				}
+				wg.Add(1)
+				go func(conversationId int64) {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						if _, err := db.GetConversationsByUser(ctx, alice.UserId, "desc", false); err != nil {
+							errs <- fmt.Errorf("listing conversations: %w", err)
+							return
+						}
+						if _, err := db.GetMessagesByConversation(ctx, alice.UserId, conversationId, "asc"); err != nil {
+							errs <- fmt.Errorf("listing messages: %w", err)
+							return
+						}
+					}
+				}(conversation.ConversationId)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Fatal(err)
+			}
+		})
+	}
+}
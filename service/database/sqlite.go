@@ -0,0 +1,64 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// sqliteDialect is the dialect of SQLite, the engine the queries are written for.
+type sqliteDialect struct{}
+
+func (sqliteDialect) name() string {
+	return "SQLite"
+}
+
+func (sqliteDialect) translate(query string) string {
+	return query
+}
+
+func (sqliteDialect) convert(args []interface{}) []interface{} {
+	return args
+}
+
+func (sqliteDialect) insert(ctx context.Context, e execer, idColumn string, query string, args []interface{}) (int64, error) {
+	result, err := e.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving last insert id: %w", err)
+	}
+	return id, nil
+}
+
+func (sqliteDialect) tableExists(ctx context.Context, c *conn, table string) (bool, error) {
+	var exists bool
+	err := c.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`, table).Scan(&exists)
+	return exists, err
+}
+
+func (sqliteDialect) columnExists(ctx context.Context, c *conn, table string, column string) (bool, error) {
+	var exists bool
+	err := c.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)`, table, column).Scan(&exists)
+	return exists, err
+}
+
+func (sqliteDialect) size(ctx context.Context, c *conn) (int64, int64, error) {
+	var pageCount, pageSize, freePages int64
+	if err := c.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pageCount); err != nil {
+		return 0, 0, fmt.Errorf("error reading page count: %w", err)
+	}
+	if err := c.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, 0, fmt.Errorf("error reading page size: %w", err)
+	}
+	if err := c.QueryRowContext(ctx, `PRAGMA freelist_count`).Scan(&freePages); err != nil {
+		return 0, 0, fmt.Errorf("error reading free pages: %w", err)
+	}
+	return pageCount * pageSize, freePages * pageSize, nil
+}
+
+// addForeignKeys does nothing: SQLite creates the foreign keys with the tables, and can't add them to a table later.
+func (sqliteDialect) addForeignKeys(context.Context, *conn, []foreignKey) error {
+	return nil
+}
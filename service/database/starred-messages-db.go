@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
+)
+
+// Funzioni per la raccolta personale di messaggi salvati (starred).
+// Si collegano agli handler starMessage, unstarMessage e getStarredMessages.
+
+// GetMessageConversationId returns the conversation a message belongs to.
+func (db *appdbimpl) GetMessageConversationId(ctx context.Context, messageId int64) (int64, error) {
+	var conversationId int64
+	err := db.q.QueryRowContext(ctx, `
+		SELECT conversation_id
+		FROM messages
+		WHERE message_id = ? AND (expires_at IS NULL OR expires_at > ?)`, messageId, globaltime.Now().UTC()).Scan(&conversationId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("message not found: %w", err)
+		}
+		return 0, fmt.Errorf("error retrieving message conversation: %w", err)
+	}
+	return conversationId, nil
+}
+
+// StarMessage adds a message to the starred messages of a user. Starring a message twice has no effect.
+func (db *appdbimpl) StarMessage(ctx context.Context, userId int64, messageId int64) error {
+	_, err := db.q.ExecContext(ctx, `
+		INSERT INTO starred_messages (user_id, message_id, starred_at) VALUES (?, ?, ?)
+		ON CONFLICT (user_id, message_id) DO NOTHING`, userId, messageId, globaltime.Now())
+	if err != nil {
+		return fmt.Errorf("error starring message: %w", err)
+	}
+	return nil
+}
+
+// UnstarMessage removes a message from the starred messages of a user.
+func (db *appdbimpl) UnstarMessage(ctx context.Context, userId int64, messageId int64) error {
+	_, err := db.q.ExecContext(ctx, `DELETE FROM starred_messages WHERE user_id = ? AND message_id = ?`, userId, messageId)
+	if err != nil {
+		return fmt.Errorf("error unstarring message: %w", err)
+	}
+	return nil
+}
+
+// GetStarredMessages retrieves a page of the starred messages of a user, most recently starred first. Messages of
+// conversations the user no longer belongs to are never returned.
+func (db *appdbimpl) GetStarredMessages(ctx context.Context, userId int64, limit int, offset int) ([]StarredMessage, error) {
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT s.message_id, m.conversation_id, s.starred_at
+		FROM starred_messages s
+		INNER JOIN messages m ON m.message_id = s.message_id
+		INNER JOIN conversation_members cm ON cm.conversation_id = m.conversation_id AND cm.user_id = s.user_id
+		WHERE s.user_id = ? AND (m.expires_at IS NULL OR m.expires_at > ?)
+		ORDER BY s.starred_at DESC
+		LIMIT ? OFFSET ?`, userId, globaltime.Now().UTC(), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving starred messages: %w", err)
+	}
+	defer rows.Close()
+
+	var starred []StarredMessage
+	for rows.Next() {
+		var item StarredMessage
+		var messageId, conversationId int64
+		if err := rows.Scan(&messageId, &conversationId, &item.StarredAt); err != nil {
+			return nil, fmt.Errorf("error scanning starred message: %w", err)
+		}
+		item.Message.MessageId = messageId
+		item.Conversation.ConversationId = conversationId
+		starred = append(starred, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration error: %w", err)
+	}
+
+	// Load the messages and their conversation context
+	for i := range starred {
+		conversationId := starred[i].Conversation.ConversationId
+		starred[i].Message, err = db.GetMessageById(ctx, starred[i].Message.MessageId, conversationId)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving starred message: %w", err)
+		}
+		starred[i].Conversation, err = db.GetConversationSummary(ctx, conversationId, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return starred, nil
+}
+
+// GetConversationSummary retrieves id, name, photo and type of a conversation as seen by `userId`: direct
+// conversations take the name and photo of the other member, as in GetConversationsByUser.
+func (db *appdbimpl) GetConversationSummary(ctx context.Context, conversationId int64, userId int64) (Conversation, error) {
+	var conversation Conversation
+	var photo []byte
+	err := db.q.QueryRowContext(ctx, `
+		SELECT conversation_id, name, photo, type
+		FROM conversations
+		WHERE conversation_id = ?`, conversationId).Scan(
+		&conversation.ConversationId, &conversation.Name, &photo, &conversation.Type)
+	if err != nil {
+		return Conversation{}, fmt.Errorf("error retrieving conversation %d: %w", conversationId, err)
+	}
+	if len(photo) > 0 {
+		conversation.Photo = photo
+	}
+	if conversation.Type == "direct" {
+		otherUser, err := db.GetOtherUserInConversation(ctx, conversationId, userId)
+		if err != nil {
+			return Conversation{}, fmt.Errorf("errore recupero altro utente nella conversazione diretta: %w", err)
+		}
+		if err := db.newPhotoPrivacy(ctx, userId).apply(otherUser); err != nil {
+			return Conversation{}, fmt.Errorf("errore privacy foto: %w", err)
+		}
+		conversation.Name = otherUser.ShownName()
+		if len(otherUser.Photo) > 0 {
+			conversation.Photo = otherUser.Photo
+		}
+	}
+	return conversation, nil
+}
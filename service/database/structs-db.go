@@ -6,15 +6,151 @@ import (
 
 // Message represents a single message in a conversation.
 type Message struct {
-	MessageId        int64     `json:"id"`
-	Timestamp        time.Time `json:"timestamp"`
-	Text             string    `json:"text,omitempty"`
-	Photo            []byte    `json:"photo,omitempty"`
-	Sender           User      `json:"sender"`
-	Status           string    `json:"status"`
-	Comments         []Comment `json:"comments,omitempty"`
-	Type             string    `json:"type"`
-	ReplyToMessageId *int64    `json:"replyToMessageId,omitempty"`
+	MessageId        int64        `json:"id"`
+	Timestamp        time.Time    `json:"timestamp"`
+	Text             string       `json:"text,omitempty"`
+	Photo            []byte       `json:"photo,omitempty"`
+	Sender           User         `json:"sender"`
+	Status           string       `json:"status"`
+	Comments         []Comment    `json:"comments,omitempty"`
+	Type             string       `json:"type"`
+	ReplyToMessageId *int64       `json:"replyToMessageId,omitempty"`
+	ConversationId   int64        `json:"conversationId,omitempty"`
+	ForwardedFrom    *ForwardInfo `json:"forwardedFrom,omitempty"`
+	Reactions        []Reaction   `json:"reactions,omitempty"`
+	Mentions         []Mention    `json:"mentions,omitempty"`
+	ExpiresAt        *time.Time   `json:"expiresAt,omitempty"`
+}
+
+// Mention is an @username reference to a conversation member inside the text of a message. Offset and Length are
+// expressed in Unicode code points and include the leading '@'.
+type Mention struct {
+	UserId   int64  `json:"userId"`
+	Username string `json:"username"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+// Reaction aggregates the reactions with the same emoji on a message.
+type Reaction struct {
+	Emoji       string `json:"emoji"`
+	Count       int    `json:"count"`
+	ReactedByMe bool   `json:"reactedByMe"`
+}
+
+// ForwardInfo describes where a forwarded message originally came from. Forwarding a forwarded message keeps the
+// attribution of the first message in the chain.
+type ForwardInfo struct {
+	// Sender is nil when the Forwards setting of the original author does not let the viewer see it.
+	Sender         *User     `json:"sender,omitempty"`
+	ConversationId int64     `json:"conversationId"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// PinnedMessage represents a message pinned in a conversation.
+type PinnedMessage struct {
+	Message  Message   `json:"message"`
+	PinnedBy User      `json:"pinnedBy"`
+	PinnedAt time.Time `json:"pinnedAt"`
+}
+
+// StarredMessage represents a message saved by a user, together with the conversation it belongs to.
+type StarredMessage struct {
+	Message      Message      `json:"message"`
+	Conversation Conversation `json:"conversation"`
+	StarredAt    time.Time    `json:"starredAt"`
+}
+
+// ScheduledMessage is a message composed now and delivered to its conversation at SendAt. Type is "text" or "photo".
+type ScheduledMessage struct {
+	ScheduledId    int64     `json:"scheduledId"`
+	ConversationId int64     `json:"conversationId"`
+	SenderId       int64     `json:"senderId"`
+	Text           string    `json:"text,omitempty"`
+	Photo          []byte    `json:"photo,omitempty"`
+	Type           string    `json:"type"`
+	SendAt         time.Time `json:"sendAt"`
+	CreatedAt      time.Time `json:"createdAt"`
+}
+
+// Status values of an ExportJob.
+const (
+	ExportPending = "pending"
+	ExportRunning = "running"
+	ExportDone    = "done"
+	ExportFailed  = "failed"
+	ExportExpired = "expired"
+)
+
+// ExportJob is a request of a user for an archive with all their data. FilePath is the archive on the server, set
+// once the job is done and cleared when the archive expires.
+type ExportJob struct {
+	JobId       int64      `json:"jobId"`
+	UserId      int64      `json:"userId"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	FilePath    string     `json:"-"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// ImportedChat is a conversation exported from another messenger, ready to be imported. Source and ExternalId
+// identify the chat in the other messenger; members and senders must already be users. ParticipantIds are the users
+// the participants of the chat were mapped to, by their key in the export, returned by GetImportedParticipants when
+// the chat is imported again.
+type ImportedChat struct {
+	Source         string
+	ExternalId     string
+	Name           string
+	Type           string
+	MemberIds      []int64
+	ParticipantIds map[string]int64
+	Messages       []ImportedMessage
+}
+
+// ImportedMessage is a message of an ImportedChat. ExternalId is unique within the chat; ReplyTo, if set, is the
+// ExternalId of the message it answers.
+type ImportedMessage struct {
+	ExternalId string
+	SenderId   int64
+	Timestamp  time.Time
+	Text       string
+	Photo      []byte
+	ReplyTo    string
+}
+
+// ImportResult summarizes an import: Created is false when the chat had already been imported, and Skipped counts
+// the messages that were already there.
+type ImportResult struct {
+	ConversationId int64 `json:"conversationId"`
+	Created        bool  `json:"created"`
+	Imported       int   `json:"imported"`
+	Skipped        int   `json:"skipped"`
+}
+
+// Statistics are the counts of the main entities of the database, and its size in bytes.
+type Statistics struct {
+	Users               int64 `json:"users"`
+	DirectConversations int64 `json:"directConversations"`
+	Groups              int64 `json:"groups"`
+	Messages            int64 `json:"messages"`
+	Photos              int64 `json:"photos"`
+	Comments            int64 `json:"comments"`
+	ScheduledMessages   int64 `json:"scheduledMessages"`
+	ImportedChats       int64 `json:"importedChats"`
+	DatabaseSize        int64 `json:"databaseSize"`
+	// FreeSpace is the part of DatabaseSize left unused by deleted data, given back by a vacuum.
+	FreeSpace int64 `json:"freeSpace"`
+}
+
+// ConversationProblem is an inconsistency in the data of a conversation. Kind is one of the Problem constants;
+// Repairable problems can be fixed by RepairConversations.
+type ConversationProblem struct {
+	ConversationId int64  `json:"conversationId"`
+	Kind           string `json:"kind"`
+	Detail         string `json:"detail"`
+	Repairable     bool   `json:"repairable"`
 }
 
 // Comment represents a comment on a message.
@@ -29,6 +165,53 @@ type User struct {
 	UserId int64  `json:"userId"`
 	Name   string `json:"name"`
 	Photo  []byte `json:"photo,omitempty"`
+	// DisplayName is the name chosen by the user to be shown in place of the username, if any.
+	DisplayName string `json:"displayName,omitempty"`
+	// Online and LastSeen are filled by the api package from the presence of the user, when the user allows it.
+	Online   bool       `json:"online,omitempty"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// ShownName returns the name to show for the user: the display name if set, the username otherwise.
+func (u User) ShownName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	return u.Name
+}
+
+// Profile is the public profile of a user.
+type Profile struct {
+	User
+	Bio    string `json:"bio"`
+	Status string `json:"status"`
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are left unchanged.
+type ProfileUpdate struct {
+	DisplayName *string `json:"displayName"`
+	Bio         *string `json:"bio"`
+	Status      *string `json:"status"`
+}
+
+// Values of the visibility settings in UserSettings: everyone, only the users sharing a group with the owner of
+// the settings, or nobody.
+const (
+	VisibilityEveryone = "everyone"
+	VisibilityGroups   = "groups"
+	VisibilityNobody   = "nobody"
+)
+
+// UserSettings are the privacy settings of a user. Each field is who can see the photo, the last seen time and the
+// read receipts of the user, add the user to groups, start a direct chat with the user and see the user as the
+// author of the messages forwarded by others.
+type UserSettings struct {
+	Photo        string `json:"photo"`
+	LastSeen     string `json:"lastSeen"`
+	ReadReceipts string `json:"readReceipts"`
+	GroupAdd     string `json:"groupAdd"`
+	DirectChat   string `json:"directChat"`
+	Forwards     string `json:"forwards"`
 }
 
 // Conversation represent a conversation object
@@ -38,6 +221,42 @@ type Conversation struct {
 	Photo          []byte       `json:"photo,omitempty"`
 	LastMessage    *LastMessage `json:"lastMessage,omitempty"`
 	Type           string       `json:"type"`
+	UnreadMentions int          `json:"unreadMentions"`
+	// UnreadCount is the number of messages from other members newer than the last access of the user, and
+	// FirstUnreadMessageId the oldest of them. MarkedUnread is set when the user explicitly marked the conversation
+	// as unread.
+	UnreadCount          int    `json:"unreadCount"`
+	FirstUnreadMessageId *int64 `json:"firstUnreadMessageId,omitempty"`
+	MarkedUnread         bool   `json:"markedUnread"`
+	// MessageTTL is the lifetime, in seconds, of the messages sent in the conversation (0 if they never disappear).
+	MessageTTL int64 `json:"messageTtl"`
+	// Draft is the message the user is writing in the conversation, if any.
+	Draft *Draft `json:"draft,omitempty"`
+	MemberSettings
+}
+
+// Draft is a message not yet sent, saved so that it can be resumed from another device.
+type Draft struct {
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// MemberSettings are the settings of a conversation chosen by one of its members.
+type MemberSettings struct {
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	PinOrder   *int       `json:"pinOrder,omitempty"`
+}
+
+// MemberSettingsUpdate describes a change to MemberSettings. Nil fields are left unchanged; when Muted is true a nil
+// MutedUntil mutes the conversation until it is explicitly unmuted.
+type MemberSettingsUpdate struct {
+	Muted      *bool
+	MutedUntil *time.Time
+	Archived   *bool
+	Pinned     *bool
 }
 
 // LastMessage represents the details of the last message in a conversation
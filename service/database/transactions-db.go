@@ -0,0 +1,31 @@
+package database
+
+import (
+	"context"
+	"fmt"
+)
+
+// withTx runs fn as a single unit of work: the statements that fn runs through tx, including those of the other
+// methods it calls on tx, are committed together if fn returns nil and rolled back otherwise, so that a failure
+// between two steps never leaves the database half updated. Called inside another unit of work, fn joins it, so that
+// methods built on other methods stay atomic as a whole.
+func (db *appdbimpl) withTx(ctx context.Context, fn func(tx *appdbimpl) error) error {
+	if db.tx != nil {
+		return fn(db)
+	}
+	t, err := db.c.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer func() {
+		_ = t.Rollback() // no-op once the transaction is committed
+	}()
+
+	if err := fn(&appdbimpl{c: db.c, q: t, tx: t}); err != nil {
+		return err
+	}
+	if err := t.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
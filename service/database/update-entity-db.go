@@ -1,14 +1,18 @@
 package database
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"time"
+
+	"github.com/Mortifer97/WASAText/service/globaltime"
 )
 
 // UpdateUserPhoto updates the photo for a given user
-func (db *appdbimpl) UpdateUserPhoto(userId int64, photoData []byte) error {
+func (db *appdbimpl) UpdateUserPhoto(ctx context.Context, userId int64, photoData []byte) error {
 	// Prepare the SQL query to update the user's photo
-	_, err := db.c.Exec(`
+	_, err := db.q.ExecContext(ctx, `
 		UPDATE users 
 		SET photo = ? 
 		WHERE id = ?`, photoData, userId)
@@ -20,9 +24,9 @@ func (db *appdbimpl) UpdateUserPhoto(userId int64, photoData []byte) error {
 }
 
 // UpdateGroupPhoto updates the photo for a given group
-func (db *appdbimpl) UpdateGroupPhoto(conversationId int64, photoData []byte) error {
+func (db *appdbimpl) UpdateGroupPhoto(ctx context.Context, conversationId int64, photoData []byte) error {
 	// Prepare the SQL query to update the group's photo
-	_, err := db.c.Exec(`
+	_, err := db.q.ExecContext(ctx, `
 		UPDATE conversations 
 		SET photo = ? 
 		WHERE conversation_id = ?`, photoData, conversationId)
@@ -34,8 +38,8 @@ func (db *appdbimpl) UpdateGroupPhoto(conversationId int64, photoData []byte) er
 }
 
 // UpdateGroupName change the name of an exsiting group
-func (db *appdbimpl) UpdateGroupName(conversationId int64, newName string) error {
-	_, err := db.c.Exec("UPDATE conversations SET name = ? WHERE conversation_id = ?", newName, conversationId)
+func (db *appdbimpl) UpdateGroupName(ctx context.Context, conversationId int64, newName string) error {
+	_, err := db.q.ExecContext(ctx, "UPDATE conversations SET name = ? WHERE conversation_id = ?", newName, conversationId)
 	if err != nil {
 		return fmt.Errorf("failed to update group name: %w", err)
 	}
@@ -43,24 +47,26 @@ func (db *appdbimpl) UpdateGroupName(conversationId int64, newName string) error
 }
 
 // UpdateUsername update the name of a specified user
-func (db *appdbimpl) UpdateUsername(userId int64, newUsername string) error {
-	_, err := db.c.Exec("UPDATE users SET name = ? WHERE id = ?", newUsername, userId)
+func (db *appdbimpl) UpdateUsername(ctx context.Context, userId int64, newUsername string) error {
+	_, err := db.q.ExecContext(ctx, "UPDATE users SET name = ? WHERE id = ?", newUsername, userId)
 	return err
 }
 
-func (db *appdbimpl) UpdateLastAccess(userId int64, conversationId int64) error {
+// UpdateLastAccess marks a conversation as read by a user: every message received until now is considered read, and
+// the explicit "unread" mark is cleared.
+func (db *appdbimpl) UpdateLastAccess(ctx context.Context, userId int64, conversationId int64) error {
 	if userId <= 0 || conversationId <= 0 {
 		return fmt.Errorf("invalid userId (%d) or conversationId (%d)", userId, conversationId)
 	}
 
 	query := `
 		UPDATE conversation_members
-		SET last_access = ?
+		SET last_access = ?, marked_unread = 0
 		WHERE user_id = ? AND conversation_id = ?;
 	`
 
-	timestamp := time.Now()
-	result, err := db.c.Exec(query, timestamp, userId, conversationId)
+	timestamp := globaltime.Now()
+	result, err := db.q.ExecContext(ctx, query, timestamp, userId, conversationId)
 	if err != nil {
 		return fmt.Errorf("failed to update last_access: %w", err)
 	}
@@ -77,45 +83,100 @@ func (db *appdbimpl) UpdateLastAccess(userId int64, conversationId int64) error
 	return nil
 }
 
-// UpdateMessageStatus updates messages status
-func (db *appdbimpl) UpdateMessagesStatus(conversationId int64, messages []Message) ([]Message, error) {
-	// Get the minimum timestamp in conversation_members for the conversation
-	var minTimestampStr string
-	err := db.c.QueryRow(`
-		SELECT CASE
-			WHEN COUNT(last_access) < COUNT(*) THEN '1970-01-01 00:00:00'
-			ELSE MIN(last_access)
-		END
-		FROM conversation_members
-		WHERE conversation_id = ?`, conversationId).Scan(&minTimestampStr)
+// MarkConversationUnread marks a conversation as unread for a user, without changing which messages are read.
+func (db *appdbimpl) MarkConversationUnread(ctx context.Context, userId int64, conversationId int64) error {
+	result, err := db.q.ExecContext(ctx, `
+		UPDATE conversation_members
+		SET marked_unread = 1
+		WHERE user_id = ? AND conversation_id = ?`, userId, conversationId)
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving minimum last_access timestamp: %w", err)
+		return fmt.Errorf("failed to mark conversation as unread: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get affected rows: %w", err)
 	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no rows updated, possibly invalid userId (%d) or conversationId (%d)", userId, conversationId)
+	}
+	return nil
+}
 
-	// Use ParseTimestamp to transform the string in time.Time
-	minTimestamp, err := ParseTimestamp(minTimestampStr)
+// messageStatus returns the status of a message given the read threshold of its conversation.
+func messageStatus(msg Message, readThreshold time.Time) string {
+	if !msg.Timestamp.After(readThreshold) {
+		return "read"
+	}
+	return "received"
+}
+
+// readThreshold returns the time up to which the messages of a conversation are read as seen by viewerId: a message
+// is read when every member has accessed the conversation after it. Members whose read receipts are hidden from the
+// viewer count as not having read it, and viewers hiding their own read receipts from everybody don't see the ones
+// of the others.
+func (db *appdbimpl) readThreshold(ctx context.Context, viewerId int64, conversationId int64) (time.Time, error) {
+	viewerSettings, err := db.GetUserSettings(ctx, viewerId)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Get the minimum last access, among the members, visible to the viewer
+	rows, err := db.q.QueryContext(ctx, `
+		SELECT user_id, last_access
+		FROM conversation_members
+		WHERE conversation_id = ?`, conversationId)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing timestamp: %w", err)
+		return time.Time{}, fmt.Errorf("error retrieving last_access timestamps: %w", err)
+	}
+	type memberAccess struct {
+		userId     int64
+		lastAccess sql.NullTime
+	}
+	var members []memberAccess
+	for rows.Next() {
+		var member memberAccess
+		if err := rows.Scan(&member.userId, &member.lastAccess); err != nil {
+			rows.Close()
+			return time.Time{}, fmt.Errorf("error scanning last_access: %w", err)
+		}
+		members = append(members, member)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("rows iteration error: %w", err)
 	}
 
-	// Update the status of each message
-	for i, msg := range messages {
-		if msg.Timestamp.Before(minTimestamp) || msg.Timestamp.Equal(minTimestamp) {
-			messages[i].Status = "read"
-		} else {
-			messages[i].Status = "received"
+	var minTimestamp time.Time
+	for i, member := range members {
+		visible := member.userId == viewerId || viewerSettings.ReadReceipts != VisibilityNobody
+		if visible && member.userId != viewerId {
+			settings, err := db.GetUserSettings(ctx, member.userId)
+			if err != nil {
+				return time.Time{}, err
+			}
+			visible, err = db.UserAllows(ctx, member.userId, viewerId, settings.ReadReceipts)
+			if err != nil {
+				return time.Time{}, err
+			}
+		}
+		if !visible || !member.lastAccess.Valid {
+			minTimestamp = time.Time{}
+			break
+		}
+		if i == 0 || member.lastAccess.Time.Before(minTimestamp) {
+			minTimestamp = member.lastAccess.Time
 		}
 	}
 
-	return messages, nil
+	return minTimestamp, nil
 }
 
-// UpdateLastMessageId update the filed last_message_id in the conversations table
-func (db *appdbimpl) UpdateLastMessageId(conversationId int64, messageId int64) error {
-	_, err := db.c.Exec(`
+// UpdateLastMessageId update the filed last_message_id in the conversations table; a messageId of 0 sets it to NULL
+func (db *appdbimpl) UpdateLastMessageId(ctx context.Context, conversationId int64, messageId int64) error {
+	_, err := db.q.ExecContext(ctx, `
 		UPDATE conversations
 		SET last_message_id = ?
-		WHERE conversation_id = ?`, messageId, conversationId)
+		WHERE conversation_id = ?`, sql.NullInt64{Int64: messageId, Valid: messageId != 0}, conversationId)
 	if err != nil {
 		return fmt.Errorf("error updating last_message_id: %w", err)
 	}
@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Funzioni per l'ultimo accesso e le impostazioni di privacy degli utenti.
+// La presenza online è tenuta in memoria dal package api, che salva qui l'ultimo accesso quando l'utente va offline.
+// Le impostazioni sono applicate qui per foto, conferme di lettura e autori dei messaggi inoltrati, e negli handler
+// per il resto.
+
+// defaultUserSettings are the settings of the users that never changed them.
+var defaultUserSettings = UserSettings{
+	Photo:        VisibilityEveryone,
+	LastSeen:     VisibilityEveryone,
+	ReadReceipts: VisibilityEveryone,
+	GroupAdd:     VisibilityEveryone,
+	DirectChat:   VisibilityEveryone,
+	Forwards:     VisibilityEveryone,
+}
+
+// UpdateLastSeen stores the last time the user was seen online.
+func (db *appdbimpl) UpdateLastSeen(ctx context.Context, userId int64, lastSeen time.Time) error {
+	if _, err := db.q.ExecContext(ctx, `UPDATE users SET last_seen = ? WHERE id = ?`, lastSeen, userId); err != nil {
+		return fmt.Errorf("error updating last seen: %w", err)
+	}
+	return nil
+}
+
+// GetLastSeen retrieves the last time the user was seen online, or nil if it was never recorded.
+func (db *appdbimpl) GetLastSeen(ctx context.Context, userId int64) (*time.Time, error) {
+	var lastSeen sql.NullTime
+	if err := db.q.QueryRowContext(ctx, `SELECT last_seen FROM users WHERE id = ?`, userId).Scan(&lastSeen); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("user not found: %w", err)
+		}
+		return nil, fmt.Errorf("error retrieving last seen: %w", err)
+	}
+	return nullTimePtr(lastSeen), nil
+}
+
+// GetUserSettings retrieves the privacy settings of a user, or the default ones if they were never changed.
+func (db *appdbimpl) GetUserSettings(ctx context.Context, userId int64) (UserSettings, error) {
+	settings := defaultUserSettings
+	err := db.q.QueryRowContext(ctx, `
+		SELECT photo, last_seen, read_receipts, group_add, direct_chat, forwards
+		FROM user_settings
+		WHERE user_id = ?`, userId).Scan(&settings.Photo, &settings.LastSeen, &settings.ReadReceipts,
+		&settings.GroupAdd, &settings.DirectChat, &settings.Forwards)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return UserSettings{}, fmt.Errorf("error retrieving user settings: %w", err)
+	}
+	return settings, nil
+}
+
+// UpdateUserSettings replaces the privacy settings of a user.
+func (db *appdbimpl) UpdateUserSettings(ctx context.Context, userId int64, settings UserSettings) error {
+	_, err := db.q.ExecContext(ctx, `
+		INSERT INTO user_settings (user_id, photo, last_seen, read_receipts, group_add, direct_chat, forwards)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET
+			photo = excluded.photo,
+			last_seen = excluded.last_seen,
+			read_receipts = excluded.read_receipts,
+			group_add = excluded.group_add,
+			direct_chat = excluded.direct_chat,
+			forwards = excluded.forwards`,
+		userId, settings.Photo, settings.LastSeen, settings.ReadReceipts, settings.GroupAdd, settings.DirectChat,
+		settings.Forwards)
+	if err != nil {
+		return fmt.Errorf("error updating user settings: %w", err)
+	}
+	return nil
+}
+
+// UserAllows reports whether a setting of the user `ownerId` with value `visibility` lets `viewerId` through.
+// Users are always allowed to see their own data.
+func (db *appdbimpl) UserAllows(ctx context.Context, ownerId int64, viewerId int64, visibility string) (bool, error) {
+	if ownerId == viewerId {
+		return true, nil
+	}
+	switch visibility {
+	case VisibilityEveryone:
+		return true, nil
+	case VisibilityGroups:
+		var shared bool
+		err := db.q.QueryRowContext(ctx, `
+			SELECT EXISTS (
+				SELECT 1
+				FROM conversation_members a
+				INNER JOIN conversation_members b ON b.conversation_id = a.conversation_id
+				INNER JOIN conversations c ON c.conversation_id = a.conversation_id
+				WHERE a.user_id = ? AND b.user_id = ? AND c.type = 'group'
+			)`, ownerId, viewerId).Scan(&shared)
+		if err != nil {
+			return false, fmt.Errorf("error checking shared groups: %w", err)
+		}
+		return shared, nil
+	default:
+		return false, nil
+	}
+}
+
+// photoPrivacy hides the photo of the users that don't let the viewer see it. It caches the answer for each user,
+// so it can be used while building a list of messages or conversations.
+type photoPrivacy struct {
+	ctx      context.Context
+	db       *appdbimpl
+	viewerId int64
+	visible  map[int64]bool
+}
+
+func (db *appdbimpl) newPhotoPrivacy(ctx context.Context, viewerId int64) *photoPrivacy {
+	return &photoPrivacy{ctx: ctx, db: db, viewerId: viewerId, visible: make(map[int64]bool)}
+}
+
+// apply removes the photo of `user` if the viewer cannot see it.
+func (p *photoPrivacy) apply(user *User) error {
+	visible, ok := p.visible[user.UserId]
+	if !ok {
+		settings, err := p.db.GetUserSettings(p.ctx, user.UserId)
+		if err != nil {
+			return err
+		}
+		visible, err = p.db.UserAllows(p.ctx, user.UserId, p.viewerId, settings.Photo)
+		if err != nil {
+			return err
+		}
+		p.visible[user.UserId] = visible
+	}
+	if !visible {
+		user.Photo = nil
+	}
+	return nil
+}
+
+// forwardPrivacy hides the original author of the forwarded messages from the viewers that the Forwards setting of
+// the author does not let through. Like photoPrivacy, it caches the answer for each author.
+type forwardPrivacy struct {
+	ctx      context.Context
+	db       *appdbimpl
+	viewerId int64
+	visible  map[int64]bool
+}
+
+func (db *appdbimpl) newForwardPrivacy(ctx context.Context, viewerId int64) *forwardPrivacy {
+	return &forwardPrivacy{ctx: ctx, db: db, viewerId: viewerId, visible: make(map[int64]bool)}
+}
+
+// apply removes the original author from `info` if the viewer cannot see it.
+func (p *forwardPrivacy) apply(info *ForwardInfo) error {
+	if info == nil || info.Sender == nil {
+		return nil
+	}
+	visible, ok := p.visible[info.Sender.UserId]
+	if !ok {
+		settings, err := p.db.GetUserSettings(p.ctx, info.Sender.UserId)
+		if err != nil {
+			return err
+		}
+		visible, err = p.db.UserAllows(p.ctx, info.Sender.UserId, p.viewerId, settings.Forwards)
+		if err != nil {
+			return err
+		}
+		p.visible[info.Sender.UserId] = visible
+	}
+	if !visible {
+		info.Sender = nil
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+Package metrics keeps counters, gauges and histograms in memory and exports them in the Prometheus text format
+(version 0.0.4), so that a Prometheus server can scrape them from the handler returned by Registry.Handler.
+
+Every metric can have labels: its values are kept separately for every combination of label values, passed in the
+same order as the label names when the metric was created.
+
+Example:
+
+	registry := metrics.NewRegistry()
+	requests := registry.NewCounter("http_requests_total", "HTTP requests served.", "method", "code")
+	requests.Inc("GET", "200")
+	http.Handle("/metrics", registry.Handler())
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of a histogram of durations of network requests.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry is a set of metrics exported together.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// metric is a metric of any type, with the values of its series.
+type metric struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	// buckets are the upper bounds of the buckets of a histogram
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series are the values of a metric for a combination of label values. Counters and gauges use value, histograms
+// the others: counts are the observations falling in each bucket, not the cumulative ones.
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// register adds a new metric to r. The name of a metric can't be used twice.
+func (r *Registry) register(name string, help string, kind string, labels []string, buckets []float64) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.metrics {
+		if m.name == name {
+			panic(fmt.Sprintf("metric %q registered twice", name))
+		}
+	}
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// update calls fn on the series of labelValues, creating it if needed.
+func (m *metric) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Sprintf("metric %q has %d labels, got %d values", m.name, len(m.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	fn(s)
+}
+
+// Counter is a value that only goes up, such as the number of requests served.
+type Counter struct {
+	m *metric
+}
+
+// NewCounter registers a new Counter with the label names labels.
+func (r *Registry) NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, "counter", labels, nil)}
+}
+
+// Inc adds one to the counter of labelValues.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which can't be negative, to the counter of labelValues.
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %q can't decrease", c.m.name))
+	}
+	c.m.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Gauge is a value that goes up and down, such as the number of open connections.
+type Gauge struct {
+	m *metric
+}
+
+// NewGauge registers a new Gauge with the label names labels.
+func (r *Registry) NewGauge(name string, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, "gauge", labels, nil)}
+}
+
+// Set sets the gauge of labelValues to value.
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds delta, which can be negative, to the gauge of labelValues.
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.m.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Inc adds one to the gauge of labelValues.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec subtracts one from the gauge of labelValues.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations, such as durations, in buckets, keeping their number and their sum too.
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram registers a new Histogram with the label names labels. buckets are the upper bounds of the buckets,
+// in increasing order; the +Inf one is added by the histogram.
+func (r *Registry) NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of histogram %q are not sorted", name))
+	}
+	return &Histogram{m: r.register(name, help, "histogram", labels, append([]float64(nil), buckets...))}
+}
+
+// Observe adds value to the histogram of labelValues.
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.m.update(labelValues, func(s *series) {
+		if i := sort.SearchFloat64s(h.m.buckets, value); i < len(s.counts) {
+			s.counts[i]++
+		}
+		s.sum += value
+		s.count++
+	})
+}
+
+// Handler returns the handler serving the metrics of r in the Prometheus text format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		buffered := bufio.NewWriter(w)
+		r.write(buffered)
+		_ = buffered.Flush()
+	})
+}
+
+// write writes all the metrics of r, in the order they were registered, with their series sorted by label values.
+func (r *Registry) write(w *bufio.Writer) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.mu.Lock()
+		keys := make([]string, 0, len(m.series))
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, escape(m.help, false))
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", m.name, labels(m.labels, s.labelValues, ""), formatFloat(s.value))
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range m.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(m.labels, s.labelValues, formatFloat(bound)), cumulative)
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labels(m.labels, s.labelValues, "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labels(m.labels, s.labelValues, ""), formatFloat(s.sum))
+			fmt.Fprintf(w, "%s_count%s %d\n", m.name, labels(m.labels, s.labelValues, ""), s.count)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// labels formats the labels of a series, such as {method="GET",code="200"}, adding the "le" label of a bucket of a
+// histogram if le is not empty.
+func labels(names []string, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escape(values[i], true)+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes the backslashes and the newlines of a help text, and the double quotes too of a label value.
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+// formatFloat formats a value as Prometheus expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -47,6 +47,54 @@ const setAuthHeader = (userId) => {
 	}
   };
   
+  // Mark a conversation as read (all the received messages become read)
+  export const markConversationRead = async (userId, conversationId) => {
+	setAuthHeader(userId);
+	try {
+	  await instance.put(`users/${userId}/conversations/${conversationId}/read`);
+	} catch (error) {
+	  console.error('Error marking conversation as read:', error);
+	  throw error;
+	}
+  };
+
+  // Mark a conversation as unread
+  export const markConversationUnread = async (userId, conversationId) => {
+	setAuthHeader(userId);
+	try {
+	  await instance.delete(`users/${userId}/conversations/${conversationId}/read`);
+	} catch (error) {
+	  console.error('Error marking conversation as unread:', error);
+	  throw error;
+	}
+  };
+
+  // Save the draft of a conversation (an empty text deletes it)
+  export const saveDraft = async (userId, conversationId, text) => {
+	setAuthHeader(userId);
+	try {
+	  await instance.put(`users/${userId}/conversations/${conversationId}/draft`, { text });
+	} catch (error) {
+	  console.error('Error saving draft:', error);
+	  throw error;
+	}
+  };
+
+  // Get the draft of a conversation, or null if there is none
+  export const getDraft = async (userId, conversationId) => {
+	setAuthHeader(userId);
+	try {
+	  const response = await instance.get(`users/${userId}/conversations/${conversationId}/draft`);
+	  return response.data;
+	} catch (error) {
+	  if (error.response && error.response.status === 404) {
+		return null;
+	  }
+	  console.error('Error getting draft:', error);
+	  throw error;
+	}
+  };
+  
   	// Send a message (text or photo) to a conversation
 	export const sendMessage = async (userId, conversationId, messageContent) => {
 		setAuthHeader(userId);